@@ -4,16 +4,71 @@
 :- dynamic(presente/2).
 :- dynamic(alergia/1).
 :- dynamic(cronica/1).
+:- dynamic(ausente/1).
+:- dynamic(edad/1).
+:- dynamic(sexo/1).
+:- dynamic(embarazo/0).
+:- dynamic(peso_kg/1).
+:- dynamic(duracion/2).
+:- dynamic(inicio/2).
+:- dynamic(toma/1).
+:- dynamic(enf_sintoma/3).
 :- dynamic(enf_contra_medicamento/2).
+:- dynamic(contraindicado/2).
+:- dynamic(penalizacion_ausente/2).
+:- dynamic(prevalencia/2).
+:- dynamic(sensibilidad/3).
+:- dynamic(enf_bandera_roja/3).
+:- dynamic(bandera_roja/4).
+:- dynamic(enf_edad/3).
+:- dynamic(edad_minima/2).
+:- dynamic(contra_embarazo/1).
+:- dynamic(enf_duracion/3).
+:- dynamic(enf_inicio/2).
+:- dynamic(interaccion/3).
+:- dynamic(posologia/10).
+:- dynamic(linea_tratamiento/4).
+:- dynamic(sinonimo/2).
+:- dynamic(sintoma_padre/2).
+:- dynamic(texto_sintoma/4).
+:- dynamic(sistema_sintoma/2).
+:- dynamic(escala_sintoma/2).
+:- dynamic(enf_excluye/2).
+:- dynamic(enf_requerido/2).
 
 % Hechos estáticos vienen del .pl de Admin:
 %   sintoma(S).
+%   sinonimo(S, "texto").                % opcional, cómo lo describe el paciente (búsqueda)
+%   sintoma_padre(Hijo, Padre).          % opcional, Hijo es un caso más específico de Padre
+%   texto_sintoma(S, Lang, "Etiqueta", "Descripción"). % opcional, catálogo público (Lang = es | en | ...)
+%   sistema_sintoma(S, Sistema).         % opcional, agrupa el catálogo (sin él: general)
+%   escala_sintoma(S, [leve, moderado]). % opcional, severidades que ofrece el formulario
 %   enfermedad(Id, "Nombre", Sistema, Tipo).
-%   enf_sintoma(Enf, Sintoma).
+%   enf_sintoma(Enf, Sintoma, Peso).     % Peso 1..5 (cardinal > inespecífico)
 %   medicamento(Med).
 %   trata(Med, Enf).
+%   linea_tratamiento(Med, Enf, Linea, Rank). % opcional, 1 = primera línea; Rank menor = preferido
 %   contraindicado(Med, Cond).
 %   enf_contra_medicamento(Enf, Med).   % opcional
+%   enf_excluye(Enf, S).                 % opcional, S presente hace muy improbable Enf (se descarta)
+%   enf_requerido(Enf, S).               % opcional, síntoma cardinal: sin él la afinidad no supera tope_requerido/1
+%   penalizacion_ausente(Enf, Puntos).   % opcional, por síntoma negado
+%   prevalencia(Enf, P).                 % opcional, prior 0..1 (modo bayes)
+%   sensibilidad(Enf, S, X).             % opcional, P(S|Enf) (modo bayes)
+%   enf_bandera_roja(Enf, S, SevMin).    % opcional, bandera roja propia de Enf
+%   bandera_roja(Id, [S1,...], SevMin, "Nivel").  % todos presentes con peso >= SevMin
+%   enf_edad(Enf, Min, Max).             % opcional, rango de edad típico (años)
+%   edad_minima(Med, Años).              % opcional, no usar por debajo de esa edad
+%   contra_embarazo(Med).                % opcional, contraindicado en embarazo
+%   enf_duracion(Enf, MinDias, MaxDias). % opcional, duración típica de los síntomas
+%   enf_inicio(Enf, subito | gradual).   % opcional, forma de inicio típica
+%   interaccion(Med1, Med2, Sev).        % opcional, Sev = leve | moderada | grave (simétrica)
+%   posologia(Med, EdadMin, EdadMax, PesoMin, PesoMax, Dosis, Unidad, Via, CadaH, MaxDia).
+%       % opcional; Unidad = mg | mg_kg; Max 0 = sin tope; MaxDia en mg
+%
+% Hechos de sesión (paciente): presente/2, ausente/1, alergia/1, cronica/1,
+%   edad/1, sexo/1 (m | f), embarazo/0, peso_kg/1,
+%   duracion(S, Dias), inicio(S, subito | gradual), toma(Med)
 
 % -------------------------------------------------------------------
 %            Severidad normalizada y utilidades básicas
@@ -22,12 +77,27 @@ peso(leve, 1).
 peso(moderado, 2).
 peso(severo, 3).
 
-% presentepeso(S,P): normaliza presente(S, X) a número
-presentepeso(S, P) :- presente(S, P), number(P), !.
-presentepeso(S, P) :- presente(S, Sev), atom(Sev), peso(Sev, P).
+% presentepeso(S,P): normaliza presente(S, X) a número.
+% El corte va en peso_norm/2 para que presentepeso(_, P) recorra todos los síntomas.
+presentepeso(S, P) :- presente(S, X), peso_norm(X, P).
+
+peso_norm(X, X) :- number(X), !.
+peso_norm(Sev, P) :- atom(Sev), peso(Sev, P).
 
 peso_max_por_sintoma(3).
 
+% ancestro(S, A): A es padre, abuelo, ... de S según sintoma_padre/2.
+% Admin valida que no haya ciclos ni más niveles que tope_jerarquia/1;
+% el tope cubre además KBs importadas.
+tope_jerarquia(9).
+ancestro(S, A) :- tope_jerarquia(N), ancestro_(S, A, N).
+ancestro_(S, A, N) :- N > 0, sintoma_padre(S, A).
+ancestro_(S, A, N) :- N > 1, N1 is N - 1, sintoma_padre(S, P), ancestro_(P, A, N1).
+
+% presente_ev(S, P): S está presente, o lo está un descendiente (evidencia de S)
+presente_ev(S, P) :- presentepeso(S, P).
+presente_ev(S, P) :- presentepeso(D, P), ancestro(D, S).
+
 member(X, [X|_]).
 member(X, [_|T]) :- member(X, T).
 
@@ -37,47 +107,194 @@ sum_pairs([(_,P)|T], S) :- sum_pairs(T, S1), S is S1 + P.
 % -------------------------------------------------------------------
 %                     Afinidad por enfermedad
 % -------------------------------------------------------------------
+% enf_sintoma/2 se deriva de los vínculos ponderados. Una KB antigua que
+% declare enf_sintoma/2 reemplaza esta regla y sus vínculos pesan 1.
+enf_sintoma(Enf, S) :- enf_sintoma(Enf, S, _).
+
+% Peso del vínculo Enf-S (1 si la KB no lo pondera)
+peso_vinculo(Enf, S, W) :- enf_sintoma(Enf, S, W), number(W), !.
+peso_vinculo(_, _, 1).
+
+sum_list_([], 0).
+sum_list_([X|T], S) :- sum_list_(T, S1), S is S1 + X.
+
 % Lista única de síntomas requeridos por Enf
 reqs_enf(Enf, Reqs) :-
     ( setof(S, enf_sintoma(Enf, S), S0) -> true ; S0 = [] ),
     sort(S0, Reqs).
 
+% Máximo = suma de pesos de los vínculos x severidad máxima
 max_puntaje_enf(Enf, Max) :-
     reqs_enf(Enf, Reqs),
-    length(Reqs, N),
+    findall(W, ( member(S, Reqs), peso_vinculo(Enf, S, W) ), Ws),
+    sum_list_(Ws, SW),
     peso_max_por_sintoma(PM),
-    Max is N * PM.
+    Max is SW * PM.
 
 % --- helpers para tomar el máximo de una lista ordenada ---
 last_([X], X).
 last_([_|T], X) :- last_(T, X).
 
-% máximo peso observado para un síntoma presente (usando presentepeso/2)
+% máximo peso observado para un síntoma presente o para sus descendientes (presente_ev/2)
 max_peso_sintoma(S, P) :-
-    setof(W, presentepeso(S, W), Ws),   % Ws queda ordenada asc
+    setof(W, presente_ev(S, W), Ws),    % Ws queda ordenada asc
     last_(Ws, P).
 
 % Puntaje real con los presentes normalizados (usa el peso máximo por síntoma)
-% Matched: solo la lista de síntomas que contaron
+% ponderado por el peso del vínculo. Matched: solo los síntomas que contaron
 puntaje_enf(Enf, Puntaje, Matched) :-
     reqs_enf(Enf, Reqs),
-    findall((S,P),
+    findall((S,PW),
         ( member(S, Reqs),
-          max_peso_sintoma(S, P)        % solo entra si S está presente
+          max_peso_sintoma(S, P),       % solo entra si S está presente
+          peso_vinculo(Enf, S, W),
+          PW is P * W
         ),
         Pairs0),
     sort(Pairs0, Pairs),                % dedup por si repitiera (S,P)
     sum_pairs(Pairs, Puntaje),
     findall(S, member((S,_), Pairs), Matched).
 
-% Afinidad en porcentaje (0..100)
+% Síntomas de Enf que el paciente niega explícitamente (ausente/1) y sin descendiente presente
+negados_enf(Enf, Negados) :-
+    reqs_enf(Enf, Reqs),
+    findall(S, ( member(S, Reqs), ausente(S), \+ presente_ev(S, _) ), Negados).
+
+% Puntos de afinidad que resta cada síntoma negado (0 si la KB no lo define)
+penalizacion_enf(Enf, Pen) :- penalizacion_ausente(Enf, Pen), !.
+penalizacion_enf(_, 0).
+
+% Penalización total: Pen x peso de cada vínculo negado
+penalizacion_total(Enf, Total) :-
+    negados_enf(Enf, Negados),
+    penalizacion_enf(Enf, Pen),
+    findall(PW, ( member(S, Negados), peso_vinculo(Enf, S, W), PW is Pen * W ), PWs),
+    sum_list_(PWs, Total).
+
+% --- Ajuste temporal: duración e inicio de los síntomas presentes de Enf ---
+bono_temporal(5).       % puntos si la duración/inicio encaja con la enfermedad
+castigo_temporal(10).   % puntos que resta si no encaja
+
+% duracion_ev/inicio_ev: la del síntoma presente o, si no la tiene, la del primer
+% descendiente presente que la informe (misma evidencia que presente_ev/2)
+duracion_ev(S, D) :- presente(S, _), duracion(S, D).
+duracion_ev(S, D) :- presente(C, _), ancestro(C, S), duracion(C, D).
+inicio_ev(S, I) :- presente(S, _), inicio(S, I).
+inicio_ev(S, I) :- presente(C, _), ancestro(C, S), inicio(C, I).
+
+% ajuste_sintoma(Enf, S, Tipo, Pts): Tipo = duracion | inicio; Pts con signo
+ajuste_sintoma(Enf, S, duracion, Pts) :-
+    enf_duracion(Enf, Min, Max),
+    reqs_enf(Enf, Reqs), member(S, Reqs),
+    once(duracion_ev(S, D)),
+    ( D >= Min, D =< Max -> bono_temporal(Pts) ; castigo_temporal(C), Pts is -C ).
+ajuste_sintoma(Enf, S, inicio, Pts) :-
+    enf_inicio(Enf, I0),
+    reqs_enf(Enf, Reqs), member(S, Reqs),
+    once(inicio_ev(S, I)),
+    ( I == I0 -> bono_temporal(Pts) ; castigo_temporal(C), Pts is -C ).
+
+ajuste_temporal(Enf, Total) :-
+    findall(P, ajuste_sintoma(Enf, _, _, P), Ps),
+    sum_list_(Ps, Total).
+
+% Afinidad en porcentaje (0..100), descontando los síntomas negados y
+% sumando el ajuste temporal (solo si algún síntoma coincidió)
 afinidad(Enf, Afinidad, Matched) :-
     max_puntaje_enf(Enf, Max),
     ( Max =:= 0 -> Afinidad = 0, Matched = []
     ; puntaje_enf(Enf, Puntaje, Matched),
-      Afinidad is round(Puntaje * 100 / Max)
+      penalizacion_total(Enf, Pen),
+      ajuste_temporal(Enf, Aj),
+      A0 is round(Puntaje * 100 / Max) - Pen + Aj,
+      ( A0 < 0 -> A1 = 0 ; A0 > 100 -> A1 = 100 ; A1 = A0 ),
+      tope_afinidad(Enf, A1, Afinidad)
     ).
 
+% --- Síntomas requeridos (cardinales) ---
+tope_requerido(25).     % afinidad máxima si falta un síntoma requerido
+
+% falta_requerido(Enf, S): S es requerido por Enf y no hay evidencia de él (ni de un descendiente)
+falta_requerido(Enf, S) :- enf_requerido(Enf, S), \+ presente_ev(S, _).
+
+tope_afinidad(Enf, A, T) :- falta_requerido(Enf, _), tope_requerido(T), A > T, !.
+tope_afinidad(_, A, A).
+
+% -------------------------------------------------------------------
+%             Filtros previos a la evaluación (/api/diagnose)
+% -------------------------------------------------------------------
+% enf_candidata(Enf, Nombre, Sistemas, Tipos): enfermedades a evaluar ([] = sin filtro)
+enf_candidata(Enf, Nombre, Sistemas, Tipos) :-
+    enfermedad(Enf, Nombre, Sis, Tipo),
+    en_filtro(Sis, Sistemas),
+    en_filtro(Tipo, Tipos).
+
+en_filtro(_, []) :- !.
+en_filtro(X, L) :- member(X, L), !.
+
+% con_coincidencia(Enf): al menos un síntoma de Enf (o un descendiente) está presente
+con_coincidencia(Enf) :- enf_sintoma(Enf, S), presente_ev(S, _), !.
+
+% excluida(Enf, S): S (o un descendiente) está presente y descarta Enf
+excluida(Enf, S) :- enf_excluye(Enf, S), once(presente_ev(S, _)).
+
+% -------------------------------------------------------------------
+%              Modo probabilístico (bayes ingenuo, ?mode=bayes)
+% -------------------------------------------------------------------
+% Valores por defecto si la KB no los define
+prevalencia_def(0.01).
+sensibilidad_def(0.7).
+fuga(0.05).          % P(S | Enf) de un síntoma presente que Enf no explica
+
+factor_edad(0.1).    % prior x factor si la edad del paciente cae fuera de enf_edad/3
+
+prior_base(Enf, P) :- prevalencia(Enf, P), !.
+prior_base(_, P) :- prevalencia_def(P).
+
+prior_enf(Enf, P) :-
+    prior_base(Enf, P0),
+    ( fuera_rango_edad(Enf, _, _) -> factor_edad(F), P is P0 * F ; P = P0 ).
+
+sens_vinculo(Enf, S, X) :- sensibilidad(Enf, S, X), !.
+sens_vinculo(_, _, X) :- sensibilidad_def(X).
+
+prod_list_([], 1).
+prod_list_([X|T], P) :- prod_list_(T, P1), P is P1 * X.
+
+% factor_bayes(Enf, S, F): verosimilitud aportada por cada síntoma con evidencia
+% (presente_ev/2: informado o con un descendiente presente)
+%   con evidencia y vinculado -> sensibilidad
+%   informado, no vinculado y sin ancestro vinculado -> fuga (la evidencia se cuenta
+%   una sola vez: ni el ancestro ni el descendiente suman otra fuga)
+%   negado sin evidencia y vinculado -> 1 - sensibilidad
+factor_bayes(Enf, S, F) :-
+    findall(S0, presente_ev(S0, _), Ps0), sort(Ps0, Ps),
+    member(S, Ps),
+    ( enf_sintoma(Enf, S) -> sens_vinculo(Enf, S, F)
+    ; presente(S, _), \+ ( ancestro(S, A), enf_sintoma(Enf, A) ) -> fuga(F) ).
+factor_bayes(Enf, S, F) :-
+    findall(S0, ausente(S0), As0), sort(As0, As),
+    member(S, As),
+    \+ presente_ev(S, _),
+    enf_sintoma(Enf, S),
+    sens_vinculo(Enf, S, X),
+    F is 1 - X.
+
+% prob_sintoma(Enf, S, X): P(S presente | Enf) para elegir la siguiente pregunta
+prob_sintoma(Enf, S, X) :- enf_sintoma(Enf, S), !, sens_vinculo(Enf, S, X).
+prob_sintoma(_, _, X) :- fuga(X).
+
+% sin_preguntar(S): síntoma de la KB que el paciente no afirmó (ni por un descendiente) ni negó
+sin_preguntar(S) :- sintoma(S), \+ presente_ev(S, _), \+ ausente(S).
+
+% Puntaje no normalizado: prior x producto de factores (Go normaliza entre todas las
+% enfermedades de la KB, antes de filtrar)
+puntaje_bayes(Enf, Score) :-
+    prior_enf(Enf, Pr),
+    findall(F, factor_bayes(Enf, _, F), Fs),
+    prod_list_(Fs, L),
+    Score is float(Pr * L).
+
 % -------------------------------------------------------------------
 %                            Urgencia
 % -------------------------------------------------------------------
@@ -85,21 +302,93 @@ has_severe :- presentepeso(_, W), W >= 3.
 
 symptom_count(N) :- findall(1, presentepeso(_, _), L), length(L, N).
 
-% Banderas rojas absolutas
-urgencia("Atención prioritaria") :-
-    ( presentepeso(disnea, P), P >= 2
-    ; presentepeso(dolor_pecho, _) ), !.
+% Niveles de urgencia en orden de prioridad (el primero es el más urgente)
+nivel_urgencia("Atención prioritaria", 3).
+nivel_urgencia("Consulta recomendada", 2).
+nivel_urgencia("Observación recomendada", 1).
+
+min_list_([X], X).
+min_list_([X|T], M) :- min_list_(T, M1), ( X < M1 -> M = X ; M = M1 ).
+
+% bandera_activa(Ss, Min, P): todos los síntomas de Ss presentes con peso >= Min;
+% P = el menor peso observado entre ellos
+bandera_activa(Ss, Min, P) :-
+    Ss = [_|_],
+    findall(W, ( member(S, Ss), max_peso_sintoma(S, W), W >= Min ), Ws),
+    length(Ss, N), length(Ws, N),
+    min_list_(Ws, P).
+
+% urgencia_motivo(U, Regla, S, P): motivo de la urgencia global del paciente
+% (triage) y su evidencia. Se recorren los niveles de mayor a menor y, dentro
+% de cada nivel, las cláusulas de motivo_nivel/4 en orden; urgencia/1 toma el primero.
+urgencia_motivo(U, Regla, S, P) :-
+    nivel_urgencia(U, _),
+    motivo_nivel(U, Regla, S, P).
+
+% Banderas rojas de la KB (S = id de la bandera)
+motivo_nivel(U, bandera_roja, Id, P) :-
+    bandera_roja(Id, Ss, Min, U),
+    bandera_activa(Ss, Min, P).
 
-% Si hay cualquier síntoma severo (aunque no sea disnea/dolor_pecho)
-urgencia("Consulta recomendada") :-
-    has_severe, !.
+% Si hay cualquier síntoma severo
+motivo_nivel("Consulta recomendada", sintoma_severo, S, W) :-
+    presentepeso(S, W), W >= 3.
 
 % Si no hay severos pero hay 3+ síntomas presentes
-urgencia("Consulta recomendada") :-
-    symptom_count(N), N >= 3, !.
+motivo_nivel("Consulta recomendada", conteo_sintomas, sintomas, N) :-
+    symptom_count(N), N >= 3.
 
 % Caso base
-urgencia("Observación recomendada").
+motivo_nivel("Observación recomendada", caso_base, ninguno, 0).
+
+urgencia(U) :- urgencia_motivo(U, _, _, _), !.
+
+% traza_bandera(Id, S, Min, P): síntomas que activaron la bandera roja Id
+traza_bandera(Id, S, Min, P) :-
+    bandera_roja(Id, Ss, Min, _),
+    member(S, Ss),
+    max_peso_sintoma(S, P).
+
+% --- Urgencia por enfermedad: solo cuentan los síntomas propios de Enf ---
+% Tipos que piden valoración médica en cuanto la enfermedad tiene coincidencias
+tipo_consulta(bacteriano).
+tipo_consulta(cronico).
+
+coincidencias_enf(Enf, N) :-
+    findall(S, ( enf_sintoma(Enf, S), presente_ev(S, _) ), L0),
+    sort(L0, L),
+    length(L, N).
+
+% urgencia_enf_motivo(Enf, U, Regla, S, P): mismo recorrido por niveles que urgencia_motivo/4
+urgencia_enf_motivo(Enf, U, Regla, S, P) :-
+    nivel_urgencia(U, _),
+    motivo_enf_nivel(Enf, U, Regla, S, P).
+
+% Bandera roja declarada para la enfermedad en la KB
+motivo_enf_nivel(Enf, "Atención prioritaria", bandera_roja_enf, S, P) :-
+    enf_bandera_roja(Enf, S, Min),
+    max_peso_sintoma(S, P), P >= Min.
+% Banderas rojas de la KB que involucran algún síntoma de la enfermedad
+motivo_enf_nivel(Enf, U, bandera_roja, Id, P) :-
+    bandera_roja(Id, Ss, Min, U),
+    once(( member(S, Ss), enf_sintoma(Enf, S) )),
+    bandera_activa(Ss, Min, P).
+% Síntoma severo propio de la enfermedad
+motivo_enf_nivel(Enf, "Consulta recomendada", sintoma_severo, S, W) :-
+    enf_sintoma(Enf, S),
+    max_peso_sintoma(S, W), W >= 3.
+% Tipo de enfermedad (bacteriana, crónica reagudizada) con al menos una coincidencia
+motivo_enf_nivel(Enf, "Consulta recomendada", tipo_enfermedad, Tipo, N) :-
+    enfermedad(Enf, _, _, Tipo),
+    tipo_consulta(Tipo),
+    coincidencias_enf(Enf, N), N >= 1.
+% 3+ síntomas de la enfermedad presentes
+motivo_enf_nivel(Enf, "Consulta recomendada", conteo_sintomas, sintomas, N) :-
+    coincidencias_enf(Enf, N), N >= 3.
+% Caso base
+motivo_enf_nivel(_, "Observación recomendada", caso_base, ninguno, 0).
+
+urgencia(Enf, U) :- urgencia_enf_motivo(Enf, U, _, _, _), !.
 
 % -------------------------------------------------------------------
 %                    Medicamento seguro / bloqueos
@@ -107,13 +396,116 @@ urgencia("Observación recomendada").
 bloqueado_por_alergia(Med) :- alergia(Cond),  contraindicado(Med, Cond).
 bloqueado_por_cronica(Med) :- cronica(Cond),  contraindicado(Med, Cond).
 bloqueado_por_enf(Enf, Med) :- enf_contra_medicamento(Enf, Med).
+bloqueado_por_edad(Med) :- edad(E), edad_minima(Med, Min), E < Min.
+bloqueado_por_embarazo(Med) :- embarazo, contra_embarazo(Med).
+
+% interaccion/3 se declara una vez por par; se consulta en ambos sentidos
+interactua(A, B, Sev) :- interaccion(A, B, Sev).
+interactua(A, B, Sev) :- interaccion(B, A, Sev), A \== B.
+
+% Solo la interacción grave con un medicamento que el paciente ya toma bloquea
+bloqueado_por_interaccion(Med) :- toma(T), interactua(Med, T, grave).
 
 % Trata y no está bloqueado
 medicamento_seguro(Enf, Med) :-
     trata(Med, Enf),
     \+ bloqueado_por_alergia(Med),
     \+ bloqueado_por_cronica(Med),
-    \+ bloqueado_por_enf(Enf, Med).
+    \+ bloqueado_por_enf(Enf, Med),
+    \+ bloqueado_por_edad(Med),
+    \+ bloqueado_por_embarazo(Med),
+    \+ bloqueado_por_interaccion(Med).
+
+% linea(Med, Enf, Linea, Rank): línea de tratamiento declarada (0, 0 = sin declarar)
+linea(Med, Enf, L, R) :- linea_tratamiento(Med, Enf, L, R), !.
+linea(_, _, 0, 0).
+
+% alerta_interaccion(Enf, Med, T, Sev): medicamento seguro que interactúa (leve/moderada)
+% con T, que el paciente ya toma; no bloquea pero se advierte
+alerta_interaccion(Enf, Med, T, Sev) :-
+    medicamento_seguro(Enf, Med),
+    toma(T), interactua(Med, T, Sev),
+    Sev \== grave.
+
+% motivo_bloqueo(Enf, Med, Tipo, Cond): hecho que descartó a Med para Enf.
+%   Tipo = alergia | cronica  -> alergia(Cond)/cronica(Cond) + contraindicado(Med, Cond)
+%   Tipo = enfermedad         -> enf_contra_medicamento(Enf, Med)
+%   Tipo = edad               -> edad(E) < edad_minima(Med, Cond)   (Cond como átomo)
+%   Tipo = embarazo           -> embarazo + contra_embarazo(Med)
+%   Tipo = interaccion        -> toma(Cond) + interaccion(Med, Cond, grave)
+motivo_bloqueo(Enf, Med, alergia, Cond) :-
+    trata(Med, Enf), alergia(Cond), contraindicado(Med, Cond).
+motivo_bloqueo(Enf, Med, cronica, Cond) :-
+    trata(Med, Enf), cronica(Cond), contraindicado(Med, Cond).
+motivo_bloqueo(Enf, Med, enfermedad, Enf) :-
+    trata(Med, Enf), enf_contra_medicamento(Enf, Med).
+motivo_bloqueo(Enf, Med, edad, Cond) :-
+    trata(Med, Enf), bloqueado_por_edad(Med),
+    edad_minima(Med, Min), number_codes(Min, Cs), atom_codes(Cond, Cs).
+motivo_bloqueo(Enf, Med, embarazo, embarazo) :-
+    trata(Med, Enf), bloqueado_por_embarazo(Med).
+motivo_bloqueo(Enf, Med, interaccion, T) :-
+    trata(Med, Enf), toma(T), interactua(Med, T, grave).
+
+% -------------------------------------------------------------------
+%                      Posología del medicamento
+% -------------------------------------------------------------------
+dato_edad(E) :- edad(E), !.
+dato_edad(none).
+dato_peso(P) :- peso_kg(P), !.
+dato_peso(none).
+
+% en_banda(Min, Max, X): 0/0 = banda sin restricción (aplica aunque no haya dato)
+en_banda(Min, Max, _) :- Min =:= 0, Max =:= 0, !.
+en_banda(Min, Max, X) :- number(X), X >= Min, ( Max =:= 0 ; X =< Max ), !.
+
+dosis_por_toma(mg, D, _, D).
+dosis_por_toma(mg_kg, D, P, X) :- number(P), X is D * P.
+
+% dosis(Med, Dosis, Via, CadaH, MaxDia, Tope): primera banda de posologia/10 que
+% encaja con edad/peso; la dosis (mg) se recorta para no superar MaxDia (Tope = si)
+dosis(Med, Dosis, Via, CadaH, MaxDia, Tope) :-
+    dato_edad(E), dato_peso(P),
+    posologia(Med, EMin, EMax, PMin, PMax, D, Unidad, Via, CadaH, MaxDia),
+    en_banda(EMin, EMax, E),
+    en_banda(PMin, PMax, P),
+    dosis_por_toma(Unidad, D, P, D0),
+    Tomas is 24 / CadaH,
+    ( D0 * Tomas > MaxDia -> Dosis is MaxDia / Tomas, Tope = si ; Dosis = D0, Tope = no ),
+    !.
+
+% -------------------------------------------------------------------
+%                    Datos demográficos del paciente
+% -------------------------------------------------------------------
+% fuera_rango_edad(Enf, Min, Max): la edad del paciente no está en enf_edad/3
+fuera_rango_edad(Enf, Min, Max) :-
+    edad(E), enf_edad(Enf, Min, Max),
+    ( E < Min ; E > Max ), !.
+
+% -------------------------------------------------------------------
+%                 Trazas para el modo explicación
+% -------------------------------------------------------------------
+% traza_puntaje(Enf, S, P, W): enf_sintoma(Enf, S, W) + presente_ev(S, P) que sumaron
+traza_puntaje(Enf, S, P, W) :-
+    reqs_enf(Enf, Reqs),
+    member(S, Reqs),
+    max_peso_sintoma(S, P),
+    peso_vinculo(Enf, S, W).
+
+% traza_evidencia(S, D): síntoma informado D que aportó el peso de S (D = S o un descendiente)
+traza_evidencia(S, D) :-
+    max_peso_sintoma(S, P),
+    ( presentepeso(S, P) -> D = S ; presentepeso(D, P), ancestro(D, S) ), !.
+
+% traza_temporal(Enf, S, Tipo, Pts): ajuste por duración/inicio de cada síntoma
+traza_temporal(Enf, S, Tipo, Pts) :- ajuste_sintoma(Enf, S, Tipo, Pts).
+
+% traza_negado(Enf, S, Pen, W): síntoma negado que restó Pen x W puntos
+traza_negado(Enf, S, Pen, W) :-
+    negados_enf(Enf, Negados),
+    member(S, Negados),
+    penalizacion_enf(Enf, Pen),
+    peso_vinculo(Enf, S, W).
 
 % -------------------------------------------------------------------
 %                 (Opcional) Detalle para depurar
@@ -5,11 +5,57 @@ sintoma(cefalea).
 sintoma(disnea).
 sintoma(dolor_garganta).
 sintoma(dolor_pecho).
+sintoma(dolor_pecho_esfuerzo).
 sintoma(fiebre).
 sintoma(nausea).
 sintoma(pirosis).
 sintoma(regurgitacion).
 sintoma(tos).
+sinonimo(cefalea, "dolor de cabeza").
+sinonimo(cefalea, "me duele la cabeza").
+sinonimo(disnea, "falta de aire").
+sinonimo(disnea, "me cuesta respirar").
+sinonimo(dolor_garganta, "me duele la garganta").
+sinonimo(dolor_garganta, "garganta irritada").
+sinonimo(dolor_pecho, "me duele el pecho").
+sinonimo(dolor_pecho_esfuerzo, "dolor de pecho al hacer esfuerzo").
+sinonimo(fiebre, "fiebre alta").
+sinonimo(fiebre, "calentura").
+sinonimo(nausea, "ganas de vomitar").
+sinonimo(pirosis, "acidez").
+sinonimo(pirosis, "ardor de estómago").
+sinonimo(regurgitacion, "se me regresa la comida").
+sintoma_padre(dolor_pecho_esfuerzo, dolor_pecho).
+texto_sintoma(cefalea, es, "Dolor de cabeza", "").
+texto_sintoma(cefalea, en, "Headache", "").
+texto_sintoma(disnea, es, "Falta de aire", "Dificultad para respirar.").
+texto_sintoma(disnea, en, "Shortness of breath", "Difficulty breathing.").
+texto_sintoma(dolor_garganta, es, "Dolor de garganta", "").
+texto_sintoma(dolor_garganta, en, "Sore throat", "").
+texto_sintoma(dolor_pecho, es, "Dolor de pecho", "").
+texto_sintoma(dolor_pecho, en, "Chest pain", "").
+texto_sintoma(dolor_pecho_esfuerzo, es, "Dolor de pecho al esfuerzo", "Aparece al caminar, subir escaleras o hacer ejercicio.").
+texto_sintoma(dolor_pecho_esfuerzo, en, "Chest pain on exertion", "Comes on when walking, climbing stairs or exercising.").
+texto_sintoma(fiebre, es, "Fiebre", "Temperatura de 38 °C o más.").
+texto_sintoma(fiebre, en, "Fever", "Temperature of 38 °C or higher.").
+texto_sintoma(nausea, es, "Náusea", "").
+texto_sintoma(nausea, en, "Nausea", "").
+texto_sintoma(pirosis, es, "Acidez", "Ardor detrás del esternón.").
+texto_sintoma(pirosis, en, "Heartburn", "Burning behind the breastbone.").
+texto_sintoma(regurgitacion, es, "Regurgitación", "").
+texto_sintoma(regurgitacion, en, "Regurgitation", "").
+texto_sintoma(tos, es, "Tos", "").
+texto_sintoma(tos, en, "Cough", "").
+sistema_sintoma(cefalea, neurologico).
+sistema_sintoma(disnea, respiratorio).
+sistema_sintoma(dolor_garganta, respiratorio).
+sistema_sintoma(dolor_pecho, cardiovascular).
+sistema_sintoma(dolor_pecho_esfuerzo, cardiovascular).
+sistema_sintoma(nausea, digestivo).
+sistema_sintoma(pirosis, digestivo).
+sistema_sintoma(regurgitacion, digestivo).
+sistema_sintoma(tos, respiratorio).
+escala_sintoma(nausea, [leve,moderado]).
 
 enfermedad(asma, "Asma", respiratorio, cronico).
 enfermedad(gripe, "Gripe", respiratorio, viral).
@@ -17,15 +63,16 @@ enfermedad(reflujo, "Enfermedad por reflujo gastroesofágico", digestivo, cronic
 descripcion_enf(asma, "Obstrucción reversible de la vía aérea.").
 descripcion_enf(gripe, "Infección respiratoria alta.").
 descripcion_enf(reflujo, "Irritación por ácido.").
-enf_sintoma(asma, disnea).
-enf_sintoma(asma, tos).
-enf_sintoma(gripe, fiebre).
-enf_sintoma(gripe, tos).
-enf_sintoma(gripe, dolor_garganta).
-enf_sintoma(reflujo, pirosis).
-enf_sintoma(reflujo, regurgitacion).
+enf_sintoma(asma, disnea, 1).
+enf_sintoma(asma, tos, 1).
+enf_sintoma(gripe, fiebre, 1).
+enf_sintoma(gripe, tos, 1).
+enf_sintoma(gripe, dolor_garganta, 1).
+enf_sintoma(reflujo, pirosis, 1).
+enf_sintoma(reflujo, regurgitacion, 1).
 enf_contra_medicamento(gripe, ibuprofeno).
 enf_contra_medicamento(reflujo, aines).
+enf_requerido(reflujo, pirosis).
 
 medicamento(aines).
 medicamento(ibuprofeno).
@@ -39,3 +86,6 @@ trata(paracetamol, gripe).
 trata(salbutamol, asma).
 contraindicado(omeprazol, prolongacion_qt).
 contraindicado(paracetamol, alergia_paracetamol).
+
+bandera_roja(disnea_moderada, [disnea], 2, "Atención prioritaria").
+bandera_roja(dolor_pecho, [dolor_pecho], 1, "Atención prioritaria").
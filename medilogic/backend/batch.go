@@ -0,0 +1,170 @@
+//go:build !rpa
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+/* ===========================================================
+   Diagnóstico por lotes (/api/diagnose/batch)
+   =========================================================== */
+
+const (
+	maxBatchCases     = 5000
+	maxBatchBytes     = 32 << 20 // 32 MiB
+	maxBatchParallel  = 32
+	defaultBatchLimit = enginePoolSize
+)
+
+// BatchCase: un DiagnoseReq con su identificador de caso.
+type BatchCase struct {
+	ID string `json:"id"`
+	DiagnoseReq
+}
+
+// BatchResult: una línea NDJSON por caso, en orden de término (index = posición de entrada).
+type BatchResult struct {
+	ID     string        `json:"id"`
+	Index  int           `json:"index"`
+	Result *DiagnoseResp `json:"result,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Limit  string        `json:"limit,omitempty"` // timeout | pasos | cancelado, si el caso se cortó
+}
+
+// POST /api/diagnose/batch (arreglo JSON o NDJSON; ?concurrency=N, más los mismos ?mode=, ?explain= y opciones que /api/diagnose)
+func handleDiagnoseBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	workers := defaultBatchLimit
+	if v := r.URL.Query().Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBatchParallel {
+			http.Error(w, fmt.Sprintf("concurrency debe estar entre 1 y %d", maxBatchParallel), http.StatusBadRequest)
+			return
+		}
+		workers = n
+	}
+	// El body se lee completo antes de responder: en HTTP/1.1 escribir la respuesta puede cortar la lectura
+	cases, err := readBatchCases(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Todos los casos contra la misma versión de la KB
+	eng := engines.current()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-KB-Version", strconv.FormatInt(eng.version, 10))
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	var outMu sync.Mutex
+	enc := json.NewEncoder(w)
+	emit := func(res BatchResult) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		_ = enc.Encode(res)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(cases); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := cases[i]
+				res := BatchResult{ID: c.ID, Index: i}
+				if err := applyQuery(r, &c.DiagnoseReq); err != nil {
+					res.Error = err.Error()
+				} else if d, err := runDiagnose(r.Context(), eng, c.DiagnoseReq); err != nil {
+					res.Error = err.Error()
+					if le, ok := err.(*LimitError); ok {
+						res.Limit = le.Kind
+					}
+				} else {
+					res.Result = &d
+				}
+				emit(res)
+			}
+		}()
+	}
+	for i := range cases {
+		if r.Context().Err() != nil {
+			break // el cliente se desconectó
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// readBatchCases acepta un arreglo JSON o NDJSON (un objeto por línea); sin id se usa la posición.
+func readBatchCases(body io.Reader) ([]BatchCase, error) {
+	br := bufio.NewReader(body)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, fmt.Errorf("lote vacío")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad request")
+	}
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return nil, fmt.Errorf("bad request")
+		}
+	}
+	var cases []BatchCase
+	for {
+		if first == '[' && !dec.More() {
+			if _, err := dec.Token(); err != nil {
+				return nil, fmt.Errorf("arreglo JSON sin cerrar")
+			}
+			break
+		}
+		var c BatchCase
+		if err := dec.Decode(&c); err == io.EOF && first != '[' {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("caso %d: JSON inválido: %v", len(cases), err)
+		}
+		if c.ID == "" {
+			c.ID = strconv.Itoa(len(cases))
+		}
+		cases = append(cases, c)
+		if len(cases) > maxBatchCases {
+			return nil, fmt.Errorf("máximo %d casos por lote", maxBatchCases)
+		}
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("lote vacío")
+	}
+	return cases, nil
+}
+
+// peekNonSpace devuelve el primer byte significativo sin consumirlo.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsRune([]byte(" \t\r\n"), rune(b[0])) {
+			return b[0], nil
+		}
+		_, _ = br.ReadByte()
+	}
+}
@@ -0,0 +1,109 @@
+//go:build !rpa
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBatchCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantIDs []string
+		wantErr string
+	}{
+		{"arreglo", `[{"id":"a","symptoms":[{"id":"tos","present":true}]}, {"id":"b"}]`, []string{"a", "b"}, ""},
+		{"ndjson", "{\"id\":\"a\"}\n\n{\"id\":\"b\"}\n", []string{"a", "b"}, ""},
+		{"sin id usa la posición", "  \n[{}, {\"id\":\"x\"}, {}]", []string{"0", "x", "2"}, ""},
+		{"vacío", " \n ", nil, "lote vacío"},
+		{"arreglo vacío", "[]", nil, "lote vacío"},
+		{"arreglo sin cerrar", `[{"id":"a"}`, nil, "caso 1"},
+		{"línea inválida", "{\"id\":\"a\"}\n{id: b}\n", nil, "caso 1: JSON inválido"},
+		{"no es objeto", `"hola"`, nil, "caso 0: JSON inválido"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cases, err := readBatchCases(strings.NewReader(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, c := range cases {
+				ids = append(ids, c.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestReadBatchCasesMax(t *testing.T) {
+	body := strings.Repeat("{}\n", maxBatchCases+1)
+	if _, err := readBatchCases(strings.NewReader(body)); err == nil || !strings.Contains(err.Error(), "máximo") {
+		t.Fatalf("error = %v", err)
+	}
+}
+
+// Una línea NDJSON por caso; un caso inválido no corta el lote.
+func TestDiagnoseBatchHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		body      string
+		wantCode  int
+		wantLines map[string]string // id -> error esperado ("" = con resultado)
+	}{
+		{"concurrencia inválida", "?concurrency=0", `[{}]`, http.StatusBadRequest, nil},
+		{"body inválido", "", `{`, http.StatusBadRequest, nil},
+		{"casos mixtos", "?concurrency=2",
+			`[{"id":"ok","symptoms":[{"id":"tos","severity":"leve","present":true}]}, {"id":"mal","sex":"x"}, {"id":"bayes","mode":"bayes"}]`,
+			http.StatusOK, map[string]string{"ok": "", "mal": "sex inválido (m|f)", "bayes": ""}},
+		{"modo inválido por query", "?mode=x", "{\"id\":\"a\"}\n{\"id\":\"b\"}",
+			http.StatusOK, map[string]string{"a": "unknown mode (afinidad|bayes)", "b": "unknown mode (afinidad|bayes)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleDiagnoseBatch(w, httptest.NewRequest("POST", "/api/diagnose/batch"+tt.query, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d (%s), want %d", w.Code, w.Body, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if w.Header().Get("X-KB-Version") == "" {
+				t.Error("falta X-KB-Version")
+			}
+			got := map[string]string{}
+			indexes := map[int]bool{}
+			sc := bufio.NewScanner(w.Body)
+			for sc.Scan() {
+				var res BatchResult
+				if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+					t.Fatalf("línea inválida %q: %v", sc.Text(), err)
+				}
+				if (res.Error == "") == (res.Result == nil) {
+					t.Errorf("caso %s: resultado y error a la vez (o ninguno)", res.ID)
+				}
+				got[res.ID] = res.Error
+				indexes[res.Index] = true
+			}
+			if !reflect.DeepEqual(got, tt.wantLines) || len(indexes) != len(tt.wantLines) {
+				t.Errorf("líneas %v (índices %v), want %v", got, indexes, tt.wantLines)
+			}
+		})
+	}
+}
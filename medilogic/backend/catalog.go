@@ -0,0 +1,167 @@
+//go:build !rpa
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/* ===========================================================
+   Catálogo público de síntomas (/api/symptoms)
+   =========================================================== */
+
+// Idioma de label/description del Snapshot; los demás van en Symptom.I18n.
+const defaultLang = "es"
+
+var reLang = regexp.MustCompile(`^[a-z]{2}$`)
+
+// Severidades que acepta /api/diagnose, de menor a mayor
+var severityScale = []string{"leve", "moderado", "severo"}
+
+// Textos de la UI por idioma (si falta el idioma se usa defaultLang)
+var severityLabels = map[string]map[string]string{
+	"es": {"leve": "leve", "moderado": "moderado", "severo": "severo"},
+	"en": {"leve": "mild", "moderado": "moderate", "severo": "severe"},
+}
+
+var systemLabels = map[string]map[string]string{
+	"es": {
+		"general": "General", "respiratorio": "Respiratorio", "cardiovascular": "Cardiovascular",
+		"digestivo": "Digestivo", "neurologico": "Neurológico", "piel": "Piel",
+		"musculoesqueletico": "Musculoesquelético", "urinario": "Urinario",
+	},
+	"en": {
+		"general": "General", "respiratorio": "Respiratory", "cardiovascular": "Cardiovascular",
+		"digestivo": "Digestive", "neurologico": "Neurological", "piel": "Skin",
+		"musculoesqueletico": "Musculoskeletal", "urinario": "Urinary",
+	},
+}
+
+// Sistema de los síntomas sin sistema_sintoma/2
+const generalSystem = "general"
+
+type CatalogLevel struct {
+	Value string `json:"value"` // valor para SymptomEntry.Severity
+	Label string `json:"label"`
+}
+
+type CatalogSymptom struct {
+	ID          string         `json:"id"`
+	Label       string         `json:"label"`
+	Description string         `json:"description,omitempty"`
+	System      string         `json:"system"`
+	Scale       []CatalogLevel `json:"scale"`
+	Parents     []string       `json:"parents,omitempty"`
+}
+
+type CatalogGroup struct {
+	System   string           `json:"system"`
+	Label    string           `json:"label"`
+	Symptoms []CatalogSymptom `json:"symptoms"`
+}
+
+// GET /api/symptoms?lang=en (o Accept-Language). "symptoms" conserva la lista de IDs.
+func handlePublicSymptoms(w http.ResponseWriter, r *http.Request) {
+	snap := engines.current().snapshot()
+	lang := requestLang(r)
+	groups := buildCatalog(snap.Symptoms, lang)
+	ids := make([]string, 0, len(snap.Symptoms))
+	for _, s := range snap.Symptoms {
+		ids = append(ids, s.ID)
+	}
+	sort.Strings(ids)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	json.NewEncoder(w).Encode(map[string]any{"lang": lang, "symptoms": ids, "groups": groups})
+}
+
+// requestLang: ?lang= o el primer idioma de Accept-Language (ej. "en-US,en;q=0.9" -> en).
+func requestLang(r *http.Request) string {
+	l := r.URL.Query().Get("lang")
+	if l == "" {
+		l = strings.Split(r.Header.Get("Accept-Language"), ",")[0]
+	}
+	l = strings.ToLower(strings.TrimSpace(strings.SplitN(strings.SplitN(l, ";", 2)[0], "-", 2)[0]))
+	if !reLang.MatchString(l) {
+		return defaultLang
+	}
+	return l
+}
+
+// buildCatalog agrupa los síntomas por sistema; los textos faltantes en lang caen a
+// defaultLang y, sin etiqueta, al id legible. Grupos y síntomas van por etiqueta.
+func buildCatalog(syms []Symptom, lang string) []CatalogGroup {
+	byID := map[string]*CatalogGroup{}
+	var groups []*CatalogGroup
+	for _, s := range syms {
+		sys := s.System
+		if sys == "" {
+			sys = generalSystem
+		}
+		g := byID[sys]
+		if g == nil {
+			g = &CatalogGroup{System: sys, Label: uiText(systemLabels, lang, sys), Symptoms: []CatalogSymptom{}}
+			byID[sys] = g
+			groups = append(groups, g)
+		}
+		label, desc := s.Label, s.Description
+		if t, ok := s.I18n[lang]; ok {
+			label = t.Label
+			if t.Description != "" {
+				desc = t.Description
+			}
+		}
+		if label == "" {
+			label = humanize(s.ID)
+		}
+		scale := s.Scale
+		if len(scale) == 0 {
+			scale = severityScale
+		}
+		levels := make([]CatalogLevel, 0, len(scale))
+		for _, v := range scale {
+			levels = append(levels, CatalogLevel{Value: v, Label: uiText(severityLabels, lang, v)})
+		}
+		g.Symptoms = append(g.Symptoms, CatalogSymptom{
+			ID: s.ID, Label: label, Description: desc, System: sys, Scale: levels, Parents: s.Parents,
+		})
+	}
+	// "general" al final; el resto por etiqueta
+	sort.SliceStable(groups, func(a, b int) bool {
+		if (groups[a].System == generalSystem) != (groups[b].System == generalSystem) {
+			return groups[b].System == generalSystem
+		}
+		return foldText(groups[a].Label) < foldText(groups[b].Label)
+	})
+	out := make([]CatalogGroup, 0, len(groups))
+	for _, g := range groups {
+		sort.SliceStable(g.Symptoms, func(a, b int) bool {
+			return foldText(g.Symptoms[a].Label) < foldText(g.Symptoms[b].Label)
+		})
+		out = append(out, *g)
+	}
+	return out
+}
+
+// uiText busca key en lang, luego en defaultLang; si no está, el id legible.
+func uiText(table map[string]map[string]string, lang, key string) string {
+	if t, ok := table[lang][key]; ok {
+		return t
+	}
+	if t, ok := table[defaultLang][key]; ok {
+		return t
+	}
+	return humanize(key)
+}
+
+// humanize: "dolor_pecho" -> "Dolor pecho"
+func humanize(id string) string {
+	s := strings.ReplaceAll(id, "_", " ")
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
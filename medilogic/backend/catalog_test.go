@@ -0,0 +1,162 @@
+//go:build !rpa
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequestLang(t *testing.T) {
+	tests := []struct {
+		query, accept, want string
+	}{
+		{"", "", "es"},
+		{"?lang=en", "", "en"},
+		{"?lang=EN-us", "", "en"},
+		{"?lang=pt", "en", "pt"},
+		{"", "en-US,en;q=0.9", "en"},
+		{"", "fr;q=0.8", "fr"},
+		{"?lang=english", "", "es"},
+		{"", "*", "es"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query+"|"+tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/symptoms"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Language", tt.accept)
+			}
+			if got := requestLang(r); got != tt.want {
+				t.Errorf("requestLang = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUIText(t *testing.T) {
+	tests := []struct{ lang, key, want string }{
+		{"en", "moderado", "moderate"},
+		{"es", "moderado", "moderado"},
+		{"fr", "severo", "severo"},         // idioma sin tabla: defaultLang
+		{"en", "muy_severo", "Muy severo"}, // clave desconocida: id legible
+	}
+	for _, tt := range tests {
+		if got := uiText(severityLabels, tt.lang, tt.key); got != tt.want {
+			t.Errorf("uiText(%s, %s) = %q, want %q", tt.lang, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"tos", "Tos"},
+		{"dolor_pecho", "Dolor pecho"},
+		{"dolor_pecho_esfuerzo", "Dolor pecho esfuerzo"},
+	}
+	for _, tt := range tests {
+		if got := humanize(tt.in); got != tt.want {
+			t.Errorf("humanize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBuildCatalog(t *testing.T) {
+	syms := []Symptom{
+		{ID: "fiebre", Label: "Fiebre", Description: "38 °C o más", I18n: map[string]SymptomText{"en": {Label: "Fever"}}},
+		{ID: "tos", Label: "Tos", System: "respiratorio", I18n: map[string]SymptomText{"en": {Label: "Cough", Description: "Dry or wet"}}},
+		{ID: "disnea", Label: "Dificultad para respirar", System: "respiratorio", Scale: []string{"moderado", "severo"}},
+		{ID: "sibilancias", System: "respiratorio", Parents: []string{"disnea"}},
+		{ID: "erupcion", Label: "Erupción", System: "piel"},
+		{ID: "palpitaciones", Label: "Palpitaciones", System: "cardiovascular"},
+	}
+	tests := []struct {
+		lang   string
+		groups []string            // sistema: etiquetas de sus síntomas, en orden
+		descs  map[string]string   // id -> descripción
+		scales map[string][]string // id -> etiquetas de la escala
+	}{
+		{"es",
+			[]string{"Cardiovascular: Palpitaciones", "Piel: Erupción", "Respiratorio: Dificultad para respirar, Sibilancias, Tos", "General: Fiebre"},
+			map[string]string{"fiebre": "38 °C o más", "tos": ""},
+			map[string][]string{"disnea": {"moderado", "severo"}, "tos": {"leve", "moderado", "severo"}}},
+		{"en",
+			[]string{"Cardiovascular: Palpitaciones", "Respiratory: Cough, Dificultad para respirar, Sibilancias", "Skin: Erupción", "General: Fever"},
+			map[string]string{"fiebre": "38 °C o más", "tos": "Dry or wet"},
+			map[string][]string{"disnea": {"moderate", "severe"}, "tos": {"mild", "moderate", "severe"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			var groups []string
+			descs := map[string]string{}
+			scales := map[string][]string{}
+			for _, g := range buildCatalog(syms, tt.lang) {
+				var labels []string
+				for _, s := range g.Symptoms {
+					if s.System != g.System {
+						t.Errorf("%s en el grupo %s", s.ID, g.System)
+					}
+					labels = append(labels, s.Label)
+					if _, ok := tt.descs[s.ID]; ok {
+						descs[s.ID] = s.Description
+					}
+					if _, ok := tt.scales[s.ID]; ok {
+						for _, l := range s.Scale {
+							scales[s.ID] = append(scales[s.ID], l.Label)
+						}
+					}
+				}
+				groups = append(groups, g.Label+": "+strings.Join(labels, ", "))
+			}
+			if !reflect.DeepEqual(groups, tt.groups) {
+				t.Errorf("grupos\n%q\nwant\n%q", groups, tt.groups)
+			}
+			if !reflect.DeepEqual(descs, tt.descs) {
+				t.Errorf("descripciones %v, want %v", descs, tt.descs)
+			}
+			if !reflect.DeepEqual(scales, tt.scales) {
+				t.Errorf("escalas %v, want %v", scales, tt.scales)
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotSymptomText(t *testing.T) {
+	sym := func(s *Snapshot) *Symptom { return &s.Symptoms[0] }
+	runSnapshotCases(t, []snapshotCase{
+		{"con traducción", func(s *Snapshot) { sym(s).I18n = map[string]SymptomText{" EN ": {Label: "Fever"}} }, ""},
+		{"etiqueta con comillas", func(s *Snapshot) { sym(s).Label = `"Fiebre"` }, "texto en 'es' sin comillas"},
+		{"descripción larga", func(s *Snapshot) { sym(s).Description = strings.Repeat("a", 301) }, "demasiado largo"},
+		{"idioma inválido", func(s *Snapshot) { sym(s).I18n = map[string]SymptomText{"eng": {Label: "Fever"}} }, "idioma 'eng' inválido"},
+		{"idioma por defecto", func(s *Snapshot) { sym(s).I18n = map[string]SymptomText{"es": {Label: "Fiebre"}} }, "va en label/description"},
+		{"traducción sin etiqueta", func(s *Snapshot) { sym(s).I18n = map[string]SymptomText{"en": {Description: "Fever"}} }, "etiqueta requerida en 'en'"},
+		{"traducción con salto", func(s *Snapshot) { sym(s).I18n = map[string]SymptomText{"en": {Label: "Fever", Description: "a\nb"}} }, "texto en 'en'"},
+		{"escala parcial", func(s *Snapshot) { sym(s).Scale = []string{"Severo", "leve"} }, ""},
+		{"escala inválida", func(s *Snapshot) { sym(s).Scale = []string{"extremo"} }, "escala 'extremo' inválida"},
+	})
+}
+
+func TestPublicSymptomsHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	handlePublicSymptoms(w, httptest.NewRequest("GET", "/api/symptoms?lang=en", nil))
+	if got := w.Header().Get("Content-Language"); got != "en" {
+		t.Errorf("Content-Language %q", got)
+	}
+	var body struct {
+		Lang     string         `json:"lang"`
+		Symptoms []string       `json:"symptoms"`
+		Groups   []CatalogGroup `json:"groups"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, g := range body.Groups {
+		n += len(g.Symptoms)
+	}
+	if body.Lang != "en" || n != len(body.Symptoms) || n == 0 {
+		t.Errorf("lang %q, %d síntomas en grupos y %d ids", body.Lang, n, len(body.Symptoms))
+	}
+}
@@ -0,0 +1,169 @@
+//go:build !rpa
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+/* ===========================================================
+   Texto libre -> SymptomEntry (/api/complaint/parse)
+   =========================================================== */
+
+// Palabras de intensidad (texto ya normalizado con foldText)
+var severityWords = map[string]string{
+	"leve": "leve", "ligera": "leve", "ligero": "leve", "poca": "leve", "poco": "leve",
+	"moderada": "moderado", "moderado": "moderado", "regular": "moderado",
+	"severa": "severo", "severo": "severo", "mucha": "severo", "mucho": "severo",
+	"fuerte": "severo", "intensa": "severo", "intenso": "severo", "alta": "severo", "grave": "severo",
+}
+
+// Disparadores de negación; su alcance llega hasta la siguiente "y"/coma, o sigue con "ni"
+var negationWords = map[string]bool{"sin": true, "no": true, "niega": true, "nunca": true, "tampoco": true}
+
+// Verbos y relleno del relato que no describen el síntoma
+var complaintFiller = map[string]bool{
+	"tiene": true, "tengo": true, "presenta": true, "refiere": true, "paciente": true,
+	"hay": true, "ha": true, "tenido": true, "siente": true, "siento": true, "con": true,
+	"algo": true, "bastante": true, "ademas": true, "tambien": true,
+}
+
+// Cortes fuertes (reinician la negación) y conjunciones que separan síntomas
+var (
+	reClauseBreak = regexp.MustCompile(`[.;:!?]|,|\bpero\b`)
+	reItemBreak   = regexp.MustCompile(`\b(y|e|ni)\b`)
+)
+
+type ComplaintReq struct {
+	Text string `json:"text"`
+}
+
+// ComplaintMatch: de qué fragmento salió cada SymptomEntry (para confirmar antes de diagnosticar)
+type ComplaintMatch struct {
+	ID       string  `json:"id"`
+	Fragment string  `json:"fragment"` // fragmento del texto
+	Matched  string  `json:"matched"`  // id, etiqueta o sinónimo que coincidió
+	Score    float64 `json:"score"`
+	Present  bool    `json:"present"`
+	Severity string  `json:"severity,omitempty"`
+}
+
+type ComplaintResp struct {
+	Text      string           `json:"text"`
+	Symptoms  []SymptomEntry   `json:"symptoms"`  // listos para DiagnoseReq.Symptoms
+	Matches   []ComplaintMatch `json:"matches"`   // detalle por síntoma
+	Unmatched []string         `json:"unmatched"` // fragmentos sin síntoma reconocido
+}
+
+// POST /api/complaint/parse {"text": "tiene mucha fiebre y tos, sin dolor de pecho"}
+func handleComplaintParse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ComplaintReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		http.Error(w, "text requerido", http.StatusBadRequest)
+		return
+	}
+	if len(req.Text) > 2000 {
+		http.Error(w, "text demasiado largo (máx. 2000 caracteres)", http.StatusBadRequest)
+		return
+	}
+	snap := engines.current().snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(parseComplaint(snap.Symptoms, req.Text))
+}
+
+// parseComplaint separa el relato en fragmentos, detecta negación e intensidad en cada
+// uno y lo asocia al síntoma de la KB con mejor puntaje (searchSymptoms).
+// Si un síntoma aparece dos veces, vale la primera mención.
+func parseComplaint(syms []Symptom, text string) ComplaintResp {
+	resp := ComplaintResp{Text: text, Symptoms: []SymptomEntry{}, Matches: []ComplaintMatch{}, Unmatched: []string{}}
+	seen := map[string]bool{}
+	for _, clause := range reClauseBreak.Split(strings.ToLower(text), -1) {
+		clause = foldText(clause)
+		negated := false
+		// conservar el conector para saber si la negación continúa ("sin fiebre ni tos")
+		idx := reItemBreak.FindAllStringIndex(clause, -1)
+		start, sep := 0, ""
+		for k := 0; k <= len(idx); k++ {
+			end := len(clause)
+			if k < len(idx) {
+				end = idx[k][0]
+			}
+			item := strings.TrimSpace(clause[start:end])
+			if sep != "ni" {
+				negated = false
+			}
+			if k < len(idx) {
+				sep = clause[idx[k][0]:idx[k][1]]
+				start = idx[k][1]
+			}
+			if item == "" {
+				continue
+			}
+
+			sev := ""
+			var words []string
+			for _, t := range strings.Fields(item) {
+				switch {
+				case negationWords[t]:
+					negated = true
+				case complaintFiller[t]:
+				default:
+					if s, ok := severityWords[t]; ok {
+						sev = s
+					}
+					words = append(words, t)
+				}
+			}
+			if len(words) == 0 {
+				continue
+			}
+			// con y sin las palabras de intensidad ("fiebre alta" puede ser un sinónimo)
+			frag := strings.Join(words, " ")
+			best := bestSymptom(syms, frag)
+			if best == nil {
+				var core []string
+				for _, t := range words {
+					if _, ok := severityWords[t]; !ok {
+						core = append(core, t)
+					}
+				}
+				if len(core) > 0 {
+					best = bestSymptom(syms, strings.Join(core, " "))
+				}
+			}
+			if best == nil {
+				resp.Unmatched = append(resp.Unmatched, item)
+				continue
+			}
+			if seen[best.ID] {
+				continue
+			}
+			seen[best.ID] = true
+			resp.Symptoms = append(resp.Symptoms, SymptomEntry{ID: best.ID, Severity: sev, Present: !negated})
+			resp.Matches = append(resp.Matches, ComplaintMatch{
+				ID: best.ID, Fragment: item, Matched: best.Matched, Score: best.Score,
+				Present: !negated, Severity: sev,
+			})
+		}
+	}
+	return resp
+}
+
+// bestSymptom: el mejor síntoma para el fragmento, o nil si hay empate en el primer lugar.
+func bestSymptom(syms []Symptom, frag string) *SymptomMatch {
+	res := searchSymptoms(syms, frag)
+	if len(res) == 0 || (len(res) > 1 && res[1].Score == res[0].Score) {
+		return nil
+	}
+	return &res[0]
+}
@@ -0,0 +1,98 @@
+//go:build !rpa
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseComplaint(t *testing.T) {
+	syms := []Symptom{
+		{ID: "fiebre", Label: "Fiebre", Synonyms: []string{"fiebre alta", "calentura"}},
+		{ID: "tos", Label: "Tos"},
+		{ID: "dolor_pecho", Label: "Dolor de pecho"},
+		{ID: "dolor_garganta", Label: "Dolor de garganta"},
+		{ID: "cefalea", Label: "Dolor de cabeza", Synonyms: []string{"jaqueca"}},
+	}
+	tests := []struct {
+		name      string
+		text      string
+		want      []string // id:severidad:presente, en orden de aparición
+		unmatched []string
+	}{
+		{"presentes con intensidad", "Tiene mucha fiebre y tos leve",
+			[]string{"fiebre:severo:true", "tos:leve:true"}, nil},
+		{"negación hasta la coma", "tos, sin dolor de pecho",
+			[]string{"tos::true", "dolor_pecho::false"}, nil},
+		{"la negación no cruza la y", "sin fiebre y con tos",
+			[]string{"fiebre::false", "tos::true"}, nil},
+		{"ni continúa la negación", "no tiene fiebre ni tos",
+			[]string{"fiebre::false", "tos::false"}, nil},
+		{"pero corta la negación", "niega fiebre pero tiene jaqueca",
+			[]string{"fiebre::false", "cefalea::true"}, nil},
+		{"sinónimo con intensidad", "fiebre alta",
+			[]string{"fiebre:severo:true"}, nil},
+		{"con acentos y tipeo", "Dolor de cabesa intenso.",
+			[]string{"cefalea:severo:true"}, nil},
+		{"vale la primera mención", "tos leve; tos fuerte",
+			[]string{"tos:leve:true"}, nil},
+		{"empate queda sin reconocer", "dolor",
+			nil, []string{"dolor"}},
+		{"fragmento desconocido", "fiebre y sarpullido",
+			[]string{"fiebre::true"}, []string{"sarpullido"}},
+		{"solo relleno", "Paciente refiere algo", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := parseComplaint(syms, tt.text)
+			var got []string
+			for _, s := range resp.Symptoms {
+				got = append(got, fmt.Sprintf("%s:%s:%t", s.ID, s.Severity, s.Present))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("síntomas %v, want %v", got, tt.want)
+			}
+			if len(resp.Unmatched) > 0 || len(tt.unmatched) > 0 {
+				if !reflect.DeepEqual(resp.Unmatched, tt.unmatched) {
+					t.Errorf("sin reconocer %q, want %q", resp.Unmatched, tt.unmatched)
+				}
+			}
+			if len(resp.Matches) != len(resp.Symptoms) {
+				t.Fatalf("%d matches para %d síntomas", len(resp.Matches), len(resp.Symptoms))
+			}
+			for i, m := range resp.Matches {
+				if s := resp.Symptoms[i]; m.ID != s.ID || m.Present != s.Present || m.Severity != s.Severity {
+					t.Errorf("match %+v no corresponde a %+v", m, s)
+				}
+			}
+		})
+	}
+}
+
+func TestComplaintParseHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+	}{
+		{"ok", "POST", `{"text":"tiene fiebre y tos"}`, http.StatusOK},
+		{"json inválido", "POST", `{`, http.StatusBadRequest},
+		{"texto vacío", "POST", `{"text":"  "}`, http.StatusBadRequest},
+		{"texto largo", "POST", `{"text":"` + strings.Repeat("a", 2001) + `"}`, http.StatusBadRequest},
+		{"método", "GET", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleComplaintParse(w, httptest.NewRequest(tt.method, "/api/complaint/parse", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Errorf("status %d (%s), want %d", w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+//go:build !rpa
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* ===========================================================
+   Consultas con estado (/api/consultations)
+   =========================================================== */
+
+// Respuestas del paciente guardadas en el servidor; aparte de la sesión admin (sessions).
+var (
+	consultations    = make(map[string]*consultation) // id -> consulta
+	consultMu        sync.Mutex
+	consultationTTL  = 30 * time.Minute // se renueva con cada paso
+	maxConsultations = 10000
+	sweepEvery       = time.Minute // pasada mínima entre barridos de expiradas
+	lastSweep        time.Time
+)
+
+type consultation struct {
+	req     DiagnoseReq
+	expires time.Time
+}
+
+// ConsultationStep: cambios incrementales; un síntoma repetido reemplaza al anterior.
+type ConsultationStep struct {
+	AddSymptoms     []SymptomEntry `json:"add_symptoms,omitempty"`
+	RemoveSymptoms  []string       `json:"remove_symptoms,omitempty"`
+	AddAllergies    []string       `json:"add_allergies,omitempty"`
+	RemoveAllergies []string       `json:"remove_allergies,omitempty"`
+	AddChronics     []string       `json:"add_chronics,omitempty"`
+	RemoveChronics  []string       `json:"remove_chronics,omitempty"`
+}
+
+type ConsultationResp struct {
+	ID        string       `json:"id"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	Request   DiagnoseReq  `json:"request"` // estado acumulado
+	Result    DiagnoseResp `json:"result"`  // ranking con ese estado
+}
+
+// POST /api/consultations (body opcional: DiagnoseReq inicial)
+func handleConsultations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req DiagnoseReq
+	if r.ContentLength != 0 {
+		var err error
+		if req, err = readDiagnoseReq(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// se diagnostica antes de guardarla: si falla, la consulta no ocupa lugar
+	res, err := runDiagnose(r.Context(), engines.current(), req)
+	if err != nil {
+		writePrologError(w, err)
+		return
+	}
+	id := newSessionID()
+	c := &consultation{req: req, expires: time.Now().Add(consultationTTL)}
+
+	consultMu.Lock()
+	sweepConsultations()
+	if len(consultations) >= maxConsultations {
+		consultMu.Unlock()
+		http.Error(w, "demasiadas consultas abiertas", http.StatusServiceUnavailable)
+		return
+	}
+	consultations[id] = c
+	consultMu.Unlock()
+
+	encodeConsultation(w, http.StatusCreated, ConsultationResp{ID: id, ExpiresAt: c.expires, Request: req, Result: res})
+}
+
+// GET | PATCH | DELETE /api/consultations/{id}
+// El modo y la explicación guardados son los de la creación; ?mode= y ?explain=1 en
+// GET o PATCH los cambian solo para esa respuesta.
+func handleConsultation(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/consultations/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		req, exp, err := touchConsultation(id, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := viewQuery(r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeConsultation(w, r, http.StatusOK, id, req, exp)
+	case http.MethodPatch:
+		var step ConsultationStep
+		if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := viewQuery(r, &DiagnoseReq{}); err != nil { // antes de tocar el estado
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, exp, err := touchConsultation(id, func(req *DiagnoseReq) error { return applyStep(req, step) })
+		if err == errConsultationGone {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = viewQuery(r, &req)
+		writeConsultation(w, r, http.StatusOK, id, req, exp)
+	case http.MethodDelete:
+		consultMu.Lock()
+		sweepConsultations()
+		delete(consultations, id)
+		consultMu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+var errConsultationGone = errors.New("consulta no encontrada o expirada")
+
+// viewQuery aplica ?mode= y ?explain=1 a req (solo para la respuesta, no se guardan).
+func viewQuery(r *http.Request, req *DiagnoseReq) error {
+	if m := r.URL.Query().Get("mode"); m != "" {
+		if !validMode(m) {
+			return fmt.Errorf("unknown mode (afinidad|bayes)")
+		}
+		req.Mode = m
+	}
+	if r.URL.Query().Get("explain") == "1" {
+		req.Explain = true
+	}
+	return nil
+}
+
+// touchConsultation aplica fn (si hay) bajo el lock, renueva el TTL y devuelve una copia del estado.
+// Si fn falla, el estado anterior se conserva.
+func touchConsultation(id string, fn func(*DiagnoseReq) error) (DiagnoseReq, time.Time, error) {
+	consultMu.Lock()
+	defer consultMu.Unlock()
+	sweepConsultations()
+	c, ok := consultations[id]
+	if !ok || time.Now().After(c.expires) {
+		delete(consultations, id)
+		return DiagnoseReq{}, time.Time{}, errConsultationGone
+	}
+	if fn != nil {
+		next := cloneReq(c.req)
+		if err := fn(&next); err != nil {
+			return DiagnoseReq{}, time.Time{}, err
+		}
+		c.req = next
+	}
+	c.expires = time.Now().Add(consultationTTL)
+	return cloneReq(c.req), c.expires, nil
+}
+
+// applyStep quita y luego agrega; revalida con las mismas reglas que /api/diagnose.
+func applyStep(req *DiagnoseReq, st ConsultationStep) error {
+	drop := map[string]bool{}
+	for _, id := range st.RemoveSymptoms {
+		drop[safeAtom(id)] = true
+	}
+	for _, s := range st.AddSymptoms {
+		if strings.TrimSpace(s.ID) == "" {
+			return fmt.Errorf("síntoma sin id")
+		}
+		drop[safeAtom(s.ID)] = true
+	}
+	kept := req.Symptoms[:0]
+	for _, s := range req.Symptoms {
+		if !drop[safeAtom(s.ID)] {
+			kept = append(kept, s)
+		}
+	}
+	req.Symptoms = append(kept, st.AddSymptoms...)
+	req.Allergies = editList(req.Allergies, st.AddAllergies, st.RemoveAllergies)
+	req.Chronics = editList(req.Chronics, st.AddChronics, st.RemoveChronics)
+	return validateDemographics(req)
+}
+
+// editList quita los elementos de del y agrega los de add que falten (comparando átomos).
+func editList(cur, add, del []string) []string {
+	out := []string{}
+	for _, v := range cur {
+		if !containsAtom(del, v) {
+			out = append(out, v)
+		}
+	}
+	for _, v := range add {
+		if !containsAtom(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func containsAtom(xs []string, v string) bool {
+	for _, x := range xs {
+		if safeAtom(x) == safeAtom(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneReq(r DiagnoseReq) DiagnoseReq {
+	r.Symptoms = append([]SymptomEntry(nil), r.Symptoms...)
+	r.Allergies = append([]string(nil), r.Allergies...)
+	r.Chronics = append([]string(nil), r.Chronics...)
+	r.CurrentMeds = append([]string(nil), r.CurrentMeds...)
+	return r
+}
+
+// sweepConsultations borra las expiradas; se llama con consultMu tomado en cada acceso y
+// recorre el mapa a lo sumo una vez por sweepEvery (siempre si está lleno).
+func sweepConsultations() {
+	now := time.Now()
+	if now.Sub(lastSweep) < sweepEvery && len(consultations) < maxConsultations {
+		return
+	}
+	lastSweep = now
+	for id, c := range consultations {
+		if now.After(c.expires) {
+			delete(consultations, id)
+		}
+	}
+}
+
+func writeConsultation(w http.ResponseWriter, r *http.Request, status int, id string, req DiagnoseReq, exp time.Time) {
+	res, err := runDiagnose(r.Context(), engines.current(), req)
+	if err != nil {
+		writePrologError(w, err)
+		return
+	}
+	encodeConsultation(w, status, ConsultationResp{ID: id, ExpiresAt: exp, Request: req, Result: res})
+}
+
+func encodeConsultation(w http.ResponseWriter, status int, resp ConsultationResp) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,212 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyStep(t *testing.T) {
+	start := func() DiagnoseReq {
+		return DiagnoseReq{
+			Symptoms:  []SymptomEntry{{ID: "tos", Severity: "leve", Present: true}, {ID: "fiebre", Severity: "moderado", Present: true}},
+			Allergies: []string{"penicilina"},
+			Chronics:  []string{"asma"},
+		}
+	}
+	tests := []struct {
+		name      string
+		step      ConsultationStep
+		symptoms  []string // id:severidad
+		allergies []string
+		chronics  []string
+		wantErr   string
+	}{
+		{"agrega", ConsultationStep{AddSymptoms: []SymptomEntry{{ID: "cefalea", Severity: "leve", Present: true}}},
+			[]string{"tos:leve", "fiebre:moderado", "cefalea:leve"}, []string{"penicilina"}, []string{"asma"}, ""},
+		{"repetido reemplaza", ConsultationStep{AddSymptoms: []SymptomEntry{{ID: "Tos", Severity: "severo", Present: true}}},
+			[]string{"fiebre:moderado", "Tos:severo"}, []string{"penicilina"}, []string{"asma"}, ""},
+		{"quita", ConsultationStep{RemoveSymptoms: []string{"fiebre"}, RemoveChronics: []string{"Asma"}},
+			[]string{"tos:leve"}, []string{"penicilina"}, []string{}, ""},
+		{"alergia repetida", ConsultationStep{AddAllergies: []string{"Penicilina", "aines"}},
+			[]string{"tos:leve", "fiebre:moderado"}, []string{"penicilina", "aines"}, []string{"asma"}, ""},
+		{"síntoma sin id", ConsultationStep{AddSymptoms: []SymptomEntry{{ID: " "}}}, nil, nil, nil, "síntoma sin id"},
+		{"revalida", ConsultationStep{AddSymptoms: []SymptomEntry{{ID: "tos", Present: true, Onset: "ayer"}}}, nil, nil, nil, "onset inválido"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := start()
+			err := applyStep(&req, tt.step)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var syms []string
+			for _, s := range req.Symptoms {
+				syms = append(syms, s.ID+":"+s.Severity)
+			}
+			if !reflect.DeepEqual(syms, tt.symptoms) || !reflect.DeepEqual(req.Allergies, tt.allergies) || !reflect.DeepEqual(req.Chronics, tt.chronics) {
+				t.Errorf("estado %v %v %v, want %v %v %v", syms, req.Allergies, req.Chronics, tt.symptoms, tt.allergies, tt.chronics)
+			}
+		})
+	}
+}
+
+// withConsultations aísla el estado global de las consultas durante un test.
+func withConsultations(t *testing.T, max int) {
+	t.Helper()
+	consultMu.Lock()
+	saved, savedMax, savedSweep := consultations, maxConsultations, lastSweep
+	consultations, maxConsultations, lastSweep = map[string]*consultation{}, max, time.Time{}
+	consultMu.Unlock()
+	t.Cleanup(func() {
+		consultMu.Lock()
+		consultations, maxConsultations, lastSweep = saved, savedMax, savedSweep
+		consultMu.Unlock()
+	})
+}
+
+func TestSweepConsultations(t *testing.T) {
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		max       int
+		lastSweep time.Duration // hace cuánto fue el último barrido
+		want      []string
+	}{
+		{"barre expiradas", 10, time.Hour, []string{"viva"}},
+		{"no repite antes de sweepEvery", 10, 0, []string{"vencida", "viva"}},
+		{"lleno: barre siempre", 2, 0, []string{"viva"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConsultations(t, tt.max)
+			consultMu.Lock()
+			defer consultMu.Unlock()
+			consultations["vencida"] = &consultation{expires: past}
+			consultations["viva"] = &consultation{expires: future}
+			lastSweep = time.Now().Add(-tt.lastSweep)
+			sweepConsultations()
+			var got []string
+			for _, id := range []string{"vencida", "viva"} {
+				if _, ok := consultations[id]; ok {
+					got = append(got, id)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("quedan %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTouchConsultation(t *testing.T) {
+	withConsultations(t, 10)
+	consultations["vencida"] = &consultation{expires: time.Now().Add(-time.Second)}
+	consultations["c"] = &consultation{req: DiagnoseReq{Allergies: []string{"penicilina"}}, expires: time.Now().Add(time.Second)}
+
+	if _, _, err := touchConsultation("vencida", nil); err != errConsultationGone {
+		t.Fatalf("expirada: %v", err)
+	}
+	if _, ok := consultations["vencida"]; ok {
+		t.Error("la consulta expirada sigue guardada")
+	}
+	// un paso inválido no toca el estado
+	bad := ConsultationStep{RemoveAllergies: []string{"penicilina"}, AddSymptoms: []SymptomEntry{{ID: "tos", DurationDays: -1}}}
+	if _, _, err := touchConsultation("c", func(r *DiagnoseReq) error { return applyStep(r, bad) }); err == nil {
+		t.Fatal("paso inválido aceptado")
+	}
+	req, exp, err := touchConsultation("c", nil)
+	if err != nil || !reflect.DeepEqual(req.Allergies, []string{"penicilina"}) {
+		t.Fatalf("estado %+v (%v) tras un paso inválido", req, err)
+	}
+	if time.Until(exp) < consultationTTL-time.Minute {
+		t.Errorf("TTL no renovado: vence en %v", time.Until(exp))
+	}
+	// la copia devuelta no comparte memoria con el estado guardado
+	req.Allergies[0] = "otra"
+	if consultations["c"].req.Allergies[0] != "penicilina" {
+		t.Error("el estado guardado cambió al editar la copia")
+	}
+}
+
+// Ciclo completo por HTTP: ?mode= en GET vale solo para esa respuesta.
+func TestConsultationHandlers(t *testing.T) {
+	withConsultations(t, 10)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if path == "/api/consultations" {
+			handleConsultations(w, r)
+		} else {
+			handleConsultation(w, r)
+		}
+		return w
+	}
+	w := do("POST", "/api/consultations", `{"symptoms":[{"id":"tos","severity":"leve","present":true}]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	var c ConsultationResp
+	_ = json.Unmarshal(w.Body.Bytes(), &c)
+	path := "/api/consultations/" + c.ID
+
+	tests := []struct {
+		method, query, body string
+		wantCode            int
+		wantMode            string
+		wantSymptoms        int
+	}{
+		{"PATCH", "", `{"add_symptoms":[{"id":"fiebre","severity":"severo","present":true}]}`, http.StatusOK, modeAffinity, 2},
+		{"PATCH", "", `{"add_symptoms":[{"id":"cefalea","present":true,"onset":"ayer"}]}`, http.StatusBadRequest, "", 0},
+		{"PATCH", "?mode=x", `{"remove_symptoms":["tos"]}`, http.StatusBadRequest, "", 0},
+		{"GET", "?mode=bayes", "", http.StatusOK, modeBayes, 2},
+		{"GET", "", "", http.StatusOK, modeAffinity, 2},
+		{"DELETE", "", "", http.StatusNoContent, "", 0},
+		{"GET", "", "", http.StatusNotFound, "", 0},
+	}
+	for _, tt := range tests {
+		w := do(tt.method, path+tt.query, tt.body)
+		if w.Code != tt.wantCode {
+			t.Fatalf("%s%s: %d %s, want %d", tt.method, tt.query, w.Code, w.Body, tt.wantCode)
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		var got ConsultationResp
+		_ = json.Unmarshal(w.Body.Bytes(), &got)
+		if got.Result.Mode != tt.wantMode || len(got.Request.Symptoms) != tt.wantSymptoms {
+			t.Errorf("%s%s: modo %q con %d síntomas, want %q con %d", tt.method, tt.query, got.Result.Mode, len(got.Request.Symptoms), tt.wantMode, tt.wantSymptoms)
+		}
+	}
+}
+
+// Si el diagnóstico inicial falla, la consulta no queda guardada.
+func TestCreateConsultationFails(t *testing.T) {
+	withConsultations(t, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/consultations", strings.NewReader(`{"symptoms":[{"id":"tos","severity":"leve","present":true}]}`))
+	handleConsultations(w, r.WithContext(ctx))
+	if w.Code == http.StatusCreated {
+		t.Fatalf("POST con el contexto cancelado: %d %s", w.Code, w.Body)
+	}
+	consultMu.Lock()
+	n := len(consultations)
+	consultMu.Unlock()
+	if n != 0 {
+		t.Errorf("quedaron %d consultas guardadas, want 0", n)
+	}
+}
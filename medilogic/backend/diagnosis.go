@@ -0,0 +1,176 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/* ===========================================================
+   Motores de diagnóstico intercambiables
+   =========================================================== */
+
+// DiagnosisEngine evalúa un DiagnoseReq ya validado contra una versión de la KB.
+// kb es inmutable: el motor Prolog usa sus intérpretes compilados y el nativo
+// los hechos leídos de ellos (kb.facts()), así ambos razonan sobre los mismos hechos.
+type DiagnosisEngine interface {
+	Name() string
+	Diagnose(ctx context.Context, kb *kbEngine, req DiagnoseReq) (DiagnoseResp, error)
+	// NextQuestions: síntomas sin preguntar que más separan a las líderes (/api/next-question)
+	NextQuestions(ctx context.Context, kb *kbEngine, req DiagnoseReq, n int) (NextQuestionResp, error)
+}
+
+var diagnosisEngines = map[string]DiagnosisEngine{
+	"prolog": prologEngine{},
+	"nativo": nativeEngine{},
+}
+
+// Motor de /api/diagnose, lotes, consultas y /api/next-question; configurable con DIAGNOSIS_ENGINE (prolog | nativo).
+var activeEngine = selectEngine(getenvDefault("DIAGNOSIS_ENGINE", "prolog"))
+
+func selectEngine(name string) DiagnosisEngine {
+	if e, ok := diagnosisEngines[name]; ok {
+		return e
+	}
+	log.Printf("DIAGNOSIS_ENGINE %q desconocido, se usa prolog\n", name)
+	return prologEngine{}
+}
+
+// runDiagnose diagnostica con el motor activo. El modo explicación siempre usa
+// Prolog: el árbol de prueba sale de las trazas de rules.pl.
+func runDiagnose(ctx context.Context, eng *kbEngine, req DiagnoseReq) (DiagnoseResp, error) {
+	if req.Explain {
+		return prologEngine{}.Diagnose(ctx, eng, req)
+	}
+	return activeEngine.Diagnose(ctx, eng, req)
+}
+
+// prologEngine: rules.pl + KB en Ichiban Prolog (motor de referencia).
+type prologEngine struct{}
+
+func (prologEngine) Name() string { return "prolog" }
+
+// Diagnose toma un intérprete del pool de kb, aserta la sesión y diagnostica
+// dentro de los límites de ejecución (ctx + timeout + pasos).
+func (prologEngine) Diagnose(ctx context.Context, kb *kbEngine, req DiagnoseReq) (DiagnoseResp, error) {
+	// 1) Intérprete exclusivo de esa versión (reglas + KB ya compiladas)
+	p, err := kb.acquire(ctx)
+	if err != nil {
+		log.Println("prolog engine error:", err)
+		return DiagnoseResp{}, fmt.Errorf("prolog engine error")
+	}
+	defer kb.release(p)
+
+	// 2) Asertar hechos de la sesión
+	if err := assertSession(p, req); err != nil {
+		if le := p.limitErr(); le != nil {
+			return DiagnoseResp{}, le
+		}
+		log.Println("assert session facts error:", err)
+		return DiagnoseResp{}, fmt.Errorf("prolog assert error")
+	}
+	resp, err := diagnose(p, req)
+	// una consulta cortada deja resultados parciales: se reporta el límite
+	if le := p.limitErr(); le != nil {
+		return DiagnoseResp{}, le
+	}
+	return resp, err
+}
+
+// diagnosisParts: lo que cada motor calcula para una enfermedad devuelta; diagnosis()
+// arma la respuesta igual para ambos (RulesFired, advertencias, sugerido y alternativas).
+type diagnosisParts struct {
+	name      string
+	aff       int
+	posterior float64
+	matched   []string
+	opts      []TherapyOption // seguros ya ordenados (línea/rank, interacciones al final)
+	inter     []DrugInteraction
+	blocked   []blockReason
+	denied    []deniedPair
+	urgency   string
+	dosage    *DosageInfo // del primero de opts
+	ageOut    bool        // edad fuera de enf_edad/3
+	ageMin    int
+	ageMax    int
+	missing   []string // falta_requerido/2
+	reqCap    int      // tope_requerido/1 (afinidad máxima si falta un requerido)
+}
+
+func (dp diagnosisParts) diagnosis(mode string) Diagnosis {
+	safeMeds := make([]string, len(dp.opts))
+	for j, o := range dp.opts {
+		safeMeds[j] = o.Drug
+	}
+	rf := rulesFired(safeMeds, dp.blocked)
+	if len(dp.inter) > 0 {
+		rf = append(rf, "alerta_interaccion/4")
+	}
+	for _, o := range dp.opts {
+		if o.Line > 0 {
+			rf = append(rf, "linea/4")
+			break
+		}
+	}
+	// el tope solo pesa si hay evidencia de la enfermedad
+	capped := len(dp.missing) > 0 && len(dp.matched) > 0
+	if capped {
+		rf = append(rf, "tope_afinidad/3")
+	}
+	if mode == modeBayes {
+		rf = append(rf, "puntaje_bayes/2")
+	}
+	med := ""
+	if len(safeMeds) > 0 {
+		med = safeMeds[0]
+	}
+	var alts []string
+	if len(safeMeds) > 1 {
+		alts = safeMeds[1:]
+	}
+	if dp.dosage != nil {
+		rf = append(rf, "dosis/6")
+	}
+	dg := Diagnosis{
+		Disease:         dp.name,
+		Affinity:        dp.aff,
+		Posterior:       dp.posterior,
+		SuggestedDrug:   med,
+		Dosage:          dp.dosage,
+		Alternatives:    alts,
+		TherapyOptions:  dp.opts,
+		Urgency:         dp.urgency,
+		Warnings:        []string{},
+		RulesFired:      rf,
+		MatchedSymptoms: dp.matched,
+		MissingRequired: dp.missing,
+	}
+	for _, d := range dp.denied {
+		dg.DeniedSymptoms = append(dg.DeniedSymptoms, d.S)
+	}
+	if capped {
+		dg.Warnings = append(dg.Warnings, fmt.Sprintf("falta síntoma requerido de %s: %s (afinidad máx. %d%%)", dp.name, strings.Join(dp.missing, ", "), dp.reqCap))
+	}
+	if dp.ageOut {
+		dg.Warnings = append(dg.Warnings, fmt.Sprintf("edad fuera del rango típico de %s (%d-%d años)", dp.name, dp.ageMin, dp.ageMax))
+	}
+	for _, in := range dp.inter {
+		dg.Warnings = append(dg.Warnings, fmt.Sprintf("%s: interacción %s con %s", in.Drug, in.Severity, in.With))
+	}
+	dg.Interactions = dp.inter
+	for _, b := range dp.blocked {
+		dg.Warnings = append(dg.Warnings, b.warning())
+		dg.BlockedDrugs = append(dg.BlockedDrugs, BlockedDrug{Drug: b.Med, Reason: b.Kind, Condition: b.Cond, Facts: b.facts()})
+	}
+	return dg
+}
+
+// excludedDisease arma el descarte por enf_excluye/2 (mismo texto en ambos motores).
+func excludedDisease(name string, aff int, syms []string) ExcludedDisease {
+	return ExcludedDisease{
+		Disease: name, Affinity: aff, Symptoms: syms,
+		Reason: fmt.Sprintf("%s descartada: presenta %s", name, strings.Join(syms, ", ")),
+	}
+}
@@ -0,0 +1,235 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	iprolog "github.com/ichiban/prolog"
+)
+
+// engineWithRules compila kb con unas reglas dadas (newKBEngine siempre lee rules.pl de disco).
+func engineWithRules(t *testing.T, rules, kb string) *kbEngine {
+	t.Helper()
+	e := &kbEngine{version: 1, rules: rules, kb: kb, pool: make(chan *iprolog.Interpreter, enginePoolSize)}
+	p, err := e.compileWith(func(s *session) { e.consts = readRuleConsts(s) })
+	if err != nil {
+		t.Fatalf("no compila: %v", err)
+	}
+	e.pool <- p
+	return e
+}
+
+const requiredKB = `sintoma(pirosis). sintoma(tos). sintoma(nauseas).
+enfermedad(reflujo, "Reflujo", digestivo, cronico).
+enf_sintoma(reflujo, pirosis, 1). enf_sintoma(reflujo, tos, 1). enf_sintoma(reflujo, nauseas, 1).
+enf_requerido(reflujo, pirosis).
+`
+
+// El tope por síntoma requerido faltante se define solo en rules.pl (tope_requerido/1):
+// ambos motores y el texto de la advertencia lo toman de ahí.
+func TestRequiredCapFromRules(t *testing.T) {
+	rules, err := readRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rules), "tope_requerido(25).") {
+		t.Fatal("rules.pl no declara tope_requerido(25).")
+	}
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	tests := []struct {
+		name    string
+		cap     int
+		req     DiagnoseReq
+		wantAff int
+		capped  bool
+	}{
+		{"sin requerido, tope 25", 25, DiagnoseReq{Symptoms: []SymptomEntry{sym("tos", "severo"), sym("nauseas", "severo")}}, 25, true},
+		{"sin requerido, tope 40", 40, DiagnoseReq{Symptoms: []SymptomEntry{sym("tos", "severo"), sym("nauseas", "severo")}}, 40, true},
+		{"bajo el tope", 40, DiagnoseReq{Symptoms: []SymptomEntry{sym("tos", "leve")}}, 11, true},
+		{"con requerido", 25, DiagnoseReq{Symptoms: []SymptomEntry{sym("pirosis", "severo"), sym("tos", "severo")}}, 67, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := strings.Replace(string(rules), "tope_requerido(25).", fmt.Sprintf("tope_requerido(%d).", tt.cap), 1)
+			eng := engineWithRules(t, r, requiredKB)
+			for _, de := range []DiagnosisEngine{prologEngine{}, nativeEngine{}} {
+				resp, err := de.Diagnose(context.Background(), eng, tt.req)
+				if err != nil {
+					t.Fatalf("%s: %v", de.Name(), err)
+				}
+				if len(resp.Diagnoses) != 1 {
+					t.Fatalf("%s: %d diagnósticos", de.Name(), len(resp.Diagnoses))
+				}
+				dg := resp.Diagnoses[0]
+				if dg.Affinity != tt.wantAff {
+					t.Errorf("%s: afinidad %d, want %d", de.Name(), dg.Affinity, tt.wantAff)
+				}
+				warn := fmt.Sprintf("(afinidad máx. %d%%)", tt.cap)
+				if got := strings.Contains(strings.Join(dg.Warnings, "|"), warn); got != tt.capped {
+					t.Errorf("%s: advertencia %q = %v, want %v (%v)", de.Name(), warn, got, tt.capped, dg.Warnings)
+				}
+			}
+		})
+	}
+}
+
+// bothEngines corre req en los dos motores (deben coincidir; ver differential_test.go).
+func bothEngines(t *testing.T, eng *kbEngine, req DiagnoseReq) map[string]DiagnoseResp {
+	t.Helper()
+	out := map[string]DiagnoseResp{}
+	for _, de := range []DiagnosisEngine{prologEngine{}, nativeEngine{}} {
+		resp, err := de.Diagnose(context.Background(), eng, req)
+		if err != nil {
+			t.Fatalf("%s: %v", de.Name(), err)
+		}
+		out[de.Name()] = resp
+	}
+	return out
+}
+
+func findDiagnosis(resp DiagnoseResp, disease string) *Diagnosis {
+	for i := range resp.Diagnoses {
+		if resp.Diagnoses[i].Disease == disease {
+			return &resp.Diagnoses[i]
+		}
+	}
+	return nil
+}
+
+// Un síntoma negado (ausente/1) resta penalizacion_ausente/2 x peso, salvo que un
+// descendiente esté presente.
+func TestDeniedSymptoms(t *testing.T) {
+	eng := newKBEngine(1, []byte(fixtureManual))
+	if eng.err != nil {
+		t.Fatal(eng.err)
+	}
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	no := func(id string) SymptomEntry { return SymptomEntry{ID: id} }
+	tests := []struct {
+		name       string
+		symptoms   []SymptomEntry
+		disease    string
+		wantAff    int
+		wantDenied []string
+	}{
+		{"sin negados", []SymptomEntry{sym("tos", "severo")}, "Bronquitis", 75, nil},
+		{"negado con penalización", []SymptomEntry{sym("tos", "severo"), no("fiebre")}, "Bronquitis", 55, []string{"fiebre"}},
+		{"negado sin penalización", []SymptomEntry{sym("tos", "severo"), no("fiebre")}, "Neumonía", 29, []string{"fiebre"}},
+		{"negado ajeno a la enfermedad", []SymptomEntry{sym("tos", "severo"), no("dolor_pecho")}, "Bronquitis", 75, nil},
+		{"negado con descendiente presente", []SymptomEntry{sym("sibilancias", "moderado"), no("disnea")}, "Asma", 56, nil},
+		{"síntoma leve y negado", []SymptomEntry{sym("tos", "leve"), no("fiebre")}, "Bronquitis", 5, []string{"fiebre"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms}) {
+				dg := findDiagnosis(resp, tt.disease)
+				if dg == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				if dg.Affinity != tt.wantAff || !reflect.DeepEqual(dg.DeniedSymptoms, tt.wantDenied) {
+					t.Errorf("%s: afinidad %d negados %v, want %d %v", name, dg.Affinity, dg.DeniedSymptoms, tt.wantAff, tt.wantDenied)
+				}
+			}
+		})
+	}
+}
+
+const bayesKB = `sintoma(s). sintoma(t).
+enfermedad(a, "A", sis, tipo). enfermedad(b, "B", sis, tipo). enfermedad(c, "C", otro, tipo).
+enf_sintoma(a, s, 1). enf_sintoma(b, s, 1). enf_sintoma(b, t, 1).
+prevalencia(a, 0.1). prevalencia(b, 0.3).
+sensibilidad(a, s, 0.9). sensibilidad(b, t, 0.5).
+enf_edad(a, 0, 10).
+`
+
+// posterior = prior x Π factores, normalizada entre todas las enfermedades de la KB.
+func TestBayesPosterior(t *testing.T) {
+	eng := newKBEngine(1, []byte(bayesKB))
+	if eng.err != nil {
+		t.Fatal(eng.err)
+	}
+	s := SymptomEntry{ID: "s", Severity: "leve", Present: true}
+	tests := []struct {
+		name string
+		req  DiagnoseReq
+		want map[string]float64
+	}{
+		// a = .1 x .9, b = .3 x .7 (sensibilidad por defecto), c = .01 x .05 (fuga)
+		{"presente", DiagnoseReq{Symptoms: []SymptomEntry{s}}, map[string]float64{"A": 0.2995, "B": 0.6988, "C": 0.0017}},
+		// b x (1 - .5) por t negado
+		{"negado", DiagnoseReq{Symptoms: []SymptomEntry{s, {ID: "t"}}}, map[string]float64{"A": 0.4604, "B": 0.5371, "C": 0.0026}},
+		// a fuera de enf_edad/3: prior x factor_edad
+		{"fuera de edad", DiagnoseReq{Age: intp(50), Symptoms: []SymptomEntry{s}}, map[string]float64{"A": 0.041, "B": 0.9567, "C": 0.0023}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Mode = modeBayes
+			for name, resp := range bothEngines(t, eng, tt.req) {
+				got := map[string]float64{}
+				for _, dg := range resp.Diagnoses {
+					got[dg.Disease] = dg.Posterior
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: posteriores %v, want %v", name, got, tt.want)
+				}
+				if resp.Diagnoses[0].Disease != "B" || !contains(resp.Diagnoses[0].RulesFired, "puntaje_bayes/2") {
+					t.Errorf("%s: primero %+v", name, resp.Diagnoses[0])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectEngine(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"prolog", "prolog"},
+		{"nativo", "nativo"},
+		{"", "prolog"},
+		{"native", "prolog"}, // desconocido: motor de referencia
+	}
+	for _, tt := range tests {
+		if got := selectEngine(tt.name).Name(); got != tt.want {
+			t.Errorf("selectEngine(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// runDiagnose usa el motor activo, salvo en modo explicación (siempre Prolog).
+func TestRunDiagnoseEngine(t *testing.T) {
+	eng := newKBEngine(1, []byte(fixtureManual))
+	if eng.err != nil {
+		t.Fatal(eng.err)
+	}
+	saved := activeEngine
+	t.Cleanup(func() { activeEngine = saved })
+	syms := []SymptomEntry{{ID: "fiebre", Severity: "severo", Present: true}, {ID: "tos", Severity: "leve", Present: true}}
+	tests := []struct {
+		active    DiagnosisEngine
+		explain   bool
+		wantProof bool
+	}{
+		{prologEngine{}, false, false},
+		{nativeEngine{}, false, false},
+		{prologEngine{}, true, true},
+		{nativeEngine{}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/explain=%t", tt.active.Name(), tt.explain), func(t *testing.T) {
+			activeEngine = tt.active
+			resp, err := runDiagnose(context.Background(), eng, DiagnoseReq{Symptoms: syms, Explain: tt.explain})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Diagnoses) == 0 {
+				t.Fatal("sin diagnósticos")
+			}
+			if got := resp.Diagnoses[0].Proof != nil; got != tt.wantProof {
+				t.Errorf("prueba presente = %t, want %t", got, tt.wantProof)
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+/* ===========================================================
+   Prueba diferencial: motor Prolog vs motor nativo
+   =========================================================== */
+
+const (
+	differentialRandom   = 250 // casos aleatorios por KB de prueba
+	maxDifferentialDiffs = 20  // rutas reportadas por caso
+)
+
+func intp(n int) *int { return &n }
+
+// fixtureRespiratorio: pesos, requeridos, excluyentes, duración/inicio, jerarquía,
+// banderas rojas, bayes, líneas, interacciones, contraindicaciones y posologías.
+func fixtureRespiratorio() Snapshot {
+	return Snapshot{
+		Symptoms: []Symptom{
+			{ID: "fiebre"}, {ID: "tos"}, {ID: "cefalea"}, {ID: "rinorrea"}, {ID: "dolor_garganta"},
+			{ID: "dolor_pecho"}, {ID: "disnea"},
+			{ID: "sibilancias", Parents: []string{"disnea"}},
+			{ID: "estridor", Parents: []string{"sibilancias"}},
+			{ID: "tos_seca", Parents: []string{"tos"}},
+		},
+		Diseases: []Disease{
+			{ID: "gripe", Name: "Gripe", System: "respiratorio", Type: "viral",
+				Symptoms: []string{"fiebre", "tos", "cefalea", "rinorrea"}, Weights: map[string]int{"fiebre": 3, "tos": 2},
+				Required: []string{"fiebre"}, AbsentPenalty: 10, DurationMin: 2, DurationMax: 7, Onset: "subito",
+				Prevalence: 0.05, Sensitivity: map[string]float64{"fiebre": 0.9, "tos": 0.6}},
+			{ID: "neumonia", Name: "Neumonía", System: "respiratorio", Type: "bacteriano",
+				Symptoms: []string{"fiebre", "tos", "disnea", "dolor_pecho"}, Weights: map[string]int{"disnea": 4},
+				RedFlags: map[string]int{"disnea": 2}, ContraMeds: []string{"ibuprofeno"}, AgeMin: 50},
+			{ID: "asma", Name: "Asma", System: "respiratorio", Type: "cronico",
+				Symptoms: []string{"sibilancias", "disnea", "tos"}, Weights: map[string]int{"sibilancias": 5},
+				Required: []string{"sibilancias"}, Excludes: []string{"fiebre"}, AgeMin: 5, AgeMax: 40,
+				DurationMin: 1, DurationMax: 30, Onset: "gradual", Prevalence: 0.08},
+			{ID: "faringitis", Name: "Faringitis", System: "otorrino", Type: "bacteriano",
+				Symptoms: []string{"dolor_garganta", "fiebre"}, AbsentPenalty: 15, Excludes: []string{"rinorrea"}},
+			{ID: "resfriado", Name: "Resfriado", System: "respiratorio", Type: "viral",
+				Symptoms: []string{"rinorrea", "tos", "dolor_garganta", "cefalea"}, Prevalence: 0.2},
+		},
+		Medications: []Medication{
+			{ID: "paracetamol", Treats: []string{"gripe", "resfriado", "faringitis"},
+				Interactions: map[string]string{"warfarina": "moderada"},
+				Dosages: []Dosage{
+					{AgeMin: 0, AgeMax: 11, Dose: 15, Unit: "mg_kg", Route: "oral", EveryHours: 6, MaxDaily: 2000},
+					{AgeMin: 12, Dose: 1000, Unit: "mg", Route: "oral", EveryHours: 6, MaxDaily: 3000},
+				}},
+			{ID: "ibuprofeno", Treats: []string{"gripe", "faringitis", "resfriado"}, Contra: []string{"ulcera", "alergia_aine"},
+				MinAge: 1, PregnancyContra: true, Interactions: map[string]string{"warfarina": "grave"},
+				TreatLines: map[string]TherapyLine{"gripe": {Line: 2, Rank: 1}},
+				Dosages: []Dosage{
+					{WeightMin: 10, WeightMax: 40, Dose: 10, Unit: "mg_kg", Route: "oral", EveryHours: 8, MaxDaily: 1200},
+					{WeightMin: 40, Dose: 400, Unit: "mg", Route: "oral", EveryHours: 7, MaxDaily: 1200},
+				}},
+			{ID: "amoxicilina", Treats: []string{"neumonia", "faringitis"}, Contra: []string{"alergia_penicilina"},
+				TreatLines: map[string]TherapyLine{"neumonia": {Line: 1, Rank: 1}, "faringitis": {Line: 1, Rank: 1}},
+				Dosages:    []Dosage{{Dose: 25, Unit: "mg_kg", Route: "oral", EveryHours: 8, MaxDaily: 3000}}},
+			{ID: "azitromicina", Treats: []string{"neumonia", "faringitis"}, MinAge: 6,
+				TreatLines:   map[string]TherapyLine{"neumonia": {Line: 1, Rank: 2}},
+				Interactions: map[string]string{"warfarina": "leve", "salbutamol": "moderada"},
+				Dosages:      []Dosage{{Dose: 500, Unit: "mg", Route: "oral", EveryHours: 24, MaxDaily: 500}}},
+			{ID: "salbutamol", Treats: []string{"asma"}, Contra: []string{"arritmia"},
+				Dosages: []Dosage{{AgeMin: 4, Dose: 0.2, Unit: "mg", Route: "inhalada", EveryHours: 4, MaxDaily: 1}}},
+			{ID: "warfarina", Treats: []string{}, Contra: []string{"ulcera"}},
+		},
+		RedFlags: []RedFlag{
+			{ID: "dolor_pecho_agudo", Symptoms: []string{"dolor_pecho"}, MinSeverity: 2, Level: "Atención prioritaria"},
+			{ID: "disnea_febril", Symptoms: []string{"disnea", "fiebre"}, MinSeverity: 2, Level: "Consulta recomendada"},
+		},
+	}
+}
+
+// fixtureJerarquia: cadena de síntomas al tope de niveles y sin banderas rojas globales
+// (rules.pl no trae banderas de respaldo).
+func fixtureJerarquia() Snapshot {
+	snap := Snapshot{
+		Symptoms: []Symptom{{ID: "disnea"}, {ID: "dolor_pecho"}, {ID: "n0"}},
+		Diseases: []Disease{
+			{ID: "cardiopatia", Name: "Cardiopatía", System: "cardio", Type: "cronico",
+				Symptoms: []string{"dolor_pecho", "disnea", "n0"}, Weights: map[string]int{"n0": 2}},
+			{ID: "ansiedad", Name: "Ansiedad", System: "mental", Type: "funcional",
+				Symptoms: []string{"n3", "disnea"}, Excludes: []string{"n9"}},
+		},
+		Medications: []Medication{
+			{ID: "nitroglicerina", Treats: []string{"cardiopatia"}, Contra: []string{"hipotension"}},
+			{ID: "ansiolitico", Treats: []string{"ansiedad", "cardiopatia"}, PregnancyContra: true},
+		},
+	}
+	for i := 1; i <= 9; i++ { // n9 -> n8 -> ... -> n0
+		snap.Symptoms = append(snap.Symptoms, Symptom{ID: "n" + strconv.Itoa(i), Parents: []string{"n" + strconv.Itoa(i-1)}})
+	}
+	return snap
+}
+
+// fixtureManual: KB escrita a mano (varios hechos por línea, hechos partidos en varias
+// líneas, orden de cláusulas distinto del alfabético y hechos repetidos).
+const fixtureManual = `% KB de prueba escrita a mano
+sintoma(tos). sintoma(fiebre).
+sintoma(disnea).
+sintoma(dolor_pecho). sintoma(sibilancias).
+sintoma_padre(sibilancias,
+    disnea).
+enfermedad(neumonia, "Neumonía", respiratorio, bacteriano).
+enfermedad(bronquitis, "Bronquitis",
+    respiratorio, viral).
+enfermedad(asma, "Asma", respiratorio, cronico).
+enf_sintoma(neumonia, fiebre, 3). enf_sintoma(neumonia, tos, 2).
+enf_sintoma(neumonia, disnea, 2).
+enf_sintoma(bronquitis, tos, 3).
+enf_sintoma(bronquitis, fiebre, 1).
+enf_sintoma(asma, sibilancias, 3). enf_sintoma(asma, disnea, 2). enf_sintoma(asma, tos, 1).
+medicamento(salbutamol). medicamento(claritromicina). medicamento(amoxicilina).
+medicamento(paracetamol). medicamento(ibuprofeno).
+trata(claritromicina, neumonia). trata(amoxicilina, neumonia).
+trata(paracetamol, bronquitis). trata(ibuprofeno, bronquitis). trata(paracetamol, neumonia).
+trata(salbutamol, asma).
+contraindicado(amoxicilina, alergia_penicilina).
+contraindicado(claritromicina, hepatopatia).
+contraindicado(ibuprofeno, ulcera).
+interaccion(salbutamol, claritromicina, moderada).
+interaccion(claritromicina, salbutamol, leve).
+interaccion(ibuprofeno, paracetamol, grave).
+posologia(amoxicilina, 0, 12, 0, 0, 25, mg_kg, oral, 8, 1500).
+posologia(amoxicilina, 12, 0, 0, 0, 500, mg, oral, 8, 3000).
+posologia(paracetamol, 0, 0, 0, 0, 1000,
+    mg, oral, 6, 3000).
+edad_minima(claritromicina, 2). edad_minima(claritromicina, 6).
+contra_embarazo(ibuprofeno).
+bandera_roja(dolor_pecho_severo, [dolor_pecho], 3, "Atención prioritaria").
+bandera_roja(disnea_fiebre,
+    [disnea, fiebre], 2, "Consulta recomendada").
+enf_bandera_roja(neumonia, disnea, 3).
+enf_edad(asma, 5, 40). enf_edad(asma, 2, 60).
+enf_requerido(asma, sibilancias).
+enf_excluye(bronquitis, sibilancias).
+penalizacion_ausente(bronquitis, 20).
+prevalencia(neumonia, 0.02).
+sensibilidad(neumonia, fiebre, 0.9).
+enf_duracion(bronquitis, 3, 21).
+enf_inicio(neumonia, subito).
+linea_tratamiento(amoxicilina, neumonia, 1, 1).
+linea_tratamiento(claritromicina, neumonia, 1, 2).
+`
+
+type differentialFixture struct {
+	name  string
+	kb    []byte
+	seed  int64
+	cases []BatchCase // además de los aleatorios
+}
+
+func differentialFixtures(t *testing.T) []differentialFixture {
+	t.Helper()
+	render := func(s Snapshot) []byte {
+		kb, err := renderPLFromSnapshot(s)
+		if err != nil {
+			t.Fatalf("fixture inválida: %v", err)
+		}
+		return kb
+	}
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	no := func(id string) SymptomEntry { return SymptomEntry{ID: id} }
+	return []differentialFixture{
+		{name: "respiratorio", kb: render(fixtureRespiratorio()), seed: 7, cases: []BatchCase{
+			{ID: "negado", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("tos", "severo"), sym("cefalea", "leve"), no("fiebre")}}},
+			{ID: "requerido_faltante", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("disnea", "severo"), sym("tos", "severo")}}},
+			{ID: "jerarquia", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("estridor", "moderado"), sym("tos_seca", "leve")}}},
+			{ID: "jerarquia_bayes_duracion", DiagnoseReq: DiagnoseReq{Mode: modeBayes, Symptoms: []SymptomEntry{
+				{ID: "tos_seca", Severity: "moderado", Present: true, DurationDays: 4, Onset: "subito"},
+				{ID: "estridor", Severity: "leve", Present: true, DurationDays: 40}, no("tos"), no("disnea")}}},
+			{ID: "excluyente", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("sibilancias", "severo"), sym("fiebre", "leve")}}},
+			{ID: "excluyente_bajo_minimo", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("rinorrea", "leve"), sym("tos", "leve")},
+				Options: DiagnoseOptions{MinAffinity: 60}}},
+			{ID: "duracion_inicio", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{
+				{ID: "fiebre", Severity: "moderado", Present: true, DurationDays: 3, Onset: "subito"},
+				{ID: "tos", Severity: "leve", Present: true, DurationDays: 20, Onset: "gradual"},
+				{ID: "tos", Severity: "moderado", Present: true, DurationDays: 5}}}},
+			{ID: "posologia_peso", DiagnoseReq: DiagnoseReq{Age: intp(8), WeightKg: 25,
+				Symptoms: []SymptomEntry{sym("fiebre", "severo"), sym("dolor_garganta", "moderado")}}},
+			{ID: "posologia_tope", DiagnoseReq: DiagnoseReq{Age: intp(40), WeightKg: 90, Allergies: []string{"alergia_penicilina"},
+				Symptoms: []SymptomEntry{sym("fiebre", "severo"), sym("dolor_garganta", "moderado")}}},
+			{ID: "interacciones", DiagnoseReq: DiagnoseReq{Age: intp(30), CurrentMeds: []string{"warfarina", "salbutamol"},
+				Symptoms: []SymptomEntry{sym("fiebre", "moderado"), sym("tos", "moderado"), sym("disnea", "moderado")}}},
+			{ID: "bloqueos", DiagnoseReq: DiagnoseReq{Age: intp(3), Sex: "f", Chronics: []string{"ulcera"},
+				Symptoms: []SymptomEntry{sym("fiebre", "moderado"), sym("rinorrea", "leve")}}},
+			{ID: "bayes_edad", DiagnoseReq: DiagnoseReq{Mode: modeBayes, Age: intp(70),
+				Symptoms: []SymptomEntry{sym("fiebre", "moderado"), sym("disnea", "severo"), no("tos"), no("sibilancias")}}},
+			{ID: "filtros", DiagnoseReq: DiagnoseReq{Mode: modeBayes, Options: DiagnoseOptions{MinAffinity: 10, Limit: 2, Systems: []string{"respiratorio"}, SkipZero: true},
+				Symptoms: []SymptomEntry{sym("rinorrea", "moderado"), sym("tos", "leve")}}},
+		}},
+		{name: "jerarquia", kb: render(fixtureJerarquia()), seed: 3, cases: []BatchCase{
+			{ID: "cadena", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("n9", "severo")}}},
+			{ID: "sin_banderas", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("disnea", "moderado"), sym("dolor_pecho", "leve")}}},
+		}},
+		{name: "manual", kb: []byte(fixtureManual), seed: 11, cases: []BatchCase{
+			{ID: "orden_trata", DiagnoseReq: DiagnoseReq{Symptoms: []SymptomEntry{sym("tos", "severo"), sym("fiebre", "leve")}}},
+			{ID: "interaccion_ambos_sentidos", DiagnoseReq: DiagnoseReq{Age: intp(4), CurrentMeds: []string{"salbutamol", "paracetamol"},
+				Symptoms: []SymptomEntry{sym("fiebre", "severo"), sym("disnea", "severo")}}},
+			{ID: "edad_minima_repetida", DiagnoseReq: DiagnoseReq{Age: intp(3), WeightKg: 14, Mode: modeBayes,
+				Symptoms: []SymptomEntry{sym("fiebre", "moderado"), sym("sibilancias", "moderado"), no("tos")}}},
+		}},
+	}
+}
+
+func TestDifferentialPrologNative(t *testing.T) {
+	for _, fx := range differentialFixtures(t) {
+		t.Run(fx.name, func(t *testing.T) {
+			eng := newKBEngine(1, fx.kb)
+			if eng.err != nil {
+				t.Fatalf("la KB no compila: %v", eng.err)
+			}
+			cases := append(fx.cases, randomCases(eng.snapshot(), rand.New(rand.NewSource(fx.seed)), differentialRandom)...)
+			for _, c := range cases {
+				a, errA := prologEngine{}.Diagnose(context.Background(), eng, c.DiagnoseReq)
+				b, errB := nativeEngine{}.Diagnose(context.Background(), eng, c.DiagnoseReq)
+				if errA != nil || errB != nil {
+					t.Fatalf("caso %s: prolog: %v; nativo: %v", c.ID, errA, errB)
+				}
+				if diffs := diffResponses(a, b); len(diffs) > 0 {
+					req, _ := json.Marshal(c.DiagnoseReq)
+					t.Errorf("caso %s: difieren %v\nrequest: %s", c.ID, diffs, req)
+				}
+				qa, errA := prologEngine{}.NextQuestions(context.Background(), eng, c.DiagnoseReq, 5)
+				qb, errB := nativeEngine{}.NextQuestions(context.Background(), eng, c.DiagnoseReq, 5)
+				if errA != nil || errB != nil {
+					t.Fatalf("caso %s (next-question): prolog: %v; nativo: %v", c.ID, errA, errB)
+				}
+				if diffs := diffValues(qa, qb); len(diffs) > 0 {
+					req, _ := json.Marshal(c.DiagnoseReq)
+					t.Errorf("caso %s (next-question): difieren %v\nrequest: %s", c.ID, diffs, req)
+				}
+			}
+		})
+	}
+}
+
+// La KB escrita a mano se lee del intérprete, no línea por línea.
+func TestSnapshotFromInterpreter(t *testing.T) {
+	eng := newKBEngine(1, []byte(fixtureManual))
+	if eng.err != nil {
+		t.Fatal(eng.err)
+	}
+	snap := eng.snapshot()
+	if len(snap.Symptoms) != 5 || len(snap.Diseases) != 3 || len(snap.Medications) != 5 || len(snap.RedFlags) != 2 {
+		t.Fatalf("snapshot incompleto: %d síntomas, %d enfermedades, %d medicamentos, %d banderas",
+			len(snap.Symptoms), len(snap.Diseases), len(snap.Medications), len(snap.RedFlags))
+	}
+	for _, s := range snap.Symptoms {
+		if s.ID == "sibilancias" && !reflect.DeepEqual(s.Parents, []string{"disnea"}) {
+			t.Errorf("sibilancias: parents = %v", s.Parents)
+		}
+	}
+	for _, d := range snap.Diseases {
+		switch d.ID {
+		case "bronquitis":
+			if d.Name != "Bronquitis" || d.Type != "viral" || !reflect.DeepEqual(d.Symptoms, []string{"tos", "fiebre"}) {
+				t.Errorf("bronquitis: %+v", d)
+			}
+		case "asma":
+			if d.AgeMin != 5 || d.AgeMax != 40 { // gana el primer enf_edad/3, como en fuera_rango_edad/3
+				t.Errorf("asma: enf_edad = %d-%d, want 5-40", d.AgeMin, d.AgeMax)
+			}
+		}
+	}
+	for _, m := range snap.Medications {
+		switch m.ID {
+		case "paracetamol":
+			if !reflect.DeepEqual(m.Treats, []string{"bronquitis", "neumonia"}) || len(m.Dosages) != 1 || m.Dosages[0].EveryHours != 6 {
+				t.Errorf("paracetamol: %+v", m)
+			}
+		case "claritromicina":
+			if m.MinAge != 2 || m.Interactions["salbutamol"] != "leve" {
+				t.Errorf("claritromicina: %+v", m)
+			}
+		}
+	}
+	want := RedFlag{ID: "disnea_fiebre", Symptoms: []string{"disnea", "fiebre"}, MinSeverity: 2, Level: "Consulta recomendada"}
+	if !reflect.DeepEqual(snap.RedFlags[0], want) {
+		t.Errorf("bandera_roja = %+v, want %+v", snap.RedFlags[0], want)
+	}
+}
+
+// diffResponses compara ambas respuestas como JSON genérico (sin "explanations").
+func diffResponses(a, b DiagnoseResp) []string {
+	a.Explanations, b.Explanations = "", ""
+	return diffValues(a, b)
+}
+
+// diffValues compara dos valores por su forma JSON.
+func diffValues(a, b any) []string {
+	var ga, gb any
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	_ = json.Unmarshal(ja, &ga)
+	_ = json.Unmarshal(jb, &gb)
+	var diffs []string
+	diffJSON("", ga, gb, &diffs)
+	return diffs
+}
+
+func diffJSON(path string, a, b any, diffs *[]string) {
+	if len(*diffs) >= maxDifferentialDiffs {
+		return
+	}
+	switch va := a.(type) {
+	case map[string]any:
+		vb, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for k := range va {
+			keys[k] = true
+		}
+		for k := range vb {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			diffJSON(p, va[k], vb[k], diffs)
+		}
+		return
+	case []any:
+		vb, ok := b.([]any)
+		if !ok || len(va) != len(vb) {
+			break
+		}
+		for i := range va {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), va[i], vb[i], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, path)
+	}
+}
+
+// randomCases arma casos de prueba con los síntomas, contraindicaciones y medicamentos de la KB.
+func randomCases(snap *Snapshot, rnd *rand.Rand, n int) []BatchCase {
+	var syms, conds, meds, systems, types []string
+	for _, s := range snap.Symptoms {
+		syms = append(syms, s.ID)
+	}
+	for _, m := range snap.Medications {
+		meds = append(meds, m.ID)
+		conds = append(conds, m.Contra...)
+	}
+	for _, d := range snap.Diseases {
+		systems = append(systems, d.System)
+		types = append(types, d.Type)
+	}
+	conds, systems, types = uniq(conds), uniq(systems), uniq(types)
+	pick := func(xs []string, max int) []string {
+		var out []string
+		if len(xs) == 0 {
+			return out
+		}
+		for k := rnd.Intn(max + 1); k > 0; k-- {
+			out = append(out, xs[rnd.Intn(len(xs))])
+		}
+		return out
+	}
+
+	cases := make([]BatchCase, 0, n)
+	for i := 0; i < n; i++ {
+		var req DiagnoseReq
+		for _, id := range pick(syms, 6) {
+			e := SymptomEntry{ID: id, Present: rnd.Intn(4) > 0, Severity: severityScale[rnd.Intn(len(severityScale))]}
+			if e.Present && rnd.Intn(3) == 0 {
+				e.DurationDays = 1 + rnd.Intn(30)
+			}
+			if e.Present && rnd.Intn(3) == 0 {
+				e.Onset = []string{"subito", "gradual"}[rnd.Intn(2)]
+			}
+			req.Symptoms = append(req.Symptoms, e)
+		}
+		req.Allergies = pick(conds, 1)
+		req.Chronics = pick(conds, 1)
+		req.CurrentMeds = pick(meds, 2)
+		if rnd.Intn(2) == 0 {
+			age := rnd.Intn(90)
+			req.Age = &age
+		}
+		if rnd.Intn(3) == 0 {
+			req.WeightKg = float64(5 + rnd.Intn(95))
+		}
+		if rnd.Intn(5) == 0 {
+			req.Sex, req.Pregnant = "f", true
+		}
+		if rnd.Intn(2) == 0 {
+			req.Mode = modeBayes
+		}
+		if rnd.Intn(4) == 0 {
+			req.Options = DiagnoseOptions{
+				MinAffinity: rnd.Intn(40),
+				Limit:       rnd.Intn(4),
+				Systems:     pick(systems, 1),
+				Types:       pick(types, 1),
+				SkipZero:    rnd.Intn(2) == 0,
+			}
+		}
+		if validateDemographics(&req) != nil {
+			req.Age, req.Pregnant = nil, false
+		}
+		cases = append(cases, BatchCase{ID: strconv.Itoa(i), DiagnoseReq: req})
+	}
+	return cases
+}
@@ -0,0 +1,265 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	iprolog "github.com/ichiban/prolog"
+)
+
+/* ===========================================================
+   Motor Prolog compartido (rules.pl + KB compilados una vez)
+   =========================================================== */
+
+// Cantidad de intérpretes ociosos que se conservan por versión de KB.
+const enginePoolSize = 8
+
+// Hechos de sesión: se limpian al devolver un intérprete al pool.
+var sessionPreds = []string{
+	"presente(_, _)",
+	"alergia(_)",
+	"cronica(_)",
+	"ausente(_)",
+	"edad(_)",
+	"sexo(_)",
+	"embarazo",
+	"peso_kg(_)",
+	"duracion(_, _)",
+	"inicio(_, _)",
+	"toma(_)",
+}
+
+// kbEngine es una versión inmutable de reglas + KB. Cada request toma un
+// intérprete exclusivo del pool (copia aislada) y lo devuelve al terminar.
+type kbEngine struct {
+	version int64
+	rules   string
+	kb      string
+	err     error // error de compilación (se reporta en cada acquire)
+	consts  ruleConsts
+	pool    chan *iprolog.Interpreter
+
+	factsMu  sync.Mutex
+	kbFacts  *kbFacts // hechos leídos del intérprete compilado, ver facts()
+	factsErr error
+}
+
+type engineManager struct {
+	mu  sync.Mutex // serializa cargas/recargas
+	cur atomic.Pointer[kbEngine]
+	seq int64
+}
+
+var engines = &engineManager{}
+
+// current devuelve la versión vigente; la primera vez lee rules.pl y la KB de disco.
+// Si la KB de disco no compila, la versión queda con el error (cada acquire lo reporta).
+func (m *engineManager) current() *kbEngine {
+	if e := m.cur.Load(); e != nil {
+		return e
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.cur.Load(); e != nil {
+		return e
+	}
+	kb, err := readKB()
+	if err != nil {
+		kb = []byte{}
+	}
+	m.seq++
+	e := newKBEngine(m.seq, kb)
+	if e.err != nil {
+		log.Printf("prolog engine v%d: %v\n", e.version, e.err)
+	}
+	m.cur.Store(e)
+	return e
+}
+
+// errInvalidKB: la KB nueva no compila; la versión vigente no se toca.
+var errInvalidKB = errors.New("kb inválida")
+
+// reload compila una nueva versión con la KB dada y, solo si compila, ejecuta
+// persist (ej. escribir el archivo) y la publica de forma atómica.
+// Los requests en curso terminan con la versión anterior.
+func (m *engineManager) reload(kb []byte, persist func() error) (*kbEngine, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := newKBEngine(m.seq+1, kb)
+	if e.err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidKB, e.err)
+	}
+	if persist != nil {
+		if err := persist(); err != nil {
+			return nil, err
+		}
+	}
+	m.seq = e.version
+	m.cur.Store(e)
+	return e, nil
+}
+
+// newKBEngine compila rules.pl + kb; el error de compilación queda en e.err.
+func newKBEngine(version int64, kb []byte) *kbEngine {
+	e := &kbEngine{
+		version: version,
+		kb:      string(stripBOM(kb)),
+		pool:    make(chan *iprolog.Interpreter, enginePoolSize),
+	}
+	rules, err := readRules()
+	if err != nil {
+		e.err = err
+		return e
+	}
+	e.rules = string(rules)
+	// Compila una vez para validar y deja el intérprete listo en el pool; las
+	// constantes se leen de rules.pl aunque la KB no compile
+	if p, err := e.compileWith(func(s *session) { e.consts = readRuleConsts(s) }); err != nil {
+		e.err = err
+	} else {
+		e.pool <- p
+	}
+	return e
+}
+
+// compile carga rules.pl + KB con los mismos límites que una consulta
+// (una directiva cíclica en la KB no cuelga la recarga).
+func (e *kbEngine) compile() (*iprolog.Interpreter, error) {
+	return e.compileWith(nil)
+}
+
+// compileWith es compile; onRules (si no es nil) corre con rules.pl ya cargado y antes de la KB.
+func (e *kbEngine) compileWith(onRules func(*session)) (*iprolog.Interpreter, error) {
+	s := newSession(context.Background(), iprolog.New(nil, nil))
+	defer s.cancel()
+	if err := s.Exec(e.rules); err != nil {
+		if le := s.limitErr(); le != nil {
+			err = le
+		}
+		return nil, fmt.Errorf("prolog rules error: %w", err)
+	}
+	if onRules != nil {
+		onRules(s)
+	}
+	if e.kb != "" {
+		if err := s.Exec(e.kb); err != nil {
+			if le := s.limitErr(); le != nil {
+				err = le
+			}
+			return nil, fmt.Errorf("prolog kb error: %w", err)
+		}
+	}
+	return s.Interpreter, nil
+}
+
+// facts devuelve los hechos de esta versión tal como los ve rules.pl (se leen una
+// sola vez de un intérprete del pool, sin importar cómo esté escrito el archivo).
+// Un corte por límite no queda guardado: el próximo llamado reintenta.
+func (e *kbEngine) facts() (*kbFacts, error) {
+	e.factsMu.Lock()
+	defer e.factsMu.Unlock()
+	if e.kbFacts != nil || e.factsErr != nil {
+		return e.kbFacts, e.factsErr
+	}
+	s, err := e.acquire(context.Background())
+	if err != nil {
+		e.factsErr = err
+		return nil, err
+	}
+	defer e.release(s)
+	f, err := loadKBFacts(s)
+	var le *LimitError
+	if errors.As(err, &le) {
+		return nil, err
+	}
+	e.kbFacts, e.factsErr = f, err
+	return f, err
+}
+
+// snapshot devuelve la KB de esta versión como Snapshot (vacío si no compila).
+func (e *kbEngine) snapshot() *Snapshot {
+	f, err := e.facts()
+	if err != nil {
+		empty := defaultEmptySnapshot()
+		return &empty
+	}
+	return &f.snap
+}
+
+// acquire entrega un intérprete de uso exclusivo (del pool o recién compilado),
+// limitado por el contexto del request.
+func (e *kbEngine) acquire(ctx context.Context) (*session, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	select {
+	case p := <-e.pool:
+		return newSession(ctx, p), nil
+	default:
+		p, err := e.compile()
+		if err != nil {
+			return nil, err
+		}
+		return newSession(ctx, p), nil
+	}
+}
+
+// release limpia los hechos de sesión y devuelve el intérprete al pool.
+// Si la consulta se cortó por un límite, la limpieza falla o el pool está lleno,
+// el intérprete se descarta.
+func (e *kbEngine) release(s *session) {
+	if s == nil {
+		return
+	}
+	cut := s.limitErr() != nil // antes de cancel: después siempre reporta cancelado
+	s.cancel()
+	if cut {
+		return
+	}
+	p := s.Interpreter
+	var b strings.Builder
+	for _, h := range sessionPreds {
+		fmt.Fprintf(&b, ":- retractall(%s).\n", h)
+	}
+	if err := p.Exec(b.String()); err != nil {
+		return
+	}
+	select {
+	case e.pool <- p:
+	default:
+	}
+}
+
+/* ===========================================================
+   Constantes de rules.pl (una sola definición, la de las reglas)
+   =========================================================== */
+
+type ruleConsts struct {
+	hierarchyCap int // tope_jerarquia/1: niveles de ancestros que sigue ancestro/2
+}
+
+func readRuleConsts(s *session) ruleConsts {
+	return ruleConsts{
+		hierarchyCap: ruleInt(s, "tope_jerarquia"),
+	}
+}
+
+// ruleInt: valor de una constante Pred(N) de rules.pl (0 si no está).
+func ruleInt(s *session, pred string) int {
+	q, err := s.Query(pred + "(N).")
+	if err != nil {
+		return 0
+	}
+	defer q.Close()
+	var row struct{ N int }
+	if q.Next() {
+		_ = q.Scan(&row)
+	}
+	return row.N
+}
@@ -0,0 +1,80 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEngineReload(t *testing.T) {
+	errDisk := errors.New("disco lleno")
+	tests := []struct {
+		name        string
+		kb          string
+		persistErr  error
+		wantErr     error
+		wantPersist bool
+		wantVersion int64 // versión vigente después de la recarga
+	}{
+		{"kb válida", "sintoma(tos).", nil, nil, true, 2},
+		{"kb que no compila", "sintoma(tos", nil, errInvalidKB, false, 1},
+		{"directiva que falla", ":- no_existe(x).", nil, errInvalidKB, false, 1},
+		{"falla al persistir", "sintoma(tos).", errDisk, errDisk, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &engineManager{}
+			if _, err := m.reload([]byte("sintoma(fiebre)."), nil); err != nil {
+				t.Fatal(err)
+			}
+			persisted := false
+			_, err := m.reload([]byte(tt.kb), func() error { persisted = true; return tt.persistErr })
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reload: %v, want %v", err, tt.wantErr)
+			}
+			if persisted != tt.wantPersist {
+				t.Errorf("persist llamado = %v, want %v", persisted, tt.wantPersist)
+			}
+			if v := m.current().version; v != tt.wantVersion {
+				t.Errorf("versión vigente %d, want %d", v, tt.wantVersion)
+			}
+		})
+	}
+}
+
+// Un intérprete devuelto al pool no arrastra hechos de la sesión anterior.
+func TestEngineReleaseClearsSession(t *testing.T) {
+	e := newKBEngine(1, []byte("sintoma(tos)."))
+	if e.err != nil {
+		t.Fatal(e.err)
+	}
+	req := DiagnoseReq{Age: intp(30), Allergies: []string{"penicilina"}, CurrentMeds: []string{"ibuprofeno"},
+		Symptoms: []SymptomEntry{{ID: "tos", Severity: "leve", Present: true, DurationDays: 3, Onset: "subito"}, {ID: "fiebre"}}}
+	s, err := e.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertSession(s, req); err != nil {
+		t.Fatal(err)
+	}
+	e.release(s)
+	if n := len(e.pool); n != 1 {
+		t.Fatalf("pool con %d intérpretes, want 1", n)
+	}
+	s, err = e.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.release(s)
+	for _, h := range sessionPreds {
+		q, err := s.Query(h + ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Next() {
+			t.Errorf("%s sigue asertado", h)
+		}
+		q.Close()
+	}
+}
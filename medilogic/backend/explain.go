@@ -0,0 +1,570 @@
+//go:build !rpa
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* ===========================================================
+   Trazas de prueba (RulesFired reales + modo ?explain=1)
+   =========================================================== */
+
+// urgencyTrace: cláusula de urgencia_motivo/4 (urgencia/1) o urgencia_enf_motivo/5 (urgencia/2)
+type urgencyTrace struct {
+	Level   string        // "Atención prioritaria", ...
+	Rule    string        // bandera_roja | bandera_roja_enf | sintoma_severo | tipo_enfermedad | conteo_sintomas | caso_base
+	Symptom string        // síntoma que disparó la cláusula (id de bandera_roja/4, tipo de enfermedad, o sintomas/ninguno)
+	Value   int           // peso observado o conteo
+	Flag    []flagSymptom // bandera_roja: síntomas que la activaron
+}
+
+// flagSymptom: síntoma de una bandera_roja/4 con la severidad mínima y la observada
+type flagSymptom struct {
+	S      string
+	Min, P int
+}
+
+func queryFlag(p *session, id string) []flagSymptom {
+	var out []flagSymptom
+	q, err := p.Query(fmt.Sprintf(`traza_bandera(%s, S, Min, P).`, safeAtom(id)))
+	if err != nil {
+		return out
+	}
+	defer q.Close()
+	for q.Next() {
+		var row struct {
+			S      string
+			Min, P int
+		}
+		if err := q.Scan(&row); err == nil {
+			out = append(out, flagSymptom{S: row.S, Min: row.Min, P: row.P})
+		}
+	}
+	return out
+}
+
+// urgencyRank ordena los niveles de urgencia (mayor = más urgente).
+func urgencyRank(level string) int {
+	switch level {
+	case "Atención prioritaria":
+		return 3
+	case "Consulta recomendada":
+		return 2
+	case "Observación recomendada":
+		return 1
+	}
+	return 0
+}
+
+// blockReason: hecho que descartó un medicamento que trata la enfermedad
+type blockReason struct {
+	Med  string
+	Kind string // alergia | cronica | enfermedad | edad | embarazo | interaccion
+	Cond string // edad: edad mínima del medicamento; interaccion: medicamento que ya toma
+}
+
+// scorePair: síntoma que sumó al puntaje con su severidad normalizada y el peso del vínculo
+type scorePair struct {
+	S   string
+	P   int
+	W   int
+	Via string // síntoma informado más específico que aportó el peso (sintoma_padre/2); "" = S
+}
+
+func queryUrgency(p *session) urgencyTrace {
+	ut := urgencyTrace{Level: "Observación recomendada", Rule: "caso_base", Symptom: "ninguno"}
+	q, err := p.Query(`urgencia_motivo(U, R, S, P).`)
+	if err != nil {
+		return ut
+	}
+	defer q.Close()
+	if q.Next() {
+		var row struct {
+			U, R, S string
+			P       int
+		}
+		if err := q.Scan(&row); err == nil && row.U != "" {
+			ut = urgencyTrace{Level: row.U, Rule: row.R, Symptom: row.S, Value: row.P}
+		}
+	}
+	if ut.Rule == "bandera_roja" {
+		ut.Flag = queryFlag(p, ut.Symptom)
+	}
+	return ut
+}
+
+func queryDiseaseUrgency(p *session, enfID string) urgencyTrace {
+	ut := urgencyTrace{Level: "Observación recomendada", Rule: "caso_base", Symptom: "ninguno"}
+	q, err := p.Query(fmt.Sprintf(`urgencia_enf_motivo(%s, U, R, S, P).`, safeAtom(enfID)))
+	if err != nil {
+		return ut
+	}
+	defer q.Close()
+	if q.Next() {
+		var row struct {
+			U, R, S string
+			P       int
+		}
+		if err := q.Scan(&row); err == nil && row.U != "" {
+			ut = urgencyTrace{Level: row.U, Rule: row.R, Symptom: row.S, Value: row.P}
+		}
+	}
+	if ut.Rule == "bandera_roja" {
+		ut.Flag = queryFlag(p, ut.Symptom)
+	}
+	return ut
+}
+
+func queryScorePairs(p *session, enfID string) []scorePair {
+	var out []scorePair
+	q, err := p.Query(fmt.Sprintf(`traza_puntaje(%s, S, P, W).`, safeAtom(enfID)))
+	if err != nil {
+		return out
+	}
+	seen := map[string]struct{}{}
+	for q.Next() {
+		var row struct {
+			S    string
+			P, W int
+		}
+		if err := q.Scan(&row); err != nil {
+			continue
+		}
+		if _, ok := seen[row.S]; ok {
+			continue
+		}
+		seen[row.S] = struct{}{}
+		out = append(out, scorePair{S: row.S, P: row.P, W: row.W})
+	}
+	q.Close()
+	for i := range out {
+		out[i].Via = queryEvidence(p, out[i].S)
+	}
+	return out
+}
+
+// queryEvidence devuelve el descendiente informado que cuenta como evidencia de S ("" si es S).
+func queryEvidence(p *session, s string) string {
+	q, err := p.Query(fmt.Sprintf(`traza_evidencia(%s, D).`, safeAtom(s)))
+	if err != nil {
+		return ""
+	}
+	defer q.Close()
+	var row struct{ D string }
+	if q.Next() && q.Scan(&row) == nil && row.D != s {
+		return row.D
+	}
+	return ""
+}
+
+// deniedPair: síntoma de la enfermedad negado por el paciente; resta Pen x W puntos
+type deniedPair struct {
+	S   string
+	Pen int
+	W   int
+}
+
+func queryDenied(p *session, enfID string) []deniedPair {
+	var out []deniedPair
+	q, err := p.Query(fmt.Sprintf(`traza_negado(%s, S, Pen, W).`, safeAtom(enfID)))
+	if err != nil {
+		return out
+	}
+	defer q.Close()
+	for q.Next() {
+		var row struct {
+			S      string
+			Pen, W int
+		}
+		if err := q.Scan(&row); err == nil {
+			out = append(out, deniedPair{S: row.S, Pen: row.Pen, W: row.W})
+		}
+	}
+	return out
+}
+
+// timingAdj: ajuste de afinidad por duración o inicio de un síntoma (Pts con signo)
+type timingAdj struct {
+	S    string
+	Kind string // duracion | inicio
+	Pts  int
+}
+
+func queryTiming(p *session, enfID string) []timingAdj {
+	var out []timingAdj
+	q, err := p.Query(fmt.Sprintf(`traza_temporal(%s, S, T, Pts).`, safeAtom(enfID)))
+	if err != nil {
+		return out
+	}
+	defer q.Close()
+	for q.Next() {
+		var row struct {
+			S, T string
+			Pts  int
+		}
+		if err := q.Scan(&row); err == nil {
+			out = append(out, timingAdj{S: row.S, Kind: row.T, Pts: row.Pts})
+		}
+	}
+	return out
+}
+
+func queryMaxScore(p *session, enfID string) int {
+	q, err := p.Query(fmt.Sprintf(`max_puntaje_enf(%s, M).`, safeAtom(enfID)))
+	if err != nil {
+		return 0
+	}
+	defer q.Close()
+	var row struct{ M int }
+	if q.Next() {
+		_ = q.Scan(&row)
+	}
+	return row.M
+}
+
+func queryBlocked(p *session, enfID string) []blockReason {
+	var out []blockReason
+	q, err := p.Query(fmt.Sprintf(`motivo_bloqueo(%s, M, T, C).`, safeAtom(enfID)))
+	if err != nil {
+		return out
+	}
+	defer q.Close()
+	seen := map[blockReason]struct{}{}
+	for q.Next() {
+		var row struct{ M, T, C string }
+		if err := q.Scan(&row); err != nil {
+			continue
+		}
+		br := blockReason{Med: row.M, Kind: row.T, Cond: row.C}
+		if _, ok := seen[br]; ok {
+			continue
+		}
+		seen[br] = struct{}{}
+		out = append(out, br)
+	}
+	return out
+}
+
+// facts devuelve los hechos de la KB/sesión que sostienen el bloqueo.
+func (b blockReason) facts() []string {
+	switch b.Kind {
+	case "alergia", "cronica":
+		return []string{
+			fmt.Sprintf("%s(%s)", b.Kind, b.Cond),
+			fmt.Sprintf("contraindicado(%s, %s)", b.Med, b.Cond),
+		}
+	case "edad":
+		return []string{
+			fmt.Sprintf("edad_minima(%s, %s)", b.Med, b.Cond),
+			fmt.Sprintf("edad(E), E < %s", b.Cond),
+		}
+	case "embarazo":
+		return []string{"embarazo", fmt.Sprintf("contra_embarazo(%s)", b.Med)}
+	case "interaccion":
+		return []string{fmt.Sprintf("toma(%s)", b.Cond), fmt.Sprintf("interaccion(%s, %s, grave)", b.Med, b.Cond)}
+	default:
+		return []string{fmt.Sprintf("enf_contra_medicamento(%s, %s)", b.Cond, b.Med)}
+	}
+}
+
+// warning: texto para el paciente, ej: "ibuprofeno descartado: contraindicado en gripe".
+func (b blockReason) warning() string {
+	switch b.Kind {
+	case "alergia":
+		return fmt.Sprintf("%s descartado: contraindicado por %s", b.Med, b.Cond)
+	case "cronica":
+		return fmt.Sprintf("%s descartado: contraindicado en enfermedad crónica %s", b.Med, b.Cond)
+	case "edad":
+		return fmt.Sprintf("%s descartado: no indicado en menores de %s años", b.Med, b.Cond)
+	case "embarazo":
+		return fmt.Sprintf("%s descartado: contraindicado en embarazo", b.Med)
+	case "interaccion":
+		return fmt.Sprintf("%s descartado: interacción grave con %s", b.Med, b.Cond)
+	default:
+		return fmt.Sprintf("%s descartado: contraindicado en %s", b.Med, b.Cond)
+	}
+}
+
+func (b blockReason) rule() string {
+	switch b.Kind {
+	case "alergia":
+		return "bloqueado_por_alergia/1"
+	case "cronica":
+		return "bloqueado_por_cronica/1"
+	case "edad":
+		return "bloqueado_por_edad/1"
+	case "embarazo":
+		return "bloqueado_por_embarazo/1"
+	case "interaccion":
+		return "bloqueado_por_interaccion/1"
+	default:
+		return "bloqueado_por_enf/2"
+	}
+}
+
+// rulesFired lista las reglas que realmente intervinieron en el diagnóstico.
+func rulesFired(safeMeds []string, blocked []blockReason) []string {
+	rf := []string{"afinidad/3", "urgencia/2"}
+	if len(safeMeds) > 0 {
+		rf = append(rf, "medicamento_seguro/2")
+	}
+	seen := map[string]struct{}{}
+	for _, b := range blocked {
+		r := b.rule()
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+		rf = append(rf, r)
+	}
+	return rf
+}
+
+// buildProof arma el árbol afinidad + urgencia + medicamentos de una enfermedad.
+func buildProof(enfID string, aff, max int, pairs []scorePair, denied []deniedPair, timing []timingAdj, missing []string, reqCap int, ut urgencyTrace, safeMeds []TherapyOption, blocked []blockReason) *ProofNode {
+	root := ProofNode{Goal: fmt.Sprintf("diagnostico(%s)", enfID)}
+
+	// afinidad/3: cada síntoma que sumó = enf_sintoma/3 + presentepeso/2
+	affNode := ProofNode{
+		Goal: fmt.Sprintf("afinidad(%s, %d)", enfID, aff),
+		Rule: "afinidad/3",
+		Children: []ProofNode{
+			{Goal: fmt.Sprintf("max_puntaje_enf(%s, %d)", enfID, max), Rule: "max_puntaje_enf/2"},
+		},
+	}
+	for _, sp := range pairs {
+		ev := []ProofNode{{Goal: fmt.Sprintf("presentepeso(%s, %d)", sp.S, sp.P)}}
+		if sp.Via != "" {
+			ev = []ProofNode{
+				{Goal: fmt.Sprintf("presentepeso(%s, %d)", sp.Via, sp.P)},
+				{Goal: fmt.Sprintf("ancestro(%s, %s)", sp.Via, sp.S), Rule: "ancestro/2"},
+			}
+		}
+		affNode.Children = append(affNode.Children, ProofNode{
+			Goal: fmt.Sprintf("puntaje(%s, %d)", sp.S, sp.P*sp.W),
+			Rule: "puntaje_enf/3",
+			Children: append([]ProofNode{
+				{Goal: fmt.Sprintf("enf_sintoma(%s, %s, %d)", enfID, sp.S, sp.W)},
+			}, ev...),
+		})
+	}
+	for _, d := range denied {
+		if d.Pen == 0 {
+			continue
+		}
+		affNode.Children = append(affNode.Children, ProofNode{
+			Goal: fmt.Sprintf("penalizacion(%s, -%d)", d.S, d.Pen*d.W),
+			Rule: "penalizacion_total/2",
+			Children: []ProofNode{
+				{Goal: fmt.Sprintf("enf_sintoma(%s, %s, %d)", enfID, d.S, d.W)},
+				{Goal: fmt.Sprintf("ausente(%s)", d.S)},
+				{Goal: fmt.Sprintf("penalizacion_ausente(%s, %d)", enfID, d.Pen)},
+			},
+		})
+	}
+	for _, t := range timing {
+		fact := fmt.Sprintf("enf_duracion(%s, _, _)", enfID)
+		if t.Kind == "inicio" {
+			fact = fmt.Sprintf("enf_inicio(%s, _)", enfID)
+		}
+		affNode.Children = append(affNode.Children, ProofNode{
+			Goal: fmt.Sprintf("ajuste_sintoma(%s, %s, %s, %+d)", enfID, t.S, t.Kind, t.Pts),
+			Rule: "ajuste_temporal/2",
+			Children: []ProofNode{
+				{Goal: fmt.Sprintf("%s(%s, _)", t.Kind, t.S)},
+				{Goal: fact},
+			},
+		})
+	}
+	// tope_afinidad/3: sin un síntoma requerido la afinidad queda en tope_requerido/1
+	for _, s := range missing {
+		affNode.Children = append(affNode.Children, ProofNode{
+			Goal: fmt.Sprintf("falta_requerido(%s, %s)", enfID, s),
+			Rule: "tope_afinidad/3",
+			Children: []ProofNode{
+				{Goal: fmt.Sprintf("enf_requerido(%s, %s)", enfID, s)},
+				{Goal: fmt.Sprintf("\\+ presente_ev(%s, _)", s)},
+				{Goal: fmt.Sprintf("tope_requerido(%d)", reqCap)},
+			},
+		})
+	}
+	root.Children = append(root.Children, affNode)
+
+	// urgencia/2: la cláusula ganadora y su evidencia (solo síntomas de la enfermedad)
+	urgNode := ProofNode{
+		Goal: fmt.Sprintf("urgencia(%s, %q)", enfID, ut.Level),
+		Rule: "urgencia/2:" + ut.Rule,
+	}
+	switch ut.Rule {
+	case "bandera_roja_enf":
+		urgNode.Children = []ProofNode{
+			{Goal: fmt.Sprintf("enf_bandera_roja(%s, %s, _)", enfID, ut.Symptom)},
+			{Goal: fmt.Sprintf("presentepeso(%s, %d)", ut.Symptom, ut.Value)},
+		}
+	case "bandera_roja":
+		urgNode.Children = []ProofNode{{Goal: fmt.Sprintf("bandera_roja(%s, _, _, %q)", ut.Symptom, ut.Level)}}
+		for _, f := range ut.Flag {
+			urgNode.Children = append(urgNode.Children, ProofNode{Goal: fmt.Sprintf("presentepeso(%s, %d) >= %d", f.S, f.P, f.Min)})
+		}
+	case "sintoma_severo":
+		urgNode.Children = []ProofNode{
+			{Goal: fmt.Sprintf("enf_sintoma(%s, %s)", enfID, ut.Symptom)},
+			{Goal: fmt.Sprintf("presentepeso(%s, %d)", ut.Symptom, ut.Value)},
+		}
+	case "tipo_enfermedad":
+		urgNode.Children = []ProofNode{
+			{Goal: fmt.Sprintf("enfermedad(%s, _, _, %s)", enfID, ut.Symptom)},
+			{Goal: fmt.Sprintf("tipo_consulta(%s)", ut.Symptom)},
+			{Goal: fmt.Sprintf("coincidencias_enf(%s, %d)", enfID, ut.Value), Rule: "coincidencias_enf/2"},
+		}
+	case "conteo_sintomas":
+		urgNode.Children = []ProofNode{{Goal: fmt.Sprintf("coincidencias_enf(%s, %d)", enfID, ut.Value), Rule: "coincidencias_enf/2"}}
+	}
+	root.Children = append(root.Children, urgNode)
+
+	// medicamento_seguro/2 y bloqueos
+	for _, m := range safeMeds {
+		n := ProofNode{
+			Goal:     fmt.Sprintf("medicamento_seguro(%s, %s)", enfID, m.Drug),
+			Rule:     "medicamento_seguro/2",
+			Children: []ProofNode{{Goal: fmt.Sprintf("trata(%s, %s)", m.Drug, enfID)}},
+		}
+		if m.Line > 0 {
+			n.Children = append(n.Children, ProofNode{
+				Goal: fmt.Sprintf("linea_tratamiento(%s, %s, %d, %d)", m.Drug, enfID, m.Line, m.Rank),
+				Rule: "linea/4",
+			})
+		}
+		root.Children = append(root.Children, n)
+	}
+	for _, b := range blocked {
+		n := ProofNode{
+			Goal: fmt.Sprintf("\\+ medicamento_seguro(%s, %s)", enfID, b.Med),
+			Rule: b.rule(),
+		}
+		for _, f := range b.facts() {
+			n.Children = append(n.Children, ProofNode{Goal: f})
+		}
+		root.Children = append(root.Children, n)
+	}
+	return &root
+}
+
+// explainText resume en una línea lo que sostiene un diagnóstico.
+func explainText(name string, aff int, pairs []scorePair, denied []deniedPair, timing []timingAdj, missing []string, reqCap int, ut urgencyTrace, blocked []blockReason) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: afinidad %d%%", name, aff)
+	if len(pairs) > 0 {
+		parts := make([]string, 0, len(pairs))
+		for _, sp := range pairs {
+			name := sp.S
+			if sp.Via != "" {
+				name = fmt.Sprintf("%s (por %s)", sp.S, sp.Via)
+			}
+			if sp.W > 1 {
+				parts = append(parts, fmt.Sprintf("%s=%dx%d", name, sp.P, sp.W))
+			} else {
+				parts = append(parts, fmt.Sprintf("%s=%d", name, sp.P))
+			}
+		}
+		fmt.Fprintf(&b, " por %s", strings.Join(parts, ", "))
+	}
+	for _, d := range denied {
+		if d.Pen > 0 {
+			fmt.Fprintf(&b, ", -%d por %s negado", d.Pen*d.W, d.S)
+		}
+	}
+	for _, t := range timing {
+		fmt.Fprintf(&b, ", %+d por %s de %s", t.Pts, t.Kind, t.S)
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(&b, ", máx. %d%% sin %s (requerido)", reqCap, strings.Join(missing, ", "))
+	}
+	fmt.Fprintf(&b, "; urgencia por %s", ut.Rule)
+	if ut.Rule != "caso_base" {
+		fmt.Fprintf(&b, " (%s=%d)", ut.Symptom, ut.Value)
+	}
+	for _, bl := range blocked {
+		fmt.Fprintf(&b, "; %s descartado por %s", bl.Med, strings.Join(bl.facts(), " + "))
+	}
+	b.WriteString(".")
+	return b.String()
+}
+
+// bayesTrace: prior y factores de verosimilitud del modo bayes
+type bayesTrace struct {
+	Prior   float64
+	Factors []bayesFactor
+}
+
+type bayesFactor struct {
+	S string
+	F float64
+}
+
+// queryBayesScores: puntaje_bayes/2 de cada enfermedad/4 y su suma (en orden de la KB),
+// el normalizador de la posterior.
+func queryBayesScores(p *session) (map[string]float64, float64) {
+	scores := map[string]float64{}
+	total := 0.0
+	q, err := p.Query(`enfermedad(Enf, _, _, _), puntaje_bayes(Enf, Sc).`)
+	if err != nil {
+		return scores, 0
+	}
+	defer q.Close()
+	for q.Next() {
+		var row struct {
+			Enf string
+			Sc  float64
+		}
+		if err := q.Scan(&row); err == nil {
+			scores[row.Enf] = row.Sc
+			total += row.Sc
+		}
+	}
+	return scores, total
+}
+
+func queryBayesTrace(p *session, enfID string) *bayesTrace {
+	bt := &bayesTrace{}
+	if q, err := p.Query(fmt.Sprintf(`prior_enf(%s, P), X is float(P).`, safeAtom(enfID))); err == nil {
+		var row struct{ X float64 }
+		if q.Next() {
+			_ = q.Scan(&row)
+		}
+		q.Close()
+		bt.Prior = row.X
+	}
+	if q, err := p.Query(fmt.Sprintf(`factor_bayes(%s, S, F0), F is float(F0).`, safeAtom(enfID))); err == nil {
+		for q.Next() {
+			var row struct {
+				S string
+				F float64
+			}
+			if err := q.Scan(&row); err == nil {
+				bt.Factors = append(bt.Factors, bayesFactor{S: row.S, F: row.F})
+			}
+		}
+		q.Close()
+	}
+	return bt
+}
+
+// node arma el subárbol posterior <- prior x factores.
+func (bt *bayesTrace) node(enfID string, posterior float64) ProofNode {
+	n := ProofNode{
+		Goal: fmt.Sprintf("posterior(%s, %g)", enfID, posterior),
+		Rule: "puntaje_bayes/2",
+		Children: []ProofNode{
+			{Goal: fmt.Sprintf("prior_enf(%s, %g)", enfID, bt.Prior), Rule: "prior_enf/2"},
+		},
+	}
+	for _, f := range bt.Factors {
+		n.Children = append(n.Children, ProofNode{
+			Goal: fmt.Sprintf("factor_bayes(%s, %s, %g)", enfID, f.S, f.F),
+			Rule: "factor_bayes/3",
+		})
+	}
+	return n
+}
@@ -0,0 +1,232 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRulesFired(t *testing.T) {
+	tests := []struct {
+		name    string
+		safe    []string
+		blocked []blockReason
+		want    []string
+	}{
+		{"sin medicamentos", nil, nil, []string{"afinidad/3", "urgencia/2"}},
+		{"con seguros", []string{"paracetamol"}, nil, []string{"afinidad/3", "urgencia/2", "medicamento_seguro/2"}},
+		{"bloqueos sin repetir regla", nil, []blockReason{
+			{Med: "amoxicilina", Kind: "alergia", Cond: "alergia_penicilina"},
+			{Med: "cefalexina", Kind: "alergia", Cond: "alergia_penicilina"},
+			{Med: "ibuprofeno", Kind: "enfermedad", Cond: "dengue"},
+		}, []string{"afinidad/3", "urgencia/2", "bloqueado_por_alergia/1", "bloqueado_por_enf/2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rulesFired(tt.safe, tt.blocked); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rulesFired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplainText(t *testing.T) {
+	base := urgencyTrace{Level: "Observación recomendada", Rule: "caso_base", Symptom: "ninguno"}
+	tests := []struct {
+		name    string
+		pairs   []scorePair
+		denied  []deniedPair
+		timing  []timingAdj
+		missing []string
+		ut      urgencyTrace
+		blocked []blockReason
+		want    string
+	}{
+		{"solo afinidad", []scorePair{{S: "tos", P: 2, W: 1}}, nil, nil, nil, base, nil,
+			"Gripe: afinidad 50% por tos=2; urgencia por caso_base."},
+		{"peso, jerarquía y negados", []scorePair{{S: "fiebre", P: 3, W: 2}, {S: "disnea", P: 2, W: 1, Via: "sibilancias"}},
+			[]deniedPair{{S: "tos", Pen: 10, W: 2}, {S: "cefalea", Pen: 0, W: 1}}, nil, nil,
+			urgencyTrace{Level: "Consulta recomendada", Rule: "sintoma_severo", Symptom: "fiebre", Value: 3}, nil,
+			"Gripe: afinidad 50% por fiebre=3x2, disnea (por sibilancias)=2, -20 por tos negado; urgencia por sintoma_severo (fiebre=3)."},
+		{"temporal, requerido y bloqueo", nil, nil, []timingAdj{{S: "tos", Kind: "duracion", Pts: 5}, {S: "tos", Kind: "inicio", Pts: -5}},
+			[]string{"fiebre"}, base, []blockReason{{Med: "aspirina", Kind: "edad", Cond: "16"}},
+			"Gripe: afinidad 50%, +5 por duracion de tos, -5 por inicio de tos, máx. 25% sin fiebre (requerido); urgencia por caso_base; aspirina descartado por edad_minima(aspirina, 16) + edad(E), E < 16."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := explainText("Gripe", 50, tt.pairs, tt.denied, tt.timing, tt.missing, 25, tt.ut, tt.blocked)
+			if got != tt.want {
+				t.Errorf("explainText =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// La traza sale de las reglas que realmente probaron el diagnóstico.
+func TestProofTrace(t *testing.T) {
+	eng := newKBEngine(1, []byte(fixtureManual))
+	if eng.err != nil {
+		t.Fatal(eng.err)
+	}
+	req := DiagnoseReq{Explain: true, Allergies: []string{"alergia_penicilina"}, Symptoms: []SymptomEntry{
+		{ID: "sibilancias", Severity: "moderado", Present: true},
+		{ID: "fiebre", Severity: "severo", Present: true},
+	}}
+	resp, err := prologEngine{}.Diagnose(context.Background(), eng, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		disease string
+		rule    string
+		goals   []string
+	}{
+		{"Neumonía", "bloqueado_por_alergia/1", []string{
+			"afinidad(neumonia, 62)", "puntaje(disnea, 4)", "ancestro(sibilancias, disnea)",
+			`\+ medicamento_seguro(neumonia, amoxicilina)`, "contraindicado(amoxicilina, alergia_penicilina)",
+			"medicamento_seguro(neumonia, claritromicina)",
+		}},
+		{"Asma", "medicamento_seguro/2", []string{"puntaje(sibilancias, 6)", "enf_sintoma(asma, disnea, 2)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.disease, func(t *testing.T) {
+			var dg *Diagnosis
+			for i := range resp.Diagnoses {
+				if resp.Diagnoses[i].Disease == tt.disease {
+					dg = &resp.Diagnoses[i]
+				}
+			}
+			if dg == nil || dg.Proof == nil {
+				t.Fatalf("sin traza para %s: %+v", tt.disease, resp.Diagnoses)
+			}
+			if !contains(dg.RulesFired, tt.rule) {
+				t.Errorf("rules_fired = %v, falta %s", dg.RulesFired, tt.rule)
+			}
+			have := map[string]bool{}
+			var walk func(n ProofNode)
+			walk = func(n ProofNode) {
+				have[n.Goal] = true
+				for _, c := range n.Children {
+					walk(c)
+				}
+			}
+			walk(*dg.Proof)
+			for _, g := range tt.goals {
+				if !have[g] {
+					t.Errorf("falta %q en la traza", g)
+				}
+			}
+		})
+	}
+	if !strings.Contains(resp.Explanations, "Bronquitis") {
+		t.Errorf("explicación sin la enfermedad descartada: %s", resp.Explanations)
+	}
+}
+
+func TestBlockReasonWarning(t *testing.T) {
+	tests := []struct {
+		b     blockReason
+		want  string
+		facts []string
+	}{
+		{blockReason{Med: "amoxicilina", Kind: "alergia", Cond: "alergia_penicilina"}, "amoxicilina descartado: contraindicado por alergia_penicilina",
+			[]string{"alergia(alergia_penicilina)", "contraindicado(amoxicilina, alergia_penicilina)"}},
+		{blockReason{Med: "ibuprofeno", Kind: "cronica", Cond: "ulcera"}, "ibuprofeno descartado: contraindicado en enfermedad crónica ulcera",
+			[]string{"cronica(ulcera)", "contraindicado(ibuprofeno, ulcera)"}},
+		{blockReason{Med: "ibuprofeno", Kind: "enfermedad", Cond: "neumonia"}, "ibuprofeno descartado: contraindicado en neumonia",
+			[]string{"enf_contra_medicamento(neumonia, ibuprofeno)"}},
+		{blockReason{Med: "azitromicina", Kind: "edad", Cond: "6"}, "azitromicina descartado: no indicado en menores de 6 años",
+			[]string{"edad_minima(azitromicina, 6)", "edad(E), E < 6"}},
+		{blockReason{Med: "ibuprofeno", Kind: "embarazo", Cond: "embarazo"}, "ibuprofeno descartado: contraindicado en embarazo",
+			[]string{"embarazo", "contra_embarazo(ibuprofeno)"}},
+		{blockReason{Med: "ibuprofeno", Kind: "interaccion", Cond: "warfarina"}, "ibuprofeno descartado: interacción grave con warfarina",
+			[]string{"toma(warfarina)", "interaccion(ibuprofeno, warfarina, grave)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.b.Kind, func(t *testing.T) {
+			if got := tt.b.warning(); got != tt.want {
+				t.Errorf("warning = %q, want %q", got, tt.want)
+			}
+			if got := tt.b.facts(); !reflect.DeepEqual(got, tt.facts) {
+				t.Errorf("facts = %v, want %v", got, tt.facts)
+			}
+		})
+	}
+}
+
+// Cada medicamento descartado aparece en warnings y blocked_drugs, en el orden de motivo_bloqueo/4.
+func TestBlockedDrugWarnings(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	req := DiagnoseReq{Age: intp(3), Allergies: []string{"alergia_penicilina"}, Chronics: []string{"ulcera"},
+		Symptoms: []SymptomEntry{{ID: "fiebre", Severity: "severo", Present: true}, {ID: "dolor_garganta", Severity: "moderado", Present: true}}}
+	want := []BlockedDrug{
+		{Drug: "amoxicilina", Reason: "alergia", Condition: "alergia_penicilina"},
+		{Drug: "ibuprofeno", Reason: "cronica", Condition: "ulcera"},
+		{Drug: "azitromicina", Reason: "edad", Condition: "6"},
+	}
+	for name, resp := range bothEngines(t, eng, req) {
+		dg := findDiagnosis(resp, "Faringitis")
+		if dg == nil {
+			t.Fatalf("%s: falta Faringitis", name)
+		}
+		if dg.SuggestedDrug != "paracetamol" || len(dg.Alternatives) != 0 {
+			t.Errorf("%s: sugerido %q alternativas %v", name, dg.SuggestedDrug, dg.Alternatives)
+		}
+		if len(dg.BlockedDrugs) != len(want) {
+			t.Fatalf("%s: blocked_drugs = %+v", name, dg.BlockedDrugs)
+		}
+		for i, w := range want {
+			b := dg.BlockedDrugs[i]
+			if b.Drug != w.Drug || b.Reason != w.Reason || b.Condition != w.Condition {
+				t.Errorf("%s: blocked_drugs[%d] = %+v, want %+v", name, i, b, w)
+			}
+			br := blockReason{Med: w.Drug, Kind: w.Reason, Cond: w.Condition}
+			if !contains(dg.Warnings, br.warning()) {
+				t.Errorf("%s: falta la advertencia %q en %v", name, br.warning(), dg.Warnings)
+			}
+		}
+	}
+}
+
+// urgencia/2 por enfermedad; el triage parte de urgencia/1 y solo sube si una enfermedad devuelta lo supera.
+func TestDiseaseUrgency(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	const (
+		alta  = "Atención prioritaria"
+		media = "Consulta recomendada"
+		baja  = "Observación recomendada"
+	)
+	tests := []struct {
+		name     string
+		symptoms []SymptomEntry
+		triage   Triage
+		urgency  map[string]string
+	}{
+		{"bandera roja de la enfermedad", []SymptomEntry{sym("disnea", "moderado"), sym("fiebre", "leve")},
+			Triage{Level: alta, Rule: "bandera_roja_enf", Symptom: "disnea", Disease: "neumonia"},
+			map[string]string{"Neumonía": alta, "Faringitis": media, "Gripe": baja}},
+		{"síntoma severo", []SymptomEntry{sym("rinorrea", "severo")},
+			Triage{Level: media, Rule: "sintoma_severo", Symptom: "rinorrea"},
+			map[string]string{"Resfriado": media, "Gripe": media, "Neumonía": baja}},
+		{"bandera roja global", []SymptomEntry{sym("dolor_pecho", "moderado")},
+			Triage{Level: alta, Rule: "bandera_roja", Symptom: "dolor_pecho_agudo"},
+			map[string]string{"Neumonía": alta, "Gripe": baja}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms}) {
+				if resp.Triage != tt.triage {
+					t.Errorf("%s: triage %+v, want %+v", name, resp.Triage, tt.triage)
+				}
+				for d, want := range tt.urgency {
+					if dg := findDiagnosis(resp, d); dg == nil || dg.Urgency != want {
+						t.Errorf("%s: urgencia de %s = %+v, want %s", name, d, dg, want)
+					}
+				}
+			}
+		})
+	}
+}
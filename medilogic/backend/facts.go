@@ -0,0 +1,481 @@
+//go:build !rpa
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+/* ===========================================================
+   Hechos de la KB leídos del intérprete compilado
+   =========================================================== */
+
+// kbFacts: los hechos de una versión de KB tal como los ve rules.pl (orden de
+// cláusulas y valores por defecto de las reglas), sin depender del formato del
+// archivo. Alimentan al motor nativo y al Snapshot de catálogo, búsqueda y texto libre.
+type kbFacts struct {
+	snap Snapshot
+
+	// constantes de rules.pl
+	timeBonus    int      // bono_temporal/1
+	timePenalty  int      // castigo_temporal/1
+	leak         float64  // fuga/1
+	ageFactor    float64  // factor_edad/1
+	requiredCap  int      // tope_requerido/1
+	levels       []string // nivel_urgencia/2, del más urgente al menos urgente
+	consultTypes []string // tipo_consulta/1
+
+	symptoms  []string                  // sintoma/1
+	ancestors map[string][]string       // ancestro/2 por síntoma
+	diseases  []diseaseFact             // enfermedad/4 (con repetidos, como los recorre enf_candidata/4)
+	links     map[string][]string       // enf_sintoma/2 por enfermedad
+	weights   map[[2]string]int         // peso_vinculo/3 de cada vínculo
+	sens      map[[2]string]float64     // sens_vinculo/3 de cada vínculo
+	maxScore  map[string]int            // max_puntaje_enf/2
+	penalty   map[string]int            // penalizacion_enf/2
+	prior     map[string]float64        // prior_base/2
+	enfFlags  map[string][]enfFlag      // enf_bandera_roja/3
+	ages      map[string][][2]int       // enf_edad/3
+	durations map[string][][2]int       // enf_duracion/3
+	onsets    map[string][]string       // enf_inicio/2
+	excludes  map[string][]string       // enf_excluye/2
+	required  map[string][]string       // enf_requerido/2
+	contraEnf map[string][]string       // enf_contra_medicamento/2
+	treats    map[string][]string       // trata/2: enfermedad -> medicamentos
+	treatedBy map[string][]string       // trata/2: medicamento -> enfermedades
+	contra    map[string][]string       // contraindicado/2
+	minAge    map[string][]int          // edad_minima/2
+	pregnancy map[string]bool           // contra_embarazo/1
+	inter     []interFact               // interaccion/3
+	lines     map[[2]string]TherapyLine // linea/4 declarada (medicamento, enfermedad)
+	dosages   map[string][]dosageFact   // posologia/10
+	flags     []RedFlag                 // bandera_roja/4
+}
+
+type diseaseFact struct {
+	ID, Name, System, Type string
+}
+
+type enfFlag struct {
+	S   string
+	Min int
+}
+
+type interFact struct {
+	A, B, Sev string
+}
+
+// dosageFact: posologia/10 con los rangos como los compara en_banda/3
+type dosageFact struct {
+	ageMin, ageMax, weightMin, weightMax float64
+	dose, maxDaily                       float64
+	unit, route                          string
+	everyHours                           int
+}
+
+// factRows ejecuta goal y escanea cada solución en T (en orden de cláusulas). Un predicado
+// que la KB no define no tiene filas; el primer error (ej. un límite) queda en *err.
+func factRows[T any](s *session, goal string, err *error) []T {
+	if *err != nil {
+		return nil
+	}
+	q, qerr := s.Query(goal)
+	if qerr != nil {
+		*err = qerr
+		return nil
+	}
+	defer q.Close()
+	var out []T
+	for q.Next() {
+		var row T
+		if q.Scan(&row) == nil {
+			out = append(out, row)
+		}
+	}
+	if le := s.limitErr(); le != nil {
+		*err = le
+	} else if qerr := q.Err(); qerr != nil && !strings.Contains(qerr.Error(), "existence_error") {
+		*err = qerr
+	}
+	return out
+}
+
+// plText: un texto "" de la KB llega como [] (lista vacía de códigos).
+func plText(s string) string {
+	if s == "[]" {
+		return ""
+	}
+	return s
+}
+
+// loadKBFacts lee los hechos de la KB (y las constantes de rules.pl) con una sesión sin paciente.
+func loadKBFacts(s *session) (*kbFacts, error) {
+	var err error
+	f := &kbFacts{
+		ancestors: map[string][]string{},
+		links:     map[string][]string{},
+		weights:   map[[2]string]int{},
+		sens:      map[[2]string]float64{},
+		maxScore:  map[string]int{},
+		penalty:   map[string]int{},
+		prior:     map[string]float64{},
+		enfFlags:  map[string][]enfFlag{},
+		ages:      map[string][][2]int{},
+		durations: map[string][][2]int{},
+		onsets:    map[string][]string{},
+		excludes:  map[string][]string{},
+		required:  map[string][]string{},
+		contraEnf: map[string][]string{},
+		treats:    map[string][]string{},
+		treatedBy: map[string][]string{},
+		contra:    map[string][]string{},
+		minAge:    map[string][]int{},
+		pregnancy: map[string]bool{},
+		lines:     map[[2]string]TherapyLine{},
+		dosages:   map[string][]dosageFact{},
+	}
+
+	// 1) Constantes de rules.pl
+	if r := factRows[struct{ B, C int }](s, `bono_temporal(B), castigo_temporal(C).`, &err); len(r) > 0 {
+		f.timeBonus, f.timePenalty = r[0].B, r[0].C
+	}
+	if r := factRows[struct{ F, E float64 }](s, `fuga(F0), F is float(F0), factor_edad(E0), E is float(E0).`, &err); len(r) > 0 {
+		f.leak, f.ageFactor = r[0].F, r[0].E
+	}
+	if r := factRows[struct{ T int }](s, `tope_requerido(T).`, &err); len(r) > 0 {
+		f.requiredCap = r[0].T
+	}
+	for _, r := range factRows[struct{ U string }](s, `nivel_urgencia(U, _).`, &err) {
+		f.levels = append(f.levels, r.U)
+	}
+	for _, r := range factRows[struct{ T string }](s, `tipo_consulta(T).`, &err) {
+		f.consultTypes = append(f.consultTypes, r.T)
+	}
+
+	// 2) Síntomas y enfermedades, con los valores que derivan las reglas
+	for _, r := range factRows[struct{ S string }](s, `sintoma(S).`, &err) {
+		f.symptoms = append(f.symptoms, r.S)
+	}
+	for _, r := range factRows[struct{ S, A string }](s, `ancestro(S, A).`, &err) {
+		f.ancestors[r.S] = append(f.ancestors[r.S], r.A)
+	}
+	for _, r := range factRows[struct{ E, N, Sis, T string }](s, `enfermedad(E, N, Sis, T).`, &err) {
+		f.diseases = append(f.diseases, diseaseFact{ID: r.E, Name: r.N, System: r.Sis, Type: r.T})
+	}
+	for _, r := range factRows[struct{ E, S string }](s, `enf_sintoma(E, S).`, &err) {
+		f.links[r.E] = append(f.links[r.E], r.S)
+	}
+	for _, r := range factRows[struct {
+		E, S string
+		W    int
+	}](s, `enf_sintoma(E, S), peso_vinculo(E, S, W).`, &err) {
+		f.weights[[2]string{r.E, r.S}] = r.W
+	}
+	for _, r := range factRows[struct {
+		E, S string
+		X    float64
+	}](s, `enf_sintoma(E, S), sens_vinculo(E, S, X0), X is float(X0).`, &err) {
+		f.sens[[2]string{r.E, r.S}] = r.X
+	}
+	for _, r := range factRows[struct {
+		E    string
+		M, P int
+		Pr   float64
+	}](s, `enfermedad(E, _, _, _), max_puntaje_enf(E, M), penalizacion_enf(E, P), prior_base(E, Pr0), Pr is float(Pr0).`, &err) {
+		f.maxScore[r.E], f.penalty[r.E], f.prior[r.E] = r.M, r.P, r.Pr
+	}
+	for _, r := range factRows[struct {
+		E, S string
+		Min  int
+	}](s, `enf_bandera_roja(E, S, Min).`, &err) {
+		f.enfFlags[r.E] = append(f.enfFlags[r.E], enfFlag{S: r.S, Min: r.Min})
+	}
+	for _, r := range factRows[struct {
+		E        string
+		Min, Max int
+	}](s, `enf_edad(E, Min, Max).`, &err) {
+		f.ages[r.E] = append(f.ages[r.E], [2]int{r.Min, r.Max})
+	}
+	for _, r := range factRows[struct {
+		E        string
+		Min, Max int
+	}](s, `enf_duracion(E, Min, Max).`, &err) {
+		f.durations[r.E] = append(f.durations[r.E], [2]int{r.Min, r.Max})
+	}
+	for _, r := range factRows[struct{ E, I string }](s, `enf_inicio(E, I).`, &err) {
+		f.onsets[r.E] = append(f.onsets[r.E], r.I)
+	}
+	for _, r := range factRows[struct{ E, S string }](s, `enf_excluye(E, S).`, &err) {
+		f.excludes[r.E] = append(f.excludes[r.E], r.S)
+	}
+	for _, r := range factRows[struct{ E, S string }](s, `enf_requerido(E, S).`, &err) {
+		f.required[r.E] = append(f.required[r.E], r.S)
+	}
+	for _, r := range factRows[struct{ E, M string }](s, `enf_contra_medicamento(E, M).`, &err) {
+		f.contraEnf[r.E] = append(f.contraEnf[r.E], r.M)
+	}
+
+	// 3) Medicamentos y banderas rojas
+	for _, r := range factRows[struct{ M, E string }](s, `trata(M, E).`, &err) {
+		f.treats[r.E] = append(f.treats[r.E], r.M)
+		f.treatedBy[r.M] = append(f.treatedBy[r.M], r.E)
+	}
+	for _, r := range factRows[struct{ M, C string }](s, `contraindicado(M, C).`, &err) {
+		f.contra[r.M] = append(f.contra[r.M], r.C)
+	}
+	for _, r := range factRows[struct {
+		M string
+		A int
+	}](s, `edad_minima(M, A).`, &err) {
+		f.minAge[r.M] = append(f.minAge[r.M], r.A)
+	}
+	for _, r := range factRows[struct{ M string }](s, `contra_embarazo(M).`, &err) {
+		f.pregnancy[r.M] = true
+	}
+	for _, r := range factRows[struct{ A, B, Sev string }](s, `interaccion(A, B, Sev).`, &err) {
+		f.inter = append(f.inter, interFact{A: r.A, B: r.B, Sev: r.Sev})
+	}
+	for _, r := range factRows[struct {
+		M, E string
+		L, R int
+	}](s, `linea_tratamiento(M, E, L, R).`, &err) {
+		if _, ok := f.lines[[2]string{r.M, r.E}]; !ok {
+			f.lines[[2]string{r.M, r.E}] = TherapyLine{Line: r.L, Rank: r.R}
+		}
+	}
+	for _, r := range factRows[struct {
+		M, U, V                   string
+		EMin, EMax, PMin, PMax, D float64
+		H                         int
+		Mx                        float64
+	}](s, `posologia(M, EMin0, EMax0, PMin0, PMax0, D0, U, V, H, Mx0),
+		EMin is float(EMin0), EMax is float(EMax0), PMin is float(PMin0), PMax is float(PMax0),
+		D is float(D0), Mx is float(Mx0).`, &err) {
+		f.dosages[r.M] = append(f.dosages[r.M], dosageFact{
+			ageMin: r.EMin, ageMax: r.EMax, weightMin: r.PMin, weightMax: r.PMax,
+			dose: r.D, maxDaily: r.Mx, unit: r.U, route: r.V, everyHours: r.H,
+		})
+	}
+	for _, r := range factRows[struct {
+		Id, U string
+		Ss    []string
+		Min   int
+	}](s, `bandera_roja(Id, Ss, Min, U).`, &err) {
+		f.flags = append(f.flags, RedFlag{ID: r.Id, Symptoms: r.Ss, MinSeverity: r.Min, Level: r.U})
+	}
+
+	// 4) Snapshot (hechos tal como los declara la KB, sin valores por defecto)
+	f.snap = f.buildSnapshot(s, &err)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// buildSnapshot arma el Snapshot con los hechos que declara la KB; los atributos de un
+// solo valor toman el primer hecho, igual que las reglas. Las listas salen ordenadas por id.
+func (f *kbFacts) buildSnapshot(s *session, err *error) Snapshot {
+	snap := defaultEmptySnapshot()
+
+	syms := map[string]*Symptom{}
+	var symOrder []string
+	for _, id := range uniq(f.symptoms) {
+		syms[id] = &Symptom{ID: id}
+		symOrder = append(symOrder, id)
+	}
+	for _, r := range factRows[struct{ S, T string }](s, `sinonimo(S, T).`, err) {
+		if x := syms[r.S]; x != nil {
+			x.Synonyms = append(x.Synonyms, plText(r.T))
+		}
+	}
+	for _, r := range factRows[struct{ S, P string }](s, `sintoma_padre(S, P).`, err) {
+		if x := syms[r.S]; x != nil && !contains(x.Parents, r.P) {
+			x.Parents = append(x.Parents, r.P)
+		}
+	}
+	for _, r := range factRows[struct{ S, L, E, D string }](s, `texto_sintoma(S, L, E, D).`, err) {
+		x := syms[r.S]
+		if x == nil {
+			continue
+		}
+		if r.L == defaultLang {
+			if x.Label == "" {
+				x.Label, x.Description = plText(r.E), plText(r.D)
+			}
+			continue
+		}
+		if x.I18n == nil {
+			x.I18n = map[string]SymptomText{}
+		}
+		if _, ok := x.I18n[r.L]; !ok {
+			x.I18n[r.L] = SymptomText{Label: plText(r.E), Description: plText(r.D)}
+		}
+	}
+	for _, r := range factRows[struct{ S, X string }](s, `sistema_sintoma(S, X).`, err) {
+		if x := syms[r.S]; x != nil && x.System == "" {
+			x.System = r.X
+		}
+	}
+	for _, r := range factRows[struct {
+		S  string
+		Xs []string
+	}](s, `escala_sintoma(S, Xs).`, err) {
+		if x := syms[r.S]; x != nil && x.Scale == nil {
+			x.Scale = r.Xs
+		}
+	}
+	for _, id := range symOrder {
+		snap.Symptoms = append(snap.Symptoms, *syms[id])
+	}
+
+	dis := map[string]*Disease{}
+	var disOrder []string
+	for _, d := range f.diseases {
+		if dis[d.ID] != nil {
+			continue
+		}
+		x := &Disease{ID: d.ID, Name: plText(d.Name), System: d.System, Type: d.Type}
+		if v := uniq(f.links[d.ID]); len(v) > 0 {
+			x.Symptoms = v
+		}
+		if v := uniq(f.contraEnf[d.ID]); len(v) > 0 {
+			x.ContraMeds = v
+		}
+		if v := uniq(f.excludes[d.ID]); len(v) > 0 {
+			x.Excludes = v
+		}
+		if v := uniq(f.required[d.ID]); len(v) > 0 {
+			x.Required = v
+		}
+		for _, sym := range x.Symptoms {
+			if w := f.weights[[2]string{d.ID, sym}]; w > 1 {
+				if x.Weights == nil {
+					x.Weights = map[string]int{}
+				}
+				x.Weights[sym] = w
+			}
+		}
+		for _, ef := range f.enfFlags[d.ID] {
+			if x.RedFlags == nil {
+				x.RedFlags = map[string]int{}
+			}
+			if _, ok := x.RedFlags[ef.S]; !ok {
+				x.RedFlags[ef.S] = ef.Min
+			}
+		}
+		if v := f.ages[d.ID]; len(v) > 0 {
+			x.AgeMin, x.AgeMax = v[0][0], v[0][1]
+		}
+		if v := f.durations[d.ID]; len(v) > 0 {
+			x.DurationMin, x.DurationMax = v[0][0], v[0][1]
+		}
+		if v := f.onsets[d.ID]; len(v) > 0 {
+			x.Onset = v[0]
+		}
+		dis[d.ID] = x
+		disOrder = append(disOrder, d.ID)
+	}
+	for _, r := range factRows[struct{ E, D string }](s, `descripcion_enf(E, D).`, err) {
+		if x := dis[r.E]; x != nil && x.Description == "" {
+			x.Description = plText(r.D)
+		}
+	}
+	for _, r := range factRows[struct {
+		E string
+		P int
+	}](s, `penalizacion_ausente(E, P).`, err) {
+		if x := dis[r.E]; x != nil && x.AbsentPenalty == 0 {
+			x.AbsentPenalty = r.P
+		}
+	}
+	for _, r := range factRows[struct {
+		E string
+		P float64
+	}](s, `prevalencia(E, P0), P is float(P0).`, err) {
+		if x := dis[r.E]; x != nil && x.Prevalence == 0 {
+			x.Prevalence = r.P
+		}
+	}
+	for _, r := range factRows[struct {
+		E, S string
+		X    float64
+	}](s, `sensibilidad(E, S, X0), X is float(X0).`, err) {
+		x := dis[r.E]
+		if x == nil {
+			continue
+		}
+		if x.Sensitivity == nil {
+			x.Sensitivity = map[string]float64{}
+		}
+		if _, ok := x.Sensitivity[r.S]; !ok {
+			x.Sensitivity[r.S] = r.X
+		}
+	}
+	for _, id := range disOrder {
+		snap.Diseases = append(snap.Diseases, *dis[id])
+	}
+
+	meds := map[string]*Medication{}
+	var medOrder []string
+	for _, r := range factRows[struct{ M string }](s, `medicamento(M).`, err) {
+		if meds[r.M] == nil {
+			meds[r.M] = &Medication{ID: r.M}
+			medOrder = append(medOrder, r.M)
+		}
+	}
+	for k, tl := range f.lines {
+		if x := meds[k[0]]; x != nil {
+			if x.TreatLines == nil {
+				x.TreatLines = map[string]TherapyLine{}
+			}
+			x.TreatLines[k[1]] = tl
+		}
+	}
+	for _, id := range medOrder {
+		x := meds[id]
+		if v := uniq(f.treatedBy[id]); len(v) > 0 {
+			x.Treats = v
+		}
+		if v := uniq(f.contra[id]); len(v) > 0 {
+			x.Contra = v
+		}
+		if v := f.minAge[id]; len(v) > 0 {
+			x.MinAge = v[0]
+		}
+		x.PregnancyContra = f.pregnancy[id]
+		for _, d := range f.dosages[id] {
+			x.Dosages = append(x.Dosages, Dosage{
+				AgeMin: int(d.ageMin), AgeMax: int(d.ageMax), WeightMin: d.weightMin, WeightMax: d.weightMax,
+				Dose: d.dose, Unit: d.unit, Route: d.route, EveryHours: d.everyHours, MaxDaily: d.maxDaily,
+			})
+		}
+	}
+	for _, it := range f.inter {
+		if x := meds[it.A]; x != nil {
+			if x.Interactions == nil {
+				x.Interactions = map[string]string{}
+			}
+			if _, ok := x.Interactions[it.B]; !ok {
+				x.Interactions[it.B] = it.Sev
+			}
+		}
+	}
+	for _, id := range medOrder {
+		snap.Medications = append(snap.Medications, *meds[id])
+	}
+
+	for _, r := range factRows[struct {
+		Id, U string
+		Ss    []string
+		Min   int
+	}](s, `bandera_roja(Id, Ss, Min, U).`, err) {
+		snap.RedFlags = append(snap.RedFlags, RedFlag{ID: r.Id, Symptoms: r.Ss, MinSeverity: r.Min, Level: r.U})
+	}
+
+	// Orden estable (el mismo que parseSnapshotPL)
+	sort.Slice(snap.Symptoms, func(i, j int) bool { return snap.Symptoms[i].ID < snap.Symptoms[j].ID })
+	sort.Slice(snap.Diseases, func(i, j int) bool { return snap.Diseases[i].ID < snap.Diseases[j].ID })
+	sort.Slice(snap.Medications, func(i, j int) bool { return snap.Medications[i].ID < snap.Medications[j].ID })
+	sort.SliceStable(snap.RedFlags, func(i, j int) bool { return snap.RedFlags[i].ID < snap.RedFlags[j].ID })
+	return snap
+}
@@ -0,0 +1,136 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	iprolog "github.com/ichiban/prolog"
+)
+
+/* ===========================================================
+   Límites de ejecución Prolog (tiempo y pasos de inferencia)
+   =========================================================== */
+
+// Por request; configurables con PROLOG_TIMEOUT (ej. 5s) y PROLOG_MAX_STEPS.
+var (
+	prologTimeout  = envDuration("PROLOG_TIMEOUT", 5*time.Second)
+	prologMaxSteps = envInt64("PROLOG_MAX_STEPS", 5_000_000)
+)
+
+// LimitError: la consulta se cortó por tiempo, por pasos o porque el cliente se fue.
+type LimitError struct {
+	Kind string // timeout | pasos | cancelado
+}
+
+func (e *LimitError) Error() string {
+	switch e.Kind {
+	case "timeout":
+		return fmt.Sprintf("consulta Prolog excedió el tiempo máximo (%s)", prologTimeout)
+	case "pasos":
+		return fmt.Sprintf("consulta Prolog excedió el máximo de %d pasos de inferencia", prologMaxSteps)
+	}
+	return "consulta Prolog cancelada"
+}
+
+var errStepBudget = errors.New("presupuesto de pasos agotado")
+
+// stepCtx cuenta cada llamada a Done(): el motor la consulta en cada paso del trampolín
+// (engine.Promise.Force), así que agota el presupuesto tras maxSteps pasos.
+type stepCtx struct {
+	context.Context
+	left atomic.Int64
+	over chan struct{}
+	once sync.Once
+}
+
+func (c *stepCtx) Done() <-chan struct{} {
+	if c.left.Add(-1) < 0 {
+		c.once.Do(func() { close(c.over) })
+		return c.over
+	}
+	return c.Context.Done()
+}
+
+func (c *stepCtx) Err() error {
+	if c.left.Load() < 0 {
+		return errStepBudget
+	}
+	return c.Context.Err()
+}
+
+// session: intérprete atado al contexto del request, con timeout y presupuesto de pasos.
+// Query y Exec sombrean a los del intérprete, así toda consulta queda limitada.
+type session struct {
+	*iprolog.Interpreter
+	ctx    *stepCtx
+	cancel context.CancelFunc
+}
+
+func newSession(parent context.Context, p *iprolog.Interpreter) *session {
+	tctx, cancel := context.WithTimeout(parent, prologTimeout)
+	c := &stepCtx{Context: tctx, over: make(chan struct{})}
+	c.left.Store(prologMaxSteps)
+	return &session{Interpreter: p, ctx: c, cancel: cancel}
+}
+
+func (s *session) Query(query string, args ...interface{}) (*iprolog.Solutions, error) {
+	return s.QueryContext(s.ctx, query, args...)
+}
+
+func (s *session) Exec(query string, args ...interface{}) error {
+	return s.ExecContext(s.ctx, query, args...)
+}
+
+// limitErr devuelve *LimitError si la sesión se cortó (los resultados parciales no valen).
+func (s *session) limitErr() error {
+	switch err := s.ctx.Err(); {
+	case err == nil:
+		return nil
+	case errors.Is(err, errStepBudget):
+		return &LimitError{Kind: "pasos"}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &LimitError{Kind: "timeout"}
+	default:
+		return &LimitError{Kind: "cancelado"}
+	}
+}
+
+// writePrologError responde 503 con JSON si se alcanzó un límite; si no, 500 en texto.
+func writePrologError(w http.ResponseWriter, err error) {
+	var le *LimitError
+	if !errors.As(err, &le) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":      "limite_prolog",
+		"kind":       le.Kind,
+		"message":    le.Error(),
+		"timeout_ms": prologTimeout.Milliseconds(),
+		"max_steps":  prologMaxSteps,
+	})
+}
+
+func envDuration(k string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(getenvDefault(k, "")); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+func envInt64(k string, def int64) int64 {
+	if n, err := strconv.ParseInt(getenvDefault(k, ""), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
@@ -0,0 +1,136 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	iprolog "github.com/ichiban/prolog"
+)
+
+// withLimits cambia los límites globales durante un test.
+func withLimits(t *testing.T, timeout time.Duration, steps int64) {
+	t.Helper()
+	savedT, savedS := prologTimeout, prologMaxSteps
+	prologTimeout, prologMaxSteps = timeout, steps
+	t.Cleanup(func() { prologTimeout, prologMaxSteps = savedT, savedS })
+}
+
+func TestSessionLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		parent  context.Context
+		timeout time.Duration
+		steps   int64
+		goal    string
+		want    string // LimitError.Kind; "" = sin corte
+	}{
+		{"termina", context.Background(), time.Second, 10_000, "cuenta(100).", ""},
+		{"pasos", context.Background(), time.Minute, 10_000, "bucle.", "pasos"},
+		{"tiempo", context.Background(), 50 * time.Millisecond, 1 << 62, "bucle.", "timeout"},
+		{"cliente desconectado", canceled, time.Minute, 1 << 62, "bucle.", "cancelado"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withLimits(t, tt.timeout, tt.steps)
+			p := iprolog.New(nil, nil)
+			if err := p.Exec(`bucle :- bucle.
+cuenta(0) :- !.
+cuenta(N) :- N1 is N - 1, cuenta(N1).`); err != nil {
+				t.Fatal(err)
+			}
+			s := newSession(tt.parent, p)
+			defer s.cancel()
+			q, err := s.Query(tt.goal)
+			if err == nil {
+				q.Next()
+				q.Close()
+			}
+			var le *LimitError
+			got := ""
+			if errors.As(s.limitErr(), &le) {
+				got = le.Kind
+			}
+			if got != tt.want {
+				t.Errorf("límite %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Un corte responde 503 y el intérprete cortado no vuelve al pool.
+func TestDiagnoseLimit(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	<-eng.pool // el pool arranca vacío: cada acquire compila uno nuevo
+	withLimits(t, time.Minute, 2_000)
+	req := DiagnoseReq{Symptoms: []SymptomEntry{{ID: "fiebre", Severity: "severo", Present: true}}}
+	_, err := prologEngine{}.Diagnose(context.Background(), eng, req)
+	var le *LimitError
+	if !errors.As(err, &le) || le.Kind != "pasos" {
+		t.Fatalf("error = %v, want límite de pasos", err)
+	}
+	if n := len(eng.pool); n != 0 {
+		t.Errorf("pool con %d intérpretes tras un corte", n)
+	}
+	w := httptest.NewRecorder()
+	writePrologError(w, err)
+	var body map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusServiceUnavailable || body["error"] != "limite_prolog" || body["kind"] != "pasos" {
+		t.Errorf("respuesta %d %s", w.Code, w.Body)
+	}
+}
+
+// /api/next-question pasa por los mismos límites y también responde 503.
+func TestNextQuestionLimit(t *testing.T) {
+	withLimits(t, time.Minute, 2_000)
+	w := httptest.NewRecorder()
+	handleNextQuestion(w, httptest.NewRequest("POST", "/api/next-question",
+		strings.NewReader(`{"symptoms":[{"id":"fiebre","severity":"severo","present":true}]}`)))
+	var body map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusServiceUnavailable || body["error"] != "limite_prolog" {
+		t.Errorf("respuesta %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWritePrologErrorInternal(t *testing.T) {
+	w := httptest.NewRecorder()
+	writePrologError(w, errors.New("prolog engine error"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", w.Code)
+	}
+}
+
+func TestEnvLimits(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantDur   time.Duration
+		wantSteps int64
+	}{
+		{"", 5 * time.Second, 100},
+		{"2s", 2 * time.Second, 100},
+		{"-1s", 5 * time.Second, 100},
+		{"250", 5 * time.Second, 250},
+		{"0", 5 * time.Second, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("MEDILOGIC_TEST_LIMIT", tt.value)
+			if d := envDuration("MEDILOGIC_TEST_LIMIT", 5*time.Second); d != tt.wantDur {
+				t.Errorf("envDuration = %v, want %v", d, tt.wantDur)
+			}
+			if n := envInt64("MEDILOGIC_TEST_LIMIT", 100); n != tt.wantSteps {
+				t.Errorf("envInt64 = %d, want %d", n, tt.wantSteps)
+			}
+		})
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -45,28 +47,119 @@ type loginReq struct {
 
 // ====== PACIENTE ======
 type DiagnoseReq struct {
-	Symptoms  []SymptomEntry `json:"symptoms"`
-	Allergies []string       `json:"allergies"`
-	Chronics  []string       `json:"chronics"`
+	Symptoms  []SymptomEntry  `json:"symptoms"`
+	Allergies []string        `json:"allergies"`
+	Chronics  []string        `json:"chronics"`
+	Explain   bool            `json:"explain,omitempty"` // también ?explain=1
+	Mode      string          `json:"mode,omitempty"`    // afinidad (defecto) | bayes; también ?mode=
+	Options   DiagnoseOptions `json:"options"`
+	// Datos demográficos (opcionales): edad/1, sexo/1, embarazo/0, peso_kg/1
+	Age      *int    `json:"age,omitempty"`       // años; nil = no informado (0 = lactante)
+	Sex      string  `json:"sex,omitempty"`       // m | f
+	Pregnant bool    `json:"pregnant,omitempty"`  // solo con sexo f (o no informado)
+	WeightKg float64 `json:"weight_kg,omitempty"` // 0 = no informado
+	// Medicamentos que el paciente ya toma: toma/1 (se cruzan con interaccion/3)
+	CurrentMeds []string `json:"current_meds,omitempty"`
+}
+
+// DiagnoseOptions: filtros aplicados dentro del motor; lo excluido no se evalúa.
+// También por query: ?min_affinity=&limit=&system=a,b&type=viral&skip_zero=1
+type DiagnoseOptions struct {
+	MinAffinity int      `json:"min_affinity,omitempty"` // 0..100
+	Limit       int      `json:"limit,omitempty"`        // top-N tras ordenar; 0 = todas
+	Systems     []string `json:"systems,omitempty"`      // enfermedad(_, _, Sistema, _)
+	Types       []string `json:"types,omitempty"`        // enfermedad(_, _, _, Tipo)
+	SkipZero    bool     `json:"skip_zero,omitempty"`    // omite enfermedades sin síntomas presentes
 }
 type SymptomEntry struct {
 	ID       string `json:"id"`
 	Severity string `json:"severity"` // leve|moderado|severo
-	Present  bool   `json:"present"`
+	Present  bool   `json:"present"`  // false = negado explícitamente -> ausente/1
+	// Evolución (opcional, solo síntomas presentes): duracion(S, Dias), inicio(S, I)
+	Onset        string `json:"onset,omitempty"`         // subito | gradual
+	DurationDays int    `json:"duration_days,omitempty"` // 0 = no informado
 }
 type DiagnoseResp struct {
-	Diagnoses    []Diagnosis `json:"diagnoses"`
-	Explanations string      `json:"explanations"`
+	Triage       Triage            `json:"triage"` // urgencia global del paciente
+	Diagnoses    []Diagnosis       `json:"diagnoses"`
+	Explanations string            `json:"explanations"`
+	Mode         string            `json:"mode"`               // motor de puntuación usado para ordenar
+	Excluded     []ExcludedDisease `json:"excluded,omitempty"` // descartadas por enf_excluye/2
+}
+
+// ExcludedDisease: enfermedad candidata descartada porque el paciente presenta un hallazgo excluyente
+type ExcludedDisease struct {
+	Disease  string   `json:"disease"`
+	Affinity int      `json:"affinity"` // la que habría tenido
+	Symptoms []string `json:"symptoms"` // síntomas excluyentes presentes (o con un descendiente presente)
+	Reason   string   `json:"reason"`
+}
+
+// Triage: urgencia/1 sobre todos los síntomas presentes; sube al nivel de la
+// urgencia/2 más alta entre las enfermedades devueltas
+type Triage struct {
+	Level   string `json:"level"`
+	Rule    string `json:"rule"`              // cláusula que decidió el nivel
+	Symptom string `json:"symptom,omitempty"` // evidencia (síntoma, id de bandera roja, tipo o "sintomas")
+	Disease string `json:"disease,omitempty"` // enfermedad que elevó el nivel, si aplica
 }
 type Diagnosis struct {
-	Disease         string   `json:"disease"`
-	Affinity        int      `json:"affinity"`
-	SuggestedDrug   string   `json:"suggested_drug,omitempty"`
-	Alternatives    []string `json:"alternatives,omitempty"`
-	Urgency         string   `json:"urgency"`
-	Warnings        []string `json:"warnings,omitempty"`
-	RulesFired      []string `json:"rules_fired"`
-	MatchedSymptoms []string `json:"matched_symptoms,omitempty"`
+	Disease         string            `json:"disease"`
+	Affinity        int               `json:"affinity"`
+	Posterior       float64           `json:"posterior,omitempty"` // solo modo bayes (normalizada entre todas las enfermedades)
+	SuggestedDrug   string            `json:"suggested_drug,omitempty"`
+	Dosage          *DosageInfo       `json:"dosage,omitempty"` // posología del sugerido según edad/peso
+	Alternatives    []string          `json:"alternatives,omitempty"`
+	TherapyOptions  []TherapyOption   `json:"therapy_options,omitempty"` // sugerido + alternativas, en orden, con su línea
+	Urgency         string            `json:"urgency"`                   // urgencia/2 de esta enfermedad
+	Warnings        []string          `json:"warnings,omitempty"`
+	BlockedDrugs    []BlockedDrug     `json:"blocked_drugs,omitempty"` // medicamentos que tratan la enfermedad pero se descartaron
+	Interactions    []DrugInteraction `json:"interactions,omitempty"`  // interacciones leves/moderadas de los sugeridos
+	RulesFired      []string          `json:"rules_fired"`
+	MatchedSymptoms []string          `json:"matched_symptoms,omitempty"`
+	DeniedSymptoms  []string          `json:"denied_symptoms,omitempty"`  // síntomas de la enfermedad negados por el paciente
+	MissingRequired []string          `json:"missing_required,omitempty"` // síntomas requeridos sin evidencia (afinidad con tope)
+	Proof           *ProofNode        `json:"proof,omitempty"`            // solo en modo explicación
+}
+
+// TherapyOption: medicamento seguro con su línea de tratamiento (0 = sin declarar)
+type TherapyOption struct {
+	Drug string `json:"drug"`
+	Line int    `json:"line"`
+	Rank int    `json:"rank,omitempty"`
+}
+
+// BlockedDrug: motivo estructurado por el que medicamento_seguro/2 rechazó un medicamento
+type BlockedDrug struct {
+	Drug      string   `json:"drug"`
+	Reason    string   `json:"reason"`    // alergia | cronica | enfermedad | edad | embarazo | interaccion
+	Condition string   `json:"condition"` // alergia/crónica del paciente o la enfermedad
+	Facts     []string `json:"facts"`     // hechos que lo sostienen, ej: enf_contra_medicamento(gripe, ibuprofeno)
+}
+
+// DosageInfo: dosis concreta (dosis/6) del medicamento sugerido
+type DosageInfo struct {
+	Drug       string  `json:"drug"`
+	DoseMg     float64 `json:"dose_mg"` // por toma
+	Route      string  `json:"route"`   // oral, inhalada, ...
+	EveryHours int     `json:"every_hours"`
+	MaxDailyMg float64 `json:"max_daily_mg"`
+	Capped     bool    `json:"capped,omitempty"` // se recortó para no superar la dosis máxima diaria
+	Text       string  `json:"text"`             // ej: "500 mg vía oral cada 8 h (máx. 3000 mg/día)"
+}
+
+// DrugInteraction: medicamento sugerido que interactúa con uno que el paciente ya toma
+type DrugInteraction struct {
+	Drug     string `json:"drug"`
+	With     string `json:"with"`     // medicamento actual (toma/1)
+	Severity string `json:"severity"` // leve | moderada (grave = bloqueado)
+}
+
+// ProofNode: nodo del árbol de prueba (meta instanciada + hechos/reglas que la sostienen)
+type ProofNode struct {
+	Goal     string      `json:"goal"`               // ej: afinidad(gripe,67)
+	Rule     string      `json:"rule,omitempty"`     // regla o cláusula usada; vacío = hecho
+	Children []ProofNode `json:"children,omitempty"` // subobjetivos
 }
 
 /* ===========================================================
@@ -77,10 +170,23 @@ type Snapshot struct {
 	Symptoms    []Symptom    `json:"symptoms"`
 	Diseases    []Disease    `json:"diseases"`
 	Medications []Medication `json:"medications"`
+	RedFlags    []RedFlag    `json:"red_flags"` // bandera_roja/4; vacío = sin banderas rojas globales
 }
 type Symptom struct {
-	ID    string `json:"id"`              // ej: fiebre
-	Label string `json:"label,omitempty"` // opcional (solo UI)
+	ID          string                 `json:"id"`                    // ej: fiebre
+	Label       string                 `json:"label,omitempty"`       // texto_sintoma(S, es, Label, _) (solo UI)
+	Description string                 `json:"description,omitempty"` // texto_sintoma(S, es, _, Descripción)
+	System      string                 `json:"system,omitempty"`      // sistema_sintoma(S, Sis): agrupa el catálogo público
+	Scale       []string               `json:"scale,omitempty"`       // escala_sintoma(S, [...]); vacío = leve, moderado, severo
+	I18n        map[string]SymptomText `json:"i18n,omitempty"`        // texto_sintoma/4 en otros idiomas (ej. "en")
+	Synonyms    []string               `json:"synonyms,omitempty"`    // sinonimo(S, "texto"): cómo lo dice el paciente
+	Parents     []string               `json:"parents,omitempty"`     // sintoma_padre(S, Padre): S es un caso de Padre
+}
+
+// SymptomText: etiqueta y descripción de un síntoma en un idioma
+type SymptomText struct {
+	Label       string `json:"label"`
+	Description string `json:"description,omitempty"`
 }
 type Disease struct {
 	ID          string   `json:"id"`          // ej: gripe
@@ -90,12 +196,68 @@ type Disease struct {
 	Description string   `json:"description"` // opcional, informe/UI
 	Symptoms    []string `json:"symptoms"`    // ids de sintoma
 	ContraMeds  []string `json:"contra_meds"` // enf_contra_medicamento(Enf, Med)
+	// enf_excluye(Enf, S): hallazgos que, presentes, descartan la enfermedad
+	Excludes []string `json:"excludes,omitempty"`
+	// enf_requerido(Enf, S): síntomas cardinales; sin ellos la afinidad no supera tope_requerido/1
+	Required []string `json:"required,omitempty"`
+	// enf_sintoma(Enf, S, Peso): peso 1..5 por síntoma (cardinal > inespecífico); omitido = 1
+	Weights map[string]int `json:"weights,omitempty"`
+	// Modo bayes: prevalencia(Enf, P) y sensibilidad(Enf, S, X) = P(S|Enf)
+	Prevalence  float64            `json:"prevalence,omitempty"`
+	Sensitivity map[string]float64 `json:"sensitivity,omitempty"`
+	// penalizacion_ausente(Enf, Puntos): % de afinidad que resta cada síntoma negado
+	AbsentPenalty int `json:"absent_penalty,omitempty"`
+	// enf_bandera_roja(Enf, S, SevMin): síntoma propio que eleva la urgencia de Enf (sev 1..3)
+	RedFlags map[string]int `json:"red_flags,omitempty"`
+	// enf_edad(Enf, Min, Max): rango de edad típico en años; 0/0 = sin rango
+	AgeMin int `json:"age_min,omitempty"`
+	AgeMax int `json:"age_max,omitempty"`
+	// enf_duracion(Enf, Min, Max) en días y enf_inicio(Enf, subito|gradual): ajustan la afinidad
+	DurationMin int    `json:"duration_min,omitempty"`
+	DurationMax int    `json:"duration_max,omitempty"`
+	Onset       string `json:"onset,omitempty"`
+}
+// RedFlag: bandera_roja(Id, [Síntomas], SevMin, "Nivel"); se activa si todos
+// los síntomas están presentes con severidad >= SevMin
+type RedFlag struct {
+	ID          string   `json:"id"`           // ej: disnea_moderada
+	Symptoms    []string `json:"symptoms"`     // uno o varios (combinación)
+	MinSeverity int      `json:"min_severity"` // 1..3
+	Level       string   `json:"level"`        // nivel_urgencia/2 que dispara
 }
 type Medication struct {
 	ID     string   `json:"id"`              // ej: paracetamol
 	Label  string   `json:"label,omitempty"` // opcional (solo UI)
 	Treats []string `json:"treats"`          // trata(Med, Enf)
-	Contra []string `json:"contra"`          // contraindicado(Med, Cond)
+	// linea_tratamiento(Med, Enf, Línea, Rank): enfermedad -> línea de tratamiento (opcional)
+	TreatLines map[string]TherapyLine `json:"treat_lines,omitempty"`
+	Contra     []string               `json:"contra"` // contraindicado(Med, Cond)
+	// edad_minima(Med, Años) y contra_embarazo(Med), opcionales
+	MinAge          int  `json:"min_age,omitempty"`
+	PregnancyContra bool `json:"pregnancy_contra,omitempty"`
+	// interaccion(Med, Otro, Sev): otro medicamento -> leve | moderada | grave
+	Interactions map[string]string `json:"interactions,omitempty"`
+	// posologia/10: bandas por edad/peso; se usa la primera que encaje
+	Dosages []Dosage `json:"dosages,omitempty"`
+}
+
+// TherapyLine: 1 = primera línea, 2 = segunda...; dentro de una línea, Rank menor = preferido
+type TherapyLine struct {
+	Line int `json:"line"`
+	Rank int `json:"rank"` // 1..99; 0 u omitido = 1
+}
+
+// Dosage: banda de posología. Rangos con Max 0 = sin tope; 0/0 = sin restricción
+type Dosage struct {
+	AgeMin     int     `json:"age_min"`
+	AgeMax     int     `json:"age_max"`
+	WeightMin  float64 `json:"weight_min"`
+	WeightMax  float64 `json:"weight_max"`
+	Dose       float64 `json:"dose"`        // por toma, en Unit
+	Unit       string  `json:"unit"`        // mg | mg_kg
+	Route      string  `json:"route"`       // oral, inhalada, iv, ...
+	EveryHours int     `json:"every_hours"` // intervalo entre tomas
+	MaxDaily   float64 `json:"max_daily"`   // mg/día
 }
 
 // Snapshot vacío/ejemplo
@@ -104,13 +266,27 @@ func defaultEmptySnapshot() Snapshot {
 		Symptoms:    []Symptom{},
 		Diseases:    []Disease{},
 		Medications: []Medication{},
+		RedFlags:    []RedFlag{},
 	}
 }
 func defaultSnapshot() Snapshot {
 	return Snapshot{
 		Symptoms: []Symptom{
-			{ID: "fiebre"}, {ID: "tos"}, {ID: "dolor_garganta"},
-			{ID: "disnea"}, {ID: "dolor_pecho"}, {ID: "cefalea"}, {ID: "nausea"},
+			{ID: "fiebre", Label: "Fiebre", Description: "Temperatura de 38 °C o más.", I18n: map[string]SymptomText{"en": {Label: "Fever", Description: "Temperature of 38 °C or higher."}},
+				Synonyms: []string{"fiebre alta", "calentura", "temperatura"}},
+			{ID: "tos", Label: "Tos", System: "respiratorio", I18n: map[string]SymptomText{"en": {Label: "Cough"}}},
+			{ID: "dolor_garganta", Label: "Dolor de garganta", System: "respiratorio", I18n: map[string]SymptomText{"en": {Label: "Sore throat"}},
+				Synonyms: []string{"me duele la garganta", "garganta irritada"}},
+			{ID: "disnea", Label: "Falta de aire", Description: "Dificultad para respirar.", System: "respiratorio", I18n: map[string]SymptomText{"en": {Label: "Shortness of breath"}},
+				Synonyms: []string{"falta de aire", "me cuesta respirar", "ahogo"}},
+			{ID: "dolor_pecho", Label: "Dolor de pecho", System: "cardiovascular", I18n: map[string]SymptomText{"en": {Label: "Chest pain"}},
+				Synonyms: []string{"me duele el pecho", "opresión en el pecho"}},
+			{ID: "dolor_pecho_esfuerzo", Label: "Dolor de pecho al esfuerzo", System: "cardiovascular", I18n: map[string]SymptomText{"en": {Label: "Chest pain on exertion"}},
+				Synonyms: []string{"dolor de pecho al hacer esfuerzo"}, Parents: []string{"dolor_pecho"}},
+			{ID: "cefalea", Label: "Dolor de cabeza", System: "neurologico", I18n: map[string]SymptomText{"en": {Label: "Headache"}},
+				Synonyms: []string{"dolor de cabeza", "me duele la cabeza", "jaqueca"}},
+			{ID: "nausea", Label: "Náusea", System: "digestivo", Scale: []string{"leve", "moderado"}, I18n: map[string]SymptomText{"en": {Label: "Nausea"}},
+				Synonyms: []string{"ganas de vomitar", "mareo"}},
 		},
 		Diseases: []Disease{
 			{
@@ -125,12 +301,17 @@ func defaultSnapshot() Snapshot {
 		},
 		Medications: []Medication{
 			{
-				ID:     "paracetamol",
-				Label:  "Paracetamol",
-				Treats: []string{"gripe"},
-				Contra: []string{"alergia_paracetamol"},
+				ID:         "paracetamol",
+				Label:      "Paracetamol",
+				Treats:     []string{"gripe"},
+				TreatLines: map[string]TherapyLine{"gripe": {Line: 1, Rank: 1}},
+				Contra:     []string{"alergia_paracetamol"},
 			},
 		},
+		RedFlags: []RedFlag{
+			{ID: "disnea_moderada", Symptoms: []string{"disnea"}, MinSeverity: 2, Level: "Atención prioritaria"},
+			{ID: "dolor_pecho", Symptoms: []string{"dolor_pecho"}, MinSeverity: 1, Level: "Atención prioritaria"},
+		},
 	}
 }
 
@@ -161,7 +342,13 @@ func main() {
 
 	// API paciente
 	mux.HandleFunc("/api/diagnose", handleDiagnose)
+	mux.HandleFunc("/api/diagnose/batch", handleDiagnoseBatch)
+	mux.HandleFunc("/api/next-question", handleNextQuestion)
+	mux.HandleFunc("/api/consultations", handleConsultations)
+	mux.HandleFunc("/api/consultations/", handleConsultation)
 	mux.HandleFunc("/api/symptoms", handlePublicSymptoms) 
+	mux.HandleFunc("/api/symptoms/search", handleSymptomSearch)
+	mux.HandleFunc("/api/complaint/parse", handleComplaintParse)
 	// API Admin: snapshot KB
 	mux.HandleFunc("/api/admin/snapshot", handleAdminSnapshot)
 
@@ -273,110 +460,233 @@ func handleDiagnose(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	var req DiagnoseReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	req, err := readDiagnoseReq(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 1) Cargar reglas y KB
-	rules, err := readRules()
+	resp, err := runDiagnose(r.Context(), engines.current(), req)
 	if err != nil {
-		http.Error(w, "rules.pl not found", http.StatusInternalServerError)
+		writePrologError(w, err)
 		return
 	}
-	kb, err := readKB()
-	if err != nil {
-		kb = []byte{}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// readDiagnoseReq decodifica el body, aplica ?explain=, ?mode= y las opciones por query, y valida.
+func readDiagnoseReq(r *http.Request) (DiagnoseReq, error) {
+	var req DiagnoseReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, fmt.Errorf("bad request")
 	}
+	return req, applyQuery(r, &req)
+}
 
-	// 2) Crear intérprete e inyectar reglas + KB
-	p := iprolog.New(nil, nil)
-	if err := p.Exec(string(rules)); err != nil {
-		log.Println("prolog rules error:", err)
-		http.Error(w, "prolog rules error", http.StatusInternalServerError)
-		return
+// applyQuery aplica ?explain=, ?mode= y las opciones por query sobre req y lo valida.
+func applyQuery(r *http.Request, req *DiagnoseReq) error {
+	if r.URL.Query().Get("explain") == "1" {
+		req.Explain = true
 	}
-	if len(kb) > 0 {
-		if err := p.Exec(string(kb)); err != nil {
-			log.Println("prolog kb error:", err)
-			http.Error(w, "prolog kb error", http.StatusInternalServerError)
-			return
-		}
+	if m := r.URL.Query().Get("mode"); m != "" {
+		req.Mode = m
+	}
+	if !validMode(req.Mode) {
+		return fmt.Errorf("unknown mode (afinidad|bayes)")
 	}
+	if err := optionsFromQuery(r, &req.Options); err != nil {
+		return err
+	}
+	return validateDemographics(req)
+}
 
-	// 3) Asertar hechos de la sesión (limpia + normaliza severidad)
-	{
-		var b strings.Builder
-		// Limpiar hechos de sesión previos
-		b.WriteString("retractall(presente(_, _)).\n")
-		b.WriteString("retractall(alergia(_)).\n")
-		b.WriteString("retractall(cronica(_)).\n")
+// Motores de puntuación disponibles para /api/diagnose
+const (
+	modeAffinity = "afinidad"
+	modeBayes    = "bayes"
+)
 
-		normalize := func(sev string) int {
-			sev = strings.ToLower(strings.TrimSpace(sev))
-			// acepta "1/2/3"
-			if n, err := strconv.Atoi(sev); err == nil && n >= 1 && n <= 3 {
-				return n
-			}
-			// o "leve/moderado/severo"
-			switch sev {
-			case "severo":
-				return 3
-			case "moderado":
-				return 2
-			default:
-				return 1 // leve por defecto
-			}
-		}
+func validMode(m string) bool { return m == "" || m == modeAffinity || m == modeBayes }
 
-		for _, s := range req.Symptoms {
-			if !s.Present {
-				continue
-			}
-			wgt := normalize(s.Severity)
-			fmt.Fprintf(&b, "presente(%s,%d).\n", safeAtom(s.ID), wgt)
-		}
-		for _, a := range req.Allergies {
-			fmt.Fprintf(&b, "alergia(%s).\n", safeAtom(a))
+// optionsFromQuery completa las opciones con la query (tiene prioridad sobre el body) y las valida.
+func optionsFromQuery(r *http.Request, o *DiagnoseOptions) error {
+	q := r.URL.Query()
+	if v := q.Get("min_affinity"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("min_affinity inválido: %q", v)
 		}
-		for _, c := range req.Chronics {
-			fmt.Fprintf(&b, "cronica(%s).\n", safeAtom(c))
+		o.MinAffinity = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("limit inválido: %q", v)
 		}
+		o.Limit = n
+	}
+	if v := q.Get("system"); v != "" {
+		o.Systems = strings.Split(v, ",")
+	}
+	if v := q.Get("type"); v != "" {
+		o.Types = strings.Split(v, ",")
+	}
+	if v := q.Get("skip_zero"); v != "" {
+		o.SkipZero = v == "1" || v == "true"
+	}
+	if o.MinAffinity < 0 || o.MinAffinity > 100 {
+		return fmt.Errorf("min_affinity debe estar entre 0 y 100")
+	}
+	if o.Limit < 0 {
+		return fmt.Errorf("limit no puede ser negativo")
+	}
+	return nil
+}
 
-		if err := p.Exec(b.String()); err != nil {
-			log.Println("assert session facts error:", err)
-			http.Error(w, "prolog assert error", http.StatusInternalServerError)
-			return
+// validateDemographics normaliza sexo e inicio de síntomas y revisa rangos de edad, peso y duración.
+func validateDemographics(req *DiagnoseReq) error {
+	switch strings.ToLower(strings.TrimSpace(req.Sex)) {
+	case "":
+		req.Sex = ""
+	case "m", "masculino", "hombre":
+		req.Sex = "m"
+	case "f", "femenino", "mujer":
+		req.Sex = "f"
+	default:
+		return fmt.Errorf("sex inválido (m|f)")
+	}
+	if req.Age != nil && (*req.Age < 0 || *req.Age > 130) {
+		return fmt.Errorf("age debe estar entre 0 y 130")
+	}
+	if req.WeightKg < 0 || req.WeightKg > 500 {
+		return fmt.Errorf("weight_kg debe estar entre 0 y 500")
+	}
+	if req.Pregnant && req.Sex == "m" {
+		return fmt.Errorf("pregnant no aplica con sex=m")
+	}
+	for i := range req.Symptoms {
+		s := &req.Symptoms[i]
+		onset, ok := normOnset(s.Onset)
+		if !ok {
+			return fmt.Errorf("síntoma %s: onset inválido (subito|gradual)", s.ID)
+		}
+		s.Onset = onset
+		if s.DurationDays < 0 || s.DurationDays > 36500 {
+			return fmt.Errorf("síntoma %s: duration_days fuera de rango", s.ID)
 		}
 	}
+	return nil
+}
 
-	// 4) Urgencia (global según síntomas presentes)
-	urg := "Observación recomendada"
-	if q, err := p.Query("urgencia(U)."); err == nil {
-		for q.Next() {
-			var res struct{ U string }
-			if err := q.Scan(&res); err == nil && res.U != "" {
-				urg = res.U
-				break
-			}
+// normOnset acepta subito/súbito/agudo y gradual/progresivo ("" = no informado).
+func normOnset(v string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "":
+		return "", true
+	case "subito", "súbito", "agudo":
+		return "subito", true
+	case "gradual", "progresivo":
+		return "gradual", true
+	}
+	return "", false
+}
+
+// severityWeight normaliza la severidad: acepta "1/2/3" o "leve/moderado/severo".
+func severityWeight(sev string) int {
+	sev = strings.ToLower(strings.TrimSpace(sev))
+	if n, err := strconv.Atoi(sev); err == nil && n >= 1 && n <= 3 {
+		return n
+	}
+	switch sev {
+	case "severo":
+		return 3
+	case "moderado":
+		return 2
+	default:
+		return 1 // leve por defecto
+	}
+}
+
+// assertSession aserta presente/2, ausente/1, alergia/1 y cronica/1 en un intérprete del pool.
+func assertSession(p *session, req DiagnoseReq) error {
+	var b strings.Builder
+	for _, s := range req.Symptoms {
+		if !s.Present {
+			fmt.Fprintf(&b, ":- assertz(ausente(%s)).\n", safeAtom(s.ID))
+			continue
 		}
-		q.Close()
+		fmt.Fprintf(&b, ":- assertz(presente(%s,%d)).\n", safeAtom(s.ID), severityWeight(s.Severity))
+		if s.DurationDays > 0 {
+			fmt.Fprintf(&b, ":- assertz(duracion(%s,%d)).\n", safeAtom(s.ID), s.DurationDays)
+		}
+		if s.Onset != "" {
+			fmt.Fprintf(&b, ":- assertz(inicio(%s,%s)).\n", safeAtom(s.ID), s.Onset)
+		}
+	}
+	for _, a := range req.Allergies {
+		fmt.Fprintf(&b, ":- assertz(alergia(%s)).\n", safeAtom(a))
+	}
+	for _, c := range req.Chronics {
+		fmt.Fprintf(&b, ":- assertz(cronica(%s)).\n", safeAtom(c))
+	}
+	for _, m := range req.CurrentMeds {
+		fmt.Fprintf(&b, ":- assertz(toma(%s)).\n", safeAtom(m))
+	}
+	if req.Age != nil {
+		fmt.Fprintf(&b, ":- assertz(edad(%d)).\n", *req.Age)
+	}
+	if req.Sex != "" {
+		fmt.Fprintf(&b, ":- assertz(sexo(%s)).\n", req.Sex)
+	}
+	if req.Pregnant {
+		b.WriteString(":- assertz(embarazo).\n")
+	}
+	if req.WeightKg > 0 {
+		fmt.Fprintf(&b, ":- assertz(peso_kg(%s)).\n", plFloat(req.WeightKg))
+	}
+	return p.Exec(b.String())
+}
+
+// diagnose recorre enfermedad/4 sobre un intérprete con la sesión ya asertada.
+func diagnose(p *session, req DiagnoseReq) (DiagnoseResp, error) {
+	// 1) Triage global (todos los síntomas presentes) + cláusula que lo decidió
+	gt := queryUrgency(p)
+	triage := Triage{Level: gt.Level, Rule: gt.Rule}
+	if gt.Rule != "caso_base" {
+		triage.Symptom = gt.Symptom
 	}
 
-	// 5) Recorrer enfermedades y calcular afinidad + medicamentos
 	type diagRow struct {
-		id, name, urg string
-		aff           int
-		matched       []string
-		safeMeds      []string
+		id, name string
+		aff      int
+		score    float64 // modo bayes: prior x verosimilitud (sin normalizar)
+	}
+	mode := req.Mode
+	if mode == "" {
+		mode = modeAffinity
+	}
+	opt := req.Options
+	reqCap := ruleInt(p, "tope_requerido") // para advertencias y trazas; el tope lo aplica afinidad/3
+
+	// 2) Candidatas: sistema/tipo (y coincidencia mínima) se filtran en Prolog,
+	//    así las enfermedades excluidas nunca llegan a evaluarse
+	goal := fmt.Sprintf(`enf_candidata(Enf, Nombre, %s, %s)`, plAtomList(opt.Systems), plAtomList(opt.Types))
+	if opt.SkipZero {
+		goal += `, con_coincidencia(Enf)`
+	}
+	// Modo bayes: la posterior se normaliza entre todas las enfermedades de la KB,
+	// antes de filtros, exclusiones y afinidad mínima (filtrar no cambia su valor)
+	var scores map[string]float64
+	total := 0.0
+	if mode == modeBayes {
+		scores, total = queryBayesScores(p)
 	}
 	var rows []diagRow
-
-	diseasesQ, err := p.Query(`enfermedad(Enf, Nombre, _, _).`)
+	var excluded []ExcludedDisease
+	diseasesQ, err := p.Query(goal + ".")
 	if err != nil {
-		http.Error(w, "query enfermedad/4 failed", http.StatusInternalServerError)
-		return
+		return DiagnoseResp{}, fmt.Errorf("query enfermedad/4 failed")
 	}
 	for diseasesQ.Next() {
 		var d struct {
@@ -386,12 +696,10 @@ func handleDiagnose(w http.ResponseWriter, r *http.Request) {
 		if err := diseasesQ.Scan(&d); err != nil {
 			continue
 		}
-		enfID := d.Enf
-		enfName := d.Nombre
 
 		// afinidad(Enf, A, _)
 		aff := 0
-		if q, err := p.Query(fmt.Sprintf(`afinidad(%s, A, _).`, safeAtom(enfID))); err == nil {
+		if q, err := p.Query(fmt.Sprintf(`afinidad(%s, A, _).`, safeAtom(d.Enf))); err == nil {
 			if q.Next() {
 				var a struct{ A int }
 				if err := q.Scan(&a); err == nil {
@@ -400,10 +708,49 @@ func handleDiagnose(w http.ResponseWriter, r *http.Request) {
 			}
 			q.Close()
 		}
+		// enf_excluye/2 antes de la afinidad mínima: una candidata descartada por un
+		// hallazgo excluyente se informa aparte aunque no llegue al mínimo
+		if ex := queryExcluded(p, d.Enf); len(ex) > 0 {
+			excluded = append(excluded, excludedDisease(d.Nombre, aff, ex))
+			continue
+		}
+		if aff < opt.MinAffinity {
+			continue
+		}
+		rows = append(rows, diagRow{id: d.Enf, name: d.Nombre, aff: aff, score: scores[d.Enf]})
+	}
+	diseasesQ.Close()
+
+	// 3) Posterior (modo bayes), orden y top-N
+	posteriors := make([]float64, len(rows))
+	if total > 0 {
+		for i, r2 := range rows {
+			posteriors[i] = math.Round(r2.score/total*10000) / 10000
+		}
+	}
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		if mode == modeBayes && posteriors[idx[a]] != posteriors[idx[b]] {
+			return posteriors[idx[a]] > posteriors[idx[b]]
+		}
+		return rows[idx[a]].aff > rows[idx[b]].aff
+	})
+	if opt.Limit > 0 && len(idx) > opt.Limit {
+		idx = idx[:opt.Limit]
+	}
+
+	// 4) Detalle (síntomas, medicamentos, trazas) solo de las que se devuelven
+	resp := DiagnoseResp{Mode: mode}
+	var lines []string
+	for _, i := range idx {
+		r2 := rows[i]
 
 		// síntomas que hicieron match (normalizados)
 		var matched []string
-		if q, err := p.Query(fmt.Sprintf(`enf_sintoma(%s,S), presentepeso(S,_).`, safeAtom(enfID))); err == nil {
+		if q, err := p.Query(fmt.Sprintf(`enf_sintoma(%s,S), presente_ev(S,_).`, safeAtom(r2.id))); err == nil {
 			seen := map[string]struct{}{}
 			for q.Next() {
 				var s struct{ S string }
@@ -417,106 +764,288 @@ func handleDiagnose(w http.ResponseWriter, r *http.Request) {
 			q.Close()
 		}
 
-		// medicamentos seguros por regla
-		safeMeds := []string{}
-		if q, err := p.Query(fmt.Sprintf(`medicamento_seguro(%s, M).`, safeAtom(enfID))); err == nil {
-			for q.Next() {
-				var m struct{ M string }
-				if err := q.Scan(&m); err == nil {
-					safeMeds = append(safeMeds, m.M)
-				}
+		// seguros ordenados por línea/rank; interacciones no graves: se advierten y pasan al final
+		opts := queryTherapyLines(p, r2.id, safeMedications(p, r2.id, req))
+		inter := queryInteractions(p, r2.id)
+		opts = demoteInteracting(opts, inter)
+		// hechos que descartaron medicamentos (para RulesFired y la traza)
+		blocked := queryBlocked(p, r2.id)
+		// síntomas negados que restaron afinidad
+		denied := queryDenied(p, r2.id)
+		// urgencia/2: solo los síntomas, tipo y banderas rojas de esta enfermedad
+		ut := queryDiseaseUrgency(p, r2.id)
+		if urgencyRank(ut.Level) > urgencyRank(triage.Level) {
+			triage = Triage{Level: ut.Level, Rule: ut.Rule, Symptom: ut.Symptom, Disease: r2.id}
+		}
+
+		var dosage *DosageInfo
+		if len(opts) > 0 {
+			dosage = queryDosage(p, opts[0].Drug)
+		}
+		lo, hi, ageOut := queryAgeRange(p, r2.id)
+		missing := queryMissingRequired(p, r2.id)
+		dg := diagnosisParts{
+			name: r2.name, aff: r2.aff, posterior: posteriors[i], matched: matched,
+			opts: opts, inter: inter, blocked: blocked, denied: denied, urgency: ut.Level,
+			dosage: dosage, ageOut: ageOut, ageMin: lo, ageMax: hi, missing: missing, reqCap: reqCap,
+		}.diagnosis(mode)
+		if req.Explain {
+			pairs := queryScorePairs(p, r2.id)
+			max := queryMaxScore(p, r2.id)
+			timing := queryTiming(p, r2.id)
+			dg.Proof = buildProof(r2.id, r2.aff, max, pairs, denied, timing, missing, reqCap, ut, opts, blocked)
+			if mode == modeBayes {
+				dg.Proof.Children = append(dg.Proof.Children, queryBayesTrace(p, r2.id).node(r2.id, posteriors[i]))
+			}
+			lines = append(lines, explainText(r2.name, r2.aff, pairs, denied, timing, missing, reqCap, ut, blocked))
+		}
+		resp.Diagnoses = append(resp.Diagnoses, dg)
+	}
+	resp.Triage = triage
+	resp.Excluded = excluded
+	if req.Explain {
+		for _, ex := range excluded {
+			lines = append(lines, ex.Reason+".")
+		}
+	}
+	resp.Explanations = "Diagnóstico realizado con Ichiban Prolog: afinidad/3, urgencia/2 y medicamento_seguro/2."
+	if len(lines) > 0 {
+		resp.Explanations = strings.Join(lines, " ")
+	}
+	return resp, nil
+}
+
+// queryInteractions: alerta_interaccion/4 (leve/moderada) de los medicamentos seguros.
+func queryInteractions(p *session, enfID string) []DrugInteraction {
+	var out []DrugInteraction
+	q, err := p.Query(fmt.Sprintf(`alerta_interaccion(%s, M, T, Sev).`, safeAtom(enfID)))
+	if err != nil {
+		return out
+	}
+	defer q.Close()
+	for q.Next() {
+		var row struct{ M, T, Sev string }
+		if err := q.Scan(&row); err == nil {
+			out = append(out, DrugInteraction{Drug: row.M, With: row.T, Severity: row.Sev})
+		}
+	}
+	return out
+}
+
+// queryTherapyLines: linea/4 de cada medicamento seguro, ordenados por línea y rank
+// (orden estable; los que no declaran línea van al final).
+func queryTherapyLines(p *session, enfID string, meds []string) []TherapyOption {
+	out := make([]TherapyOption, 0, len(meds))
+	for _, m := range meds {
+		o := TherapyOption{Drug: m}
+		if q, err := p.Query(fmt.Sprintf(`linea(%s, %s, L, R).`, safeAtom(m), safeAtom(enfID))); err == nil {
+			var row struct{ L, R int }
+			if q.Next() && q.Scan(&row) == nil {
+				o.Line, o.Rank = row.L, row.R
 			}
 			q.Close()
 		}
+		out = append(out, o)
+	}
+	sortTherapyOptions(out)
+	return out
+}
 
-		// Fallback si no hubo resultados (filtra contraindicaciones básicas)
-		if len(safeMeds) == 0 {
-			// candidatos que tratan la enfermedad
-			cands := []string{}
-			if q, err := p.Query(fmt.Sprintf(`trata(M,%s).`, safeAtom(enfID))); err == nil {
-				for q.Next() {
-					var m struct{ M string }
-					if err := q.Scan(&m); err == nil {
-						cands = append(cands, m.M)
-					}
-				}
-				q.Close()
+// sortTherapyOptions ordena por línea y rank (estable; sin línea declarada al final).
+func sortTherapyOptions(out []TherapyOption) {
+	key := func(o TherapyOption) [2]int {
+		if o.Line == 0 {
+			return [2]int{math.MaxInt, 0}
+		}
+		return [2]int{o.Line, o.Rank}
+	}
+	sort.SliceStable(out, func(a, b int) bool {
+		ka, kb := key(out[a]), key(out[b])
+		return ka[0] < kb[0] || (ka[0] == kb[0] && ka[1] < kb[1])
+	})
+}
+
+// demoteInteracting deja al final (orden estable) los medicamentos con interacciones.
+func demoteInteracting(meds []TherapyOption, inter []DrugInteraction) []TherapyOption {
+	if len(inter) == 0 {
+		return meds
+	}
+	flagged := map[string]struct{}{}
+	for _, in := range inter {
+		flagged[in.Drug] = struct{}{}
+	}
+	out := make([]TherapyOption, 0, len(meds))
+	var last []TherapyOption
+	for _, m := range meds {
+		if _, ok := flagged[m.Drug]; ok {
+			last = append(last, m)
+		} else {
+			out = append(out, m)
+		}
+	}
+	return append(out, last...)
+}
+
+// queryDosage: dosis/6 del medicamento con la edad/peso asertados (nil si ninguna banda aplica).
+func queryDosage(p *session, med string) *DosageInfo {
+	q, err := p.Query(fmt.Sprintf(
+		`dosis(%s, D0, Via, H, M0, Tope), D is float(D0), M is float(M0).`, safeAtom(med)))
+	if err != nil {
+		return nil
+	}
+	defer q.Close()
+	var row struct {
+		D, M      float64
+		Via, Tope string
+		H         int
+	}
+	if !q.Next() || q.Scan(&row) != nil {
+		return nil
+	}
+	return newDosageInfo(med, row.D, row.Via, row.H, row.M, row.Tope == "si")
+}
+
+// newDosageInfo redondea la dosis por toma a 0.1 mg y arma el texto para el paciente.
+func newDosageInfo(med string, dose float64, route string, everyH int, maxDaily float64, capped bool) *DosageInfo {
+	d := &DosageInfo{
+		Drug:       med,
+		DoseMg:     math.Round(dose*10) / 10,
+		Route:      route,
+		EveryHours: everyH,
+		MaxDailyMg: maxDaily,
+		Capped:     capped,
+	}
+	d.Text = fmt.Sprintf("%s mg vía %s cada %d h (máx. %s mg/día)",
+		strconv.FormatFloat(d.DoseMg, 'f', -1, 64), d.Route, d.EveryHours, strconv.FormatFloat(d.MaxDailyMg, 'f', -1, 64))
+	return d
+}
+
+// queryExcluded: síntomas de enf_excluye/2 presentes (excluida/2), sin repetidos.
+func queryExcluded(p *session, enfID string) []string {
+	var out []string
+	q, err := p.Query(fmt.Sprintf(`excluida(%s, S).`, safeAtom(enfID)))
+	if err != nil {
+		return out
+	}
+	defer q.Close()
+	for q.Next() {
+		var row struct{ S string }
+		if err := q.Scan(&row); err == nil && !contains(out, row.S) {
+			out = append(out, row.S)
+		}
+	}
+	return out
+}
+
+// queryMissingRequired: falta_requerido/2 (síntomas requeridos sin evidencia), sin repetidos.
+func queryMissingRequired(p *session, enfID string) []string {
+	var out []string
+	q, err := p.Query(fmt.Sprintf(`falta_requerido(%s, S).`, safeAtom(enfID)))
+	if err != nil {
+		return out
+	}
+	defer q.Close()
+	for q.Next() {
+		var row struct{ S string }
+		if err := q.Scan(&row); err == nil && !contains(out, row.S) {
+			out = append(out, row.S)
+		}
+	}
+	return out
+}
+
+// queryAgeRange: rango enf_edad/3 si la edad del paciente cae fuera de él.
+func queryAgeRange(p *session, enfID string) (int, int, bool) {
+	q, err := p.Query(fmt.Sprintf(`fuera_rango_edad(%s, Min, Max).`, safeAtom(enfID)))
+	if err != nil {
+		return 0, 0, false
+	}
+	defer q.Close()
+	var row struct{ Min, Max int }
+	if !q.Next() || q.Scan(&row) != nil {
+		return 0, 0, false
+	}
+	return row.Min, row.Max, true
+}
+
+// safeMedications: medicamento_seguro/2; si no hay resultados, filtra trata/2 en Go
+// con las contraindicaciones básicas (KB antiguas).
+func safeMedications(p *session, enfID string, req DiagnoseReq) []string {
+	safeMeds := []string{}
+	if q, err := p.Query(fmt.Sprintf(`medicamento_seguro(%s, M).`, safeAtom(enfID))); err == nil {
+		for q.Next() {
+			var m struct{ M string }
+			if err := q.Scan(&m); err == nil {
+				safeMeds = append(safeMeds, m.M)
+			}
+		}
+		q.Close()
+	}
+	if len(safeMeds) > 0 {
+		return safeMeds
+	}
+
+	// candidatos que tratan la enfermedad
+	cands := []string{}
+	if q, err := p.Query(fmt.Sprintf(`trata(M,%s).`, safeAtom(enfID))); err == nil {
+		for q.Next() {
+			var m struct{ M string }
+			if err := q.Scan(&m); err == nil {
+				cands = append(cands, m.M)
 			}
-			// bloqueos por alergias/crónicas del paciente
-			blocked := map[string]struct{}{}
-			for _, a := range req.Allergies {
-				blocked[safeAtom(a)] = struct{}{}
-			}
-			for _, c := range req.Chronics {
-				blocked[safeAtom(c)] = struct{}{}
-			}
-			for _, cand := range cands {
-				bad := false
-				// contraindicado(Med, Cond)
-				if q, err := p.Query(fmt.Sprintf(`contraindicado(%s,Cond).`, safeAtom(cand))); err == nil {
-					for q.Next() {
-						var row struct{ Cond string }
-						if err := q.Scan(&row); err == nil {
-							if _, ok := blocked[row.Cond]; ok {
-								bad = true
-								break
-							}
-						}
+		}
+		q.Close()
+	}
+	// bloqueos por alergias/crónicas del paciente
+	blocked := map[string]struct{}{}
+	for _, a := range req.Allergies {
+		blocked[safeAtom(a)] = struct{}{}
+	}
+	for _, c := range req.Chronics {
+		blocked[safeAtom(c)] = struct{}{}
+	}
+	for _, cand := range cands {
+		bad := false
+		// contraindicado(Med, Cond)
+		if q, err := p.Query(fmt.Sprintf(`contraindicado(%s,Cond).`, safeAtom(cand))); err == nil {
+			for q.Next() {
+				var row struct{ Cond string }
+				if err := q.Scan(&row); err == nil {
+					if _, ok := blocked[row.Cond]; ok {
+						bad = true
+						break
 					}
-					q.Close()
 				}
-				// enf_contra_medicamento(Enf, Med)
-				if !bad {
-					if q, err := p.Query(fmt.Sprintf(
-						`enf_contra_medicamento(%s,%s).`, safeAtom(enfID), safeAtom(cand),
-					)); err == nil {
-						if q.Next() {
-							bad = true
-						}
-						q.Close()
-					}
+			}
+			q.Close()
+		}
+		// edad, embarazo y demás motivos de rules.pl
+		if !bad {
+			if q, err := p.Query(fmt.Sprintf(
+				`motivo_bloqueo(%s,%s,_,_).`, safeAtom(enfID), safeAtom(cand),
+			)); err == nil {
+				if q.Next() {
+					bad = true
 				}
-				if !bad {
-					safeMeds = append(safeMeds, cand)
+				q.Close()
+			}
+		}
+		// enf_contra_medicamento(Enf, Med)
+		if !bad {
+			if q, err := p.Query(fmt.Sprintf(
+				`enf_contra_medicamento(%s,%s).`, safeAtom(enfID), safeAtom(cand),
+			)); err == nil {
+				if q.Next() {
+					bad = true
 				}
+				q.Close()
 			}
 		}
-
-		rows = append(rows, diagRow{
-			id: enfID, name: enfName, aff: aff, urg: urg, matched: matched, safeMeds: safeMeds,
-		})
-	}
-	diseasesQ.Close()
-
-	// 6) Orden y respuesta
-	sort.Slice(rows, func(i, j int) bool { return rows[i].aff > rows[j].aff })
-
-	resp := DiagnoseResp{}
-	for _, r2 := range rows {
-		rf := []string{"afinidad/3", "urgencia/1", "medicamento_seguro/2"}
-		med := ""
-		if len(r2.safeMeds) > 0 {
-			med = r2.safeMeds[0]
-		}
-		var alts []string
-		if len(r2.safeMeds) > 1 {
-			alts = r2.safeMeds[1:]
-		}
-		resp.Diagnoses = append(resp.Diagnoses, Diagnosis{
-			Disease:         r2.name,
-			Affinity:        r2.aff,
-			SuggestedDrug:   med,
-			Alternatives:    alts,
-			Urgency:         r2.urg,
-			Warnings:        []string{},
-			RulesFired:      rf,
-			MatchedSymptoms: r2.matched,
-		})
+		if !bad {
+			safeMeds = append(safeMeds, cand)
+		}
 	}
-	resp.Explanations = "Diagnóstico realizado con Ichiban Prolog: afinidad/3, urgencia/1 y medicamento_seguro/2."
-
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
+	return safeMeds
 }
 
 /* ===========================================================
@@ -529,32 +1058,38 @@ func handleDebugEnf(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing ?id=gripe", http.StatusBadRequest)
 		return
 	}
-	kb, err := readKB()
+	eng := engines.current()
+	p, err := eng.acquire(r.Context())
 	if err != nil {
-		http.Error(w, "kb not found", http.StatusInternalServerError)
-		return
-	}
-	p := iprolog.New(nil, nil)
-	if err := p.Exec(string(kb)); err != nil {
 		http.Error(w, "kb load error", http.StatusInternalServerError)
 		return
 	}
-	q, err := p.Query(fmt.Sprintf(`enf_sintoma(%s,S).`, safeAtom(id)))
+	defer eng.release(p)
+	q, err := p.Query(fmt.Sprintf(`enf_sintoma(%s,S), peso_vinculo(%s,S,W).`, safeAtom(id), safeAtom(id)))
 	if err != nil {
 		http.Error(w, "query error", http.StatusInternalServerError)
 		return
 	}
 	var out []string
+	weights := map[string]int{}
 	for q.Next() {
-		var s struct{ S string }
+		var s struct {
+			S string
+			W int
+		}
 		if err := q.Scan(&s); err == nil {
 			out = append(out, s.S)
+			weights[s.S] = s.W
 		}
 	}
 	q.Close()
+	if le := p.limitErr(); le != nil {
+		writePrologError(w, le)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{"id": id, "symptoms": out})
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "symptoms": out, "weights": weights})
 }
 
 func handleDebugPres(w http.ResponseWriter, r *http.Request) {
@@ -567,7 +1102,8 @@ func handleDebugPres(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	p := iprolog.New(nil, nil)
+	p := newSession(r.Context(), iprolog.New(nil, nil))
+	defer p.cancel()
 
 	sevW := map[string]int{"leve": 1, "moderado": 2, "severo": 3}
 	var asserts []string
@@ -645,7 +1181,8 @@ func handleDebugMedSeguro(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	p := iprolog.New(nil, nil)
+	p := newSession(r.Context(), iprolog.New(nil, nil))
+	defer p.cancel()
 	if err := p.Exec(string(rules)); err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -715,7 +1252,8 @@ func handleDebugRules(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "readRules error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	p := iprolog.New(nil, nil)
+	p := newSession(r.Context(), iprolog.New(nil, nil))
+	defer p.cancel()
 	if err := p.Exec(string(rules)); err != nil {
 		http.Error(w, "rules exec error: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -762,7 +1300,8 @@ func handleDebugAfinidad(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	p := iprolog.New(nil, nil)
+	p := newSession(r.Context(), iprolog.New(nil, nil))
+	defer p.cancel()
 	if err := p.Exec(string(rules)); err != nil {
 		http.Error(w, "rules exec error: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -793,7 +1332,7 @@ func handleDebugAfinidad(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	for _, s := range req.Symptoms {
-		if !s.Present { continue }
+		if !s.Present { fmt.Fprintf(&b, "ausente(%s).\n", safeAtom(s.ID)); continue }
 		fmt.Fprintf(&b, "presente(%s,%d).\n", safeAtom(s.ID), normalize(s.Severity))
 	}
 	for _, a := range req.Allergies { fmt.Fprintf(&b, "alergia(%s).\n", safeAtom(a)) }
@@ -823,7 +1362,7 @@ func handleDebugAfinidad(w http.ResponseWriter, r *http.Request) {
 	// Matched normalizados (S,P) que contaron para el puntaje
 	type mp struct{ S string; P int }
 	matched := []mp{}
-	if q, err := p.Query(fmt.Sprintf(`enf_sintoma(%s,S), presentepeso(S,P).`, safeAtom(enf))); err == nil {
+	if q, err := p.Query(fmt.Sprintf(`enf_sintoma(%s,S), max_peso_sintoma(S,P).`, safeAtom(enf))); err == nil {
 		seen := map[string]struct{}{}
 		for q.Next() {
 			var row struct{ S string; P int }
@@ -875,8 +1414,8 @@ func handleDebugAfinidad(w http.ResponseWriter, r *http.Request) {
 		"id":       enf,
 		"reqs":     reqs,        // todos los síntomas requeridos (
 		"matched":  matched,     // los que contaron con su peso
-		"puntaje":  puntaje,     // suma de pesos
-		"max":      max,         // 3 * #reqs
+		"puntaje":  puntaje,     // suma de severidad x peso del vínculo
+		"max":      max,         // 3 * suma de pesos de los vínculos
 		"afinidad": afin,        // round(puntaje*100/max)
 	})
 }
@@ -916,6 +1455,10 @@ func handleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if err := writePLFromSnapshot(snap); err != nil {
+			if errors.Is(err, errInvalidKB) {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
 			http.Error(w, "cannot write .pl: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -929,22 +1472,6 @@ func handleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
 
 
 
-func handlePublicSymptoms(w http.ResponseWriter, r *http.Request) {
-    snap, err := loadSnapshotFromPL()
-    if err != nil {
-        http.Error(w, "cannot load kb", http.StatusInternalServerError)
-        return
-    }
-    ids := make([]string, 0, len(snap.Symptoms))
-    for _, s := range snap.Symptoms {
-        ids = append(ids, s.ID)
-    }
-    sort.Strings(ids)
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]any{"symptoms": ids})
-}
-
-
 /* ===========================================================
    Lectura/Escritura de KB (.pl)
    =========================================================== */
@@ -959,12 +1486,16 @@ func readKB() ([]byte, error) {
 func writeKBAtomic(b []byte) error {
 	kbMu.Lock()
 	defer kbMu.Unlock()
-	tmp := kbPath + ".tmp"
-	if err := os.WriteFile(tmp, b, 0644); err != nil {
-		return err
-	}
-	_ = os.Remove(kbPath) // Windows: Rename no sobreescribe
-	return os.Rename(tmp, kbPath)
+	// Compila antes de escribir: una KB inválida no reemplaza al archivo ni al motor
+	_, err := engines.reload(b, func() error {
+		tmp := kbPath + ".tmp"
+		if err := os.WriteFile(tmp, b, 0644); err != nil {
+			return err
+		}
+		_ = os.Remove(kbPath) // Windows: Rename no sobreescribe
+		return os.Rename(tmp, kbPath)
+	})
+	return err
 }
 
 func readRules() ([]byte, error) {
@@ -1010,6 +1541,10 @@ func handleKBImport(w http.ResponseWriter, r *http.Request) {
 	}
 	// Escribe sin validar (endpoint “raw”); el panel usa /api/admin/snapshot
 	if err := writeKBAtomic(body); err != nil {
+		if errors.Is(err, errInvalidKB) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "cannot write kb: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -1025,17 +1560,42 @@ func loadSnapshotFromPL() (Snapshot, error) {
 	if err != nil { // si no existe, usa bootstrap
 		return defaultSnapshot(), nil
 	}
-	lines := normalizePL(string(b))
+	return parseSnapshotPL(string(b)), nil
+}
+
+// parseSnapshotPL arma el Snapshot con los hechos que reconoce (el resto se ignora).
+func parseSnapshotPL(src string) Snapshot {
+	lines := normalizePL(src)
 	var snap = defaultEmptySnapshot()
 
 	reSint := regexp.MustCompile(`^sintoma\((\w+)\)\.$`)
+	reSin := regexp.MustCompile(`^sinonimo\((\w+),\s*\"([^\"]*)\"\)\.$`)
+	reSinPadre := regexp.MustCompile(`^sintoma_padre\((\w+),\s*(\w+)\)\.$`)
+	reSinTexto := regexp.MustCompile(`^texto_sintoma\((\w+),\s*(\w+),\s*\"([^\"]*)\",\s*\"([^\"]*)\"\)\.$`)
+	reSinSis := regexp.MustCompile(`^sistema_sintoma\((\w+),\s*(\w+)\)\.$`)
+	reSinEscala := regexp.MustCompile(`^escala_sintoma\((\w+),\s*\[([\w,\s]*)\]\)\.$`)
 	reEnf := regexp.MustCompile(`^enfermedad\((\w+),\s*\"([^\"]*)\",\s*(\w+),\s*(\w+)\)\.$`)
 	reDesc := regexp.MustCompile(`^descripcion_enf\((\w+),\s*\"([^\"]*)\"\)\.$`)
-	reEnfS := regexp.MustCompile(`^enf_sintoma\((\w+),\s*(\w+)\)\.$`)
+	reEnfS := regexp.MustCompile(`^enf_sintoma\((\w+),\s*(\w+)(?:,\s*(\d+))?\)\.$`)
 	reEnfContraMed := regexp.MustCompile(`^enf_contra_medicamento\((\w+),\s*(\w+)\)\.$`)
+	reEnfExcl := regexp.MustCompile(`^enf_excluye\((\w+),\s*(\w+)\)\.$`)
+	reEnfReq := regexp.MustCompile(`^enf_requerido\((\w+),\s*(\w+)\)\.$`)
 	reMed := regexp.MustCompile(`^medicamento\((\w+)\)\.$`)
 	reTrat := regexp.MustCompile(`^trata\((\w+),\s*(\w+)\)\.$`)
+	reLinea := regexp.MustCompile(`^linea_tratamiento\((\w+),\s*(\w+),\s*(\d+),\s*(\d+)\)\.$`)
 	reContra := regexp.MustCompile(`^contraindicado\((\w+),\s*(\w+)\)\.$`)
+	rePenAus := regexp.MustCompile(`^penalizacion_ausente\((\w+),\s*(\d+)\)\.$`)
+	rePrev := regexp.MustCompile(`^prevalencia\((\w+),\s*([0-9.eE+-]+)\)\.$`)
+	reSens := regexp.MustCompile(`^sensibilidad\((\w+),\s*(\w+),\s*([0-9.eE+-]+)\)\.$`)
+	reEnfRF := regexp.MustCompile(`^enf_bandera_roja\((\w+),\s*(\w+),\s*(\d+)\)\.$`)
+	reEnfEdad := regexp.MustCompile(`^enf_edad\((\w+),\s*(\d+),\s*(\d+)\)\.$`)
+	reEnfDur := regexp.MustCompile(`^enf_duracion\((\w+),\s*(\d+),\s*(\d+)\)\.$`)
+	reEnfIni := regexp.MustCompile(`^enf_inicio\((\w+),\s*(\w+)\)\.$`)
+	reEdadMin := regexp.MustCompile(`^edad_minima\((\w+),\s*(\d+)\)\.$`)
+	reEmb := regexp.MustCompile(`^contra_embarazo\((\w+)\)\.$`)
+	reInter := regexp.MustCompile(`^interaccion\((\w+),\s*(\w+),\s*(\w+)\)\.$`)
+	rePos := regexp.MustCompile(`^posologia\((\w+),\s*(\d+),\s*(\d+),\s*([0-9.]+),\s*([0-9.]+),\s*([0-9.]+),\s*(\w+),\s*(\w+),\s*(\d+),\s*([0-9.]+)\)\.$`)
+	reFlag := regexp.MustCompile(`^bandera_roja\((\w+),\s*\[([\w,\s]*)\],\s*(\d+),\s*\"([^\"]*)\"\)\.$`)
 
 	dmap := map[string]*Disease{}
 	smap := map[string]*Symptom{}
@@ -1050,22 +1610,83 @@ func loadSnapshotFromPL() (Snapshot, error) {
 			}
 			continue
 		}
-		if m := reEnf.FindStringSubmatch(ln); m != nil {
-			id, name, system, typ := m[1], m[2], m[3], m[4]
-			enf := dmap[id]
-			if enf == nil {
-				enf = &Disease{ID: id}
-				dmap[id] = enf
+		if m := reSin.FindStringSubmatch(ln); m != nil {
+			id := m[1]
+			sym := smap[id]
+			if sym == nil {
+				sym = &Symptom{ID: id}
+				smap[id] = sym
 			}
-			enf.Name, enf.System, enf.Type = name, system, typ
+			sym.Synonyms = append(sym.Synonyms, m[2])
 			continue
 		}
-		if m := reDesc.FindStringSubmatch(ln); m != nil {
-			id, desc := m[1], m[2]
-			enf := dmap[id]
-			if enf == nil {
-				enf = &Disease{ID: id}
-				dmap[id] = enf
+		if m := reSinPadre.FindStringSubmatch(ln); m != nil {
+			id := m[1]
+			sym := smap[id]
+			if sym == nil {
+				sym = &Symptom{ID: id}
+				smap[id] = sym
+			}
+			sym.Parents = append(sym.Parents, m[2])
+			continue
+		}
+		if m := reSinTexto.FindStringSubmatch(ln); m != nil {
+			id, lang := m[1], m[2]
+			sym := smap[id]
+			if sym == nil {
+				sym = &Symptom{ID: id}
+				smap[id] = sym
+			}
+			if lang == defaultLang {
+				sym.Label, sym.Description = m[3], m[4]
+			} else {
+				if sym.I18n == nil {
+					sym.I18n = map[string]SymptomText{}
+				}
+				sym.I18n[lang] = SymptomText{Label: m[3], Description: m[4]}
+			}
+			continue
+		}
+		if m := reSinSis.FindStringSubmatch(ln); m != nil {
+			id := m[1]
+			sym := smap[id]
+			if sym == nil {
+				sym = &Symptom{ID: id}
+				smap[id] = sym
+			}
+			sym.System = m[2]
+			continue
+		}
+		if m := reSinEscala.FindStringSubmatch(ln); m != nil {
+			id := m[1]
+			sym := smap[id]
+			if sym == nil {
+				sym = &Symptom{ID: id}
+				smap[id] = sym
+			}
+			for _, x := range strings.Split(m[2], ",") {
+				if x = strings.TrimSpace(x); x != "" {
+					sym.Scale = append(sym.Scale, x)
+				}
+			}
+			continue
+		}
+		if m := reEnf.FindStringSubmatch(ln); m != nil {
+			id, name, system, typ := m[1], m[2], m[3], m[4]
+			enf := dmap[id]
+			if enf == nil {
+				enf = &Disease{ID: id}
+				dmap[id] = enf
+			}
+			enf.Name, enf.System, enf.Type = name, system, typ
+			continue
+		}
+		if m := reDesc.FindStringSubmatch(ln); m != nil {
+			id, desc := m[1], m[2]
+			enf := dmap[id]
+			if enf == nil {
+				enf = &Disease{ID: id}
+				dmap[id] = enf
 			}
 			enf.Description = desc
 			continue
@@ -1078,6 +1699,12 @@ func loadSnapshotFromPL() (Snapshot, error) {
 				dmap[enfID] = enf
 			}
 			enf.Symptoms = uniq(append(enf.Symptoms, symID))
+			if w, _ := strconv.Atoi(m[3]); w > 1 { // enf_sintoma/3 con peso distinto de 1
+				if enf.Weights == nil {
+					enf.Weights = map[string]int{}
+				}
+				enf.Weights[symID] = w
+			}
 			continue
 		}
 		if m := reEnfContraMed.FindStringSubmatch(ln); m != nil {
@@ -1090,6 +1717,167 @@ func loadSnapshotFromPL() (Snapshot, error) {
 			enf.ContraMeds = uniq(append(enf.ContraMeds, medID))
 			continue
 		}
+		if m := reEnfExcl.FindStringSubmatch(ln); m != nil {
+			enfID, symID := m[1], m[2]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			enf.Excludes = uniq(append(enf.Excludes, symID))
+			continue
+		}
+		if m := reEnfReq.FindStringSubmatch(ln); m != nil {
+			enfID, symID := m[1], m[2]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			enf.Required = uniq(append(enf.Required, symID))
+			continue
+		}
+		if m := rePenAus.FindStringSubmatch(ln); m != nil {
+			enfID := m[1]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			enf.AbsentPenalty, _ = strconv.Atoi(m[2])
+			continue
+		}
+		if m := rePrev.FindStringSubmatch(ln); m != nil {
+			enfID := m[1]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			enf.Prevalence, _ = strconv.ParseFloat(m[2], 64)
+			continue
+		}
+		if m := reSens.FindStringSubmatch(ln); m != nil {
+			enfID, symID := m[1], m[2]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			if enf.Sensitivity == nil {
+				enf.Sensitivity = map[string]float64{}
+			}
+			enf.Sensitivity[symID], _ = strconv.ParseFloat(m[3], 64)
+			continue
+		}
+		if m := reEnfRF.FindStringSubmatch(ln); m != nil {
+			enfID, symID := m[1], m[2]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			if enf.RedFlags == nil {
+				enf.RedFlags = map[string]int{}
+			}
+			enf.RedFlags[symID], _ = strconv.Atoi(m[3])
+			continue
+		}
+		if m := reEnfEdad.FindStringSubmatch(ln); m != nil {
+			enfID := m[1]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			enf.AgeMin, _ = strconv.Atoi(m[2])
+			enf.AgeMax, _ = strconv.Atoi(m[3])
+			continue
+		}
+		if m := reEnfDur.FindStringSubmatch(ln); m != nil {
+			enfID := m[1]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			enf.DurationMin, _ = strconv.Atoi(m[2])
+			enf.DurationMax, _ = strconv.Atoi(m[3])
+			continue
+		}
+		if m := reEnfIni.FindStringSubmatch(ln); m != nil {
+			enfID := m[1]
+			enf := dmap[enfID]
+			if enf == nil {
+				enf = &Disease{ID: enfID}
+				dmap[enfID] = enf
+			}
+			enf.Onset = m[2]
+			continue
+		}
+		if m := reEdadMin.FindStringSubmatch(ln); m != nil {
+			medID := m[1]
+			med := mmap[medID]
+			if med == nil {
+				med = &Medication{ID: medID}
+				mmap[medID] = med
+			}
+			med.MinAge, _ = strconv.Atoi(m[2])
+			continue
+		}
+		if m := reEmb.FindStringSubmatch(ln); m != nil {
+			medID := m[1]
+			med := mmap[medID]
+			if med == nil {
+				med = &Medication{ID: medID}
+				mmap[medID] = med
+			}
+			med.PregnancyContra = true
+			continue
+		}
+		if m := reInter.FindStringSubmatch(ln); m != nil {
+			medID := m[1]
+			med := mmap[medID]
+			if med == nil {
+				med = &Medication{ID: medID}
+				mmap[medID] = med
+			}
+			if med.Interactions == nil {
+				med.Interactions = map[string]string{}
+			}
+			med.Interactions[m[2]] = m[3]
+			continue
+		}
+		if m := rePos.FindStringSubmatch(ln); m != nil {
+			medID := m[1]
+			med := mmap[medID]
+			if med == nil {
+				med = &Medication{ID: medID}
+				mmap[medID] = med
+			}
+			var d Dosage
+			d.AgeMin, _ = strconv.Atoi(m[2])
+			d.AgeMax, _ = strconv.Atoi(m[3])
+			d.WeightMin, _ = strconv.ParseFloat(m[4], 64)
+			d.WeightMax, _ = strconv.ParseFloat(m[5], 64)
+			d.Dose, _ = strconv.ParseFloat(m[6], 64)
+			d.Unit, d.Route = m[7], m[8]
+			d.EveryHours, _ = strconv.Atoi(m[9])
+			d.MaxDaily, _ = strconv.ParseFloat(m[10], 64)
+			med.Dosages = append(med.Dosages, d)
+			continue
+		}
+		if m := reFlag.FindStringSubmatch(ln); m != nil {
+			rf := RedFlag{ID: m[1], Level: m[4]}
+			for _, x := range strings.Split(m[2], ",") {
+				if x = strings.TrimSpace(x); x != "" {
+					rf.Symptoms = append(rf.Symptoms, x)
+				}
+			}
+			rf.MinSeverity, _ = strconv.Atoi(m[3])
+			snap.RedFlags = append(snap.RedFlags, rf)
+			continue
+		}
 		if m := reMed.FindStringSubmatch(ln); m != nil {
 			id := m[1]
 			if _, ok := mmap[id]; !ok {
@@ -1107,6 +1895,21 @@ func loadSnapshotFromPL() (Snapshot, error) {
 			med.Treats = uniq(append(med.Treats, enfID))
 			continue
 		}
+		if m := reLinea.FindStringSubmatch(ln); m != nil {
+			medID := m[1]
+			med := mmap[medID]
+			if med == nil {
+				med = &Medication{ID: medID}
+				mmap[medID] = med
+			}
+			if med.TreatLines == nil {
+				med.TreatLines = map[string]TherapyLine{}
+			}
+			l, _ := strconv.Atoi(m[3])
+			r, _ := strconv.Atoi(m[4])
+			med.TreatLines[m[2]] = TherapyLine{Line: l, Rank: r}
+			continue
+		}
 		if m := reContra.FindStringSubmatch(ln); m != nil {
 			medID, cond := m[1], m[2]
 			med := mmap[medID]
@@ -1134,19 +1937,30 @@ func loadSnapshotFromPL() (Snapshot, error) {
 	sort.Slice(snap.Symptoms, func(i, j int) bool { return snap.Symptoms[i].ID < snap.Symptoms[j].ID })
 	sort.Slice(snap.Diseases, func(i, j int) bool { return snap.Diseases[i].ID < snap.Diseases[j].ID })
 	sort.Slice(snap.Medications, func(i, j int) bool { return snap.Medications[i].ID < snap.Medications[j].ID })
-	return snap, nil
+	sort.Slice(snap.RedFlags, func(i, j int) bool { return snap.RedFlags[i].ID < snap.RedFlags[j].ID })
+	return snap
 }
 
 func writePLFromSnapshot(s Snapshot) error {
+	kb, err := renderPLFromSnapshot(s)
+	if err != nil {
+		return err
+	}
+	return writeKBAtomic(kb)
+}
+
+// renderPLFromSnapshot valida el Snapshot y lo imprime como KB (un hecho por línea).
+func renderPLFromSnapshot(s Snapshot) ([]byte, error) {
 	// 1) Normalización + validación fuerte
 	if err := validateSnapshot(&s); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 2) Orden estable de impresión
 	sort.Slice(s.Symptoms, func(i, j int) bool { return s.Symptoms[i].ID < s.Symptoms[j].ID })
 	sort.Slice(s.Diseases, func(i, j int) bool { return s.Diseases[i].ID < s.Diseases[j].ID })
 	sort.Slice(s.Medications, func(i, j int) bool { return s.Medications[i].ID < s.Medications[j].ID })
+	sort.Slice(s.RedFlags, func(i, j int) bool { return s.RedFlags[i].ID < s.RedFlags[j].ID })
 
 	var b strings.Builder
 	bw := bufio.NewWriter(&b)
@@ -1158,6 +1972,45 @@ func writePLFromSnapshot(s Snapshot) error {
 	for _, x := range s.Symptoms {
 		fmt.Fprintf(bw, "sintoma(%s).\n", safeAtom(x.ID))
 	}
+	// 1b) sinonimo/2 (opcional)
+	for _, x := range s.Symptoms {
+		for _, syn := range x.Synonyms {
+			fmt.Fprintf(bw, "sinonimo(%s, \"%s\").\n", safeAtom(x.ID), escQuotes(syn))
+		}
+	}
+	// 1c) sintoma_padre/2 (opcional, jerarquía de síntomas)
+	for _, x := range s.Symptoms {
+		for _, par := range x.Parents {
+			fmt.Fprintf(bw, "sintoma_padre(%s, %s).\n", safeAtom(x.ID), safeAtom(par))
+		}
+	}
+	// 1d) texto_sintoma/4 (opcional, catálogo público; primero el idioma por defecto)
+	for _, x := range s.Symptoms {
+		if x.Label != "" || x.Description != "" {
+			fmt.Fprintf(bw, "texto_sintoma(%s, %s, \"%s\", \"%s\").\n", safeAtom(x.ID), defaultLang, escQuotes(x.Label), escQuotes(x.Description))
+		}
+		langs := make([]string, 0, len(x.I18n))
+		for l := range x.I18n {
+			langs = append(langs, l)
+		}
+		sort.Strings(langs)
+		for _, l := range langs {
+			t := x.I18n[l]
+			fmt.Fprintf(bw, "texto_sintoma(%s, %s, \"%s\", \"%s\").\n", safeAtom(x.ID), safeAtom(l), escQuotes(t.Label), escQuotes(t.Description))
+		}
+	}
+	// 1e) sistema_sintoma/2 (opcional)
+	for _, x := range s.Symptoms {
+		if x.System != "" {
+			fmt.Fprintf(bw, "sistema_sintoma(%s, %s).\n", safeAtom(x.ID), safeAtom(x.System))
+		}
+	}
+	// 1f) escala_sintoma/2 (opcional, severidades permitidas)
+	for _, x := range s.Symptoms {
+		if len(x.Scale) > 0 {
+			fmt.Fprintf(bw, "escala_sintoma(%s, %s).\n", safeAtom(x.ID), plAtomList(x.Scale))
+		}
+	}
 
 	// 2) enfermedad/4
 	fmt.Fprintln(bw, "")
@@ -1176,10 +2029,10 @@ func writePLFromSnapshot(s Snapshot) error {
 		fmt.Fprintf(bw, "descripcion_enf(%s, \"%s\").\n", safeAtom(d.ID), escQuotes(desc))
 	}
 
-	// 4) enf_sintoma/2
+	// 4) enf_sintoma/3 (peso 1 por defecto)
 	for _, d := range s.Diseases {
 		for _, sym := range d.Symptoms {
-			fmt.Fprintf(bw, "enf_sintoma(%s, %s).\n", safeAtom(d.ID), safeAtom(sym))
+			fmt.Fprintf(bw, "enf_sintoma(%s, %s, %d).\n", safeAtom(d.ID), safeAtom(sym), d.weight(sym))
 		}
 	}
 
@@ -1190,6 +2043,69 @@ func writePLFromSnapshot(s Snapshot) error {
 		}
 	}
 
+	// 5b) penalizacion_ausente/2 (opcional)
+	for _, d := range s.Diseases {
+		if d.AbsentPenalty > 0 {
+			fmt.Fprintf(bw, "penalizacion_ausente(%s, %d).\n", safeAtom(d.ID), d.AbsentPenalty)
+		}
+	}
+
+	// 5c) prevalencia/2 y sensibilidad/3 (opcionales, modo bayes)
+	for _, d := range s.Diseases {
+		if d.Prevalence > 0 {
+			fmt.Fprintf(bw, "prevalencia(%s, %s).\n", safeAtom(d.ID), plFloat(d.Prevalence))
+		}
+	}
+	for _, d := range s.Diseases {
+		for _, sym := range d.Symptoms {
+			if x, ok := d.Sensitivity[sym]; ok {
+				fmt.Fprintf(bw, "sensibilidad(%s, %s, %s).\n", safeAtom(d.ID), safeAtom(sym), plFloat(x))
+			}
+		}
+	}
+
+	// 5d) enf_bandera_roja/3 (opcional)
+	for _, d := range s.Diseases {
+		for _, sym := range d.Symptoms {
+			if sev, ok := d.RedFlags[sym]; ok {
+				fmt.Fprintf(bw, "enf_bandera_roja(%s, %s, %d).\n", safeAtom(d.ID), safeAtom(sym), sev)
+			}
+		}
+	}
+
+	// 5e) enf_edad/3 (opcional)
+	for _, d := range s.Diseases {
+		if d.AgeMin > 0 || d.AgeMax > 0 {
+			fmt.Fprintf(bw, "enf_edad(%s, %d, %d).\n", safeAtom(d.ID), d.AgeMin, d.ageMax())
+		}
+	}
+
+	// 5f) enf_duracion/3 y enf_inicio/2 (opcionales)
+	for _, d := range s.Diseases {
+		if d.DurationMax > 0 {
+			fmt.Fprintf(bw, "enf_duracion(%s, %d, %d).\n", safeAtom(d.ID), d.DurationMin, d.DurationMax)
+		}
+	}
+	for _, d := range s.Diseases {
+		if d.Onset != "" {
+			fmt.Fprintf(bw, "enf_inicio(%s, %s).\n", safeAtom(d.ID), d.Onset)
+		}
+	}
+
+	// 5g) enf_excluye/2 (opcional)
+	for _, d := range s.Diseases {
+		for _, sym := range d.Excludes {
+			fmt.Fprintf(bw, "enf_excluye(%s, %s).\n", safeAtom(d.ID), safeAtom(sym))
+		}
+	}
+
+	// 5h) enf_requerido/2 (opcional)
+	for _, d := range s.Diseases {
+		for _, sym := range d.Required {
+			fmt.Fprintf(bw, "enf_requerido(%s, %s).\n", safeAtom(d.ID), safeAtom(sym))
+		}
+	}
+
 	// 6) medicamento/1
 	fmt.Fprintln(bw, "")
 	for _, m := range s.Medications {
@@ -1203,6 +2119,19 @@ func writePLFromSnapshot(s Snapshot) error {
 		}
 	}
 
+	// 7b) linea_tratamiento/4 (opcional)
+	for _, m := range s.Medications {
+		dzs := make([]string, 0, len(m.TreatLines))
+		for dz := range m.TreatLines {
+			dzs = append(dzs, dz)
+		}
+		sort.Strings(dzs)
+		for _, dz := range dzs {
+			tl := m.TreatLines[dz]
+			fmt.Fprintf(bw, "linea_tratamiento(%s, %s, %d, %d).\n", safeAtom(m.ID), safeAtom(dz), tl.Line, tl.Rank)
+		}
+	}
+
 	// 8) contraindicado/2
 	for _, m := range s.Medications {
 		for _, c := range m.Contra {
@@ -1210,8 +2139,59 @@ func writePLFromSnapshot(s Snapshot) error {
 		}
 	}
 
+	// 8b) edad_minima/2 y contra_embarazo/1 (opcionales)
+	for _, m := range s.Medications {
+		if m.MinAge > 0 {
+			fmt.Fprintf(bw, "edad_minima(%s, %d).\n", safeAtom(m.ID), m.MinAge)
+		}
+	}
+	for _, m := range s.Medications {
+		if m.PregnancyContra {
+			fmt.Fprintf(bw, "contra_embarazo(%s).\n", safeAtom(m.ID))
+		}
+	}
+
+	// 8c) interaccion/3: un hecho por par (si ambos lo declaran, se escribe una vez)
+	seenPair := map[[2]string]struct{}{}
+	for _, m := range s.Medications {
+		others := make([]string, 0, len(m.Interactions))
+		for o := range m.Interactions {
+			others = append(others, o)
+		}
+		sort.Strings(others)
+		for _, o := range others {
+			a, b := m.ID, o
+			if b < a {
+				a, b = b, a
+			}
+			if _, ok := seenPair[[2]string{a, b}]; ok {
+				continue
+			}
+			seenPair[[2]string{a, b}] = struct{}{}
+			fmt.Fprintf(bw, "interaccion(%s, %s, %s).\n", safeAtom(m.ID), safeAtom(o), m.Interactions[o])
+		}
+	}
+
+	// 8d) posologia/10 (en el orden de las bandas)
+	for _, m := range s.Medications {
+		for _, d := range m.Dosages {
+			fmt.Fprintf(bw, "posologia(%s, %d, %d, %s, %s, %s, %s, %s, %d, %s).\n",
+				safeAtom(m.ID), d.AgeMin, d.AgeMax, plFloat(d.WeightMin), plFloat(d.WeightMax),
+				plFloat(d.Dose), d.Unit, d.Route, d.EveryHours, plFloat(d.MaxDaily))
+		}
+	}
+
+	// 9) bandera_roja/4 (urgencia/1 y urgencia/2 las evalúan en orden de nivel)
+	if len(s.RedFlags) > 0 {
+		fmt.Fprintln(bw, "")
+	}
+	for _, rf := range s.RedFlags {
+		fmt.Fprintf(bw, "bandera_roja(%s, %s, %d, \"%s\").\n",
+			rf.ID, plAtomList(rf.Symptoms), rf.MinSeverity, escQuotes(rf.Level))
+	}
+
 	bw.Flush()
-	return writeKBAtomic([]byte(b.String()))
+	return []byte(b.String()), nil
 }
 
 /* ===========================================================
@@ -1247,14 +2227,244 @@ func uniq(ss []string) []string {
 	return out
 }
 
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// weight devuelve el peso del vínculo enfermedad-síntoma (1 si no está ponderado).
+func (d Disease) weight(sym string) int {
+	if w, ok := d.Weights[sym]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// ageMax: tope de enf_edad/3 (sin máximo declarado = 130).
+func (d Disease) ageMax() int {
+	if d.AgeMax > 0 {
+		return d.AgeMax
+	}
+	return 130
+}
+
+// plFloat imprime un número que Prolog lea siempre como float (0.05, 1.0).
+func plFloat(x float64) string {
+	t := strconv.FormatFloat(x, 'f', -1, 64)
+	if !strings.ContainsAny(t, ".eE") {
+		t += ".0"
+	}
+	return t
+}
+
+// plAtomList arma una lista Prolog de átomos: [a,b] (vacía = []).
+func plAtomList(ss []string) string {
+	out := make([]string, 0, len(ss))
+	for _, x := range ss {
+		if strings.TrimSpace(x) != "" {
+			out = append(out, safeAtom(x))
+		}
+	}
+	return "[" + strings.Join(out, ",") + "]"
+}
+
 func escQuotes(s string) string {
 	return strings.ReplaceAll(s, `"`, `\"`)
 }
 
+// validateSymptomText normaliza etiqueta, descripción, sistema, escala y traducciones de x.
+func validateSymptomText(x *Symptom) error {
+	checkText := func(lang string, t *SymptomText) error {
+		t.Label = strings.Join(strings.Fields(t.Label), " ")
+		t.Description = strings.TrimSpace(t.Description)
+		if strings.ContainsAny(t.Label+t.Description, "\"\\\n") {
+			return fmt.Errorf("síntoma %s: texto en '%s' sin comillas, barras ni saltos de línea", x.ID, lang)
+		}
+		if len(t.Label) > 80 || len(t.Description) > 300 {
+			return fmt.Errorf("síntoma %s: texto en '%s' demasiado largo (etiqueta máx. 80, descripción máx. 300)", x.ID, lang)
+		}
+		return nil
+	}
+	def := SymptomText{Label: x.Label, Description: x.Description}
+	if err := checkText(defaultLang, &def); err != nil {
+		return err
+	}
+	x.Label, x.Description = def.Label, def.Description
+	if len(x.I18n) > 0 {
+		tr := make(map[string]SymptomText, len(x.I18n))
+		for l, t := range x.I18n {
+			l = strings.ToLower(strings.TrimSpace(l))
+			if !reLang.MatchString(l) {
+				return fmt.Errorf("síntoma %s: idioma '%s' inválido (código de 2 letras, ej. en)", x.ID, l)
+			}
+			if l == defaultLang {
+				return fmt.Errorf("síntoma %s: el texto en '%s' va en label/description", x.ID, defaultLang)
+			}
+			if err := checkText(l, &t); err != nil {
+				return err
+			}
+			if t.Label == "" {
+				return fmt.Errorf("síntoma %s: etiqueta requerida en '%s'", x.ID, l)
+			}
+			tr[l] = t
+		}
+		x.I18n = tr
+	}
+	if strings.TrimSpace(x.System) != "" {
+		x.System = safeAtom(x.System)
+	} else {
+		x.System = ""
+	}
+	// escala en orden canónico; la escala completa equivale a no declararla
+	var scale []string
+	for _, lvl := range severityScale {
+		for _, v := range x.Scale {
+			if strings.ToLower(strings.TrimSpace(v)) == lvl {
+				scale = append(scale, lvl)
+				break
+			}
+		}
+	}
+	for _, v := range x.Scale {
+		if !contains(severityScale, strings.ToLower(strings.TrimSpace(v))) {
+			return fmt.Errorf("síntoma %s: escala '%s' inválida (leve | moderado | severo)", x.ID, v)
+		}
+	}
+	if len(scale) == len(severityScale) {
+		scale = nil
+	}
+	x.Scale = scale
+	return nil
+}
+
+// validateExcludes: los hallazgos excluyentes existen y no son síntomas de la enfermedad
+// ni ancestros de uno (su descendiente presente la descartaría a la vez que la apoya).
+func validateExcludes(d *Disease, syms []Symptom, symSet map[string]struct{}) error {
+	if len(d.Excludes) == 0 {
+		return nil
+	}
+	parents := map[string][]string{}
+	for _, x := range syms {
+		parents[x.ID] = x.Parents
+	}
+	for _, sid := range d.Excludes {
+		if _, ok := symSet[sid]; !ok {
+			return fmt.Errorf("enfermedad %s: síntoma excluyente '%s' no existe", d.ID, sid)
+		}
+	}
+	for _, sym := range d.Symptoms {
+		// validateSymptomTree ya descartó ciclos
+		for level := []string{sym}; len(level) > 0; {
+			var next []string
+			for _, a := range level {
+				if contains(d.Excludes, a) {
+					if a == sym {
+						return fmt.Errorf("enfermedad %s: '%s' no puede ser síntoma y excluyente a la vez", d.ID, a)
+					}
+					return fmt.Errorf("enfermedad %s: '%s' es excluyente pero su descendiente '%s' es síntoma de la enfermedad", d.ID, a, sym)
+				}
+				next = append(next, parents[a]...)
+			}
+			level = next
+		}
+	}
+	return nil
+}
+
+// validateSymptomTree: cada padre existe, sintoma_padre/2 no forma ciclos y ninguna
+// cadena supera maxDepth niveles (tope_jerarquia/1; 0 = sin tope).
+func validateSymptomTree(syms []Symptom, symSet map[string]struct{}, maxDepth int) error {
+	parents := map[string][]string{}
+	for _, x := range syms {
+		for _, par := range x.Parents {
+			if par == x.ID {
+				return fmt.Errorf("síntoma %s: no puede ser su propio padre", x.ID)
+			}
+			if _, ok := symSet[par]; !ok {
+				return fmt.Errorf("síntoma %s: padre '%s' no existe", x.ID, par)
+			}
+		}
+		parents[x.ID] = append(parents[x.ID], x.Parents...)
+	}
+	// DFS: 1 = en el camino actual, 2 = ya revisado (depth = niveles de ancestros)
+	state := map[string]int{}
+	depth := map[string]int{}
+	var path []string
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case 1:
+			for i, p := range path {
+				if p == id {
+					return fmt.Errorf("jerarquía de síntomas con ciclo: %s", strings.Join(append(path[i:], id), " -> "))
+				}
+			}
+		case 2:
+			return nil
+		}
+		state[id] = 1
+		path = append(path, id)
+		for _, par := range parents[id] {
+			if err := visit(par); err != nil {
+				return err
+			}
+			depth[id] = max(depth[id], depth[par]+1)
+		}
+		if maxDepth > 0 && depth[id] > maxDepth {
+			return fmt.Errorf("síntoma %s: jerarquía de %d niveles (máximo %d)", id, depth[id], maxDepth)
+		}
+		path = path[:len(path)-1]
+		state[id] = 2
+		return nil
+	}
+	for _, x := range syms {
+		if err := visit(x.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func validateSnapshot(s *Snapshot) error {
 	// normalizar IDs/contenido
+	synOwner := map[string]string{} // sinónimo normalizado -> síntoma
 	for i := range s.Symptoms {
-		s.Symptoms[i].ID = safeAtom(s.Symptoms[i].ID)
+		x := &s.Symptoms[i]
+		x.ID = safeAtom(x.ID)
+		syns := []string{}
+		for _, syn := range x.Synonyms {
+			syn = strings.Join(strings.Fields(syn), " ")
+			if syn == "" {
+				continue
+			}
+			if strings.ContainsAny(syn, "\"\\") || len(syn) > 80 {
+				return fmt.Errorf("síntoma %s: sinónimo '%s' inválido (sin comillas, máx. 80 caracteres)", x.ID, syn)
+			}
+			key := foldText(syn)
+			if owner, ok := synOwner[key]; ok {
+				if owner == x.ID {
+					continue
+				}
+				return fmt.Errorf("sinónimo '%s' repetido en %s y %s", syn, owner, x.ID)
+			}
+			synOwner[key] = x.ID
+			syns = append(syns, syn)
+		}
+		x.Synonyms = syns
+		pars := []string{}
+		for _, par := range x.Parents {
+			if strings.TrimSpace(par) != "" {
+				pars = append(pars, safeAtom(par))
+			}
+		}
+		x.Parents = uniq(pars)
+		if err := validateSymptomText(x); err != nil {
+			return err
+		}
 	}
 	for i := range s.Diseases {
 		d := &s.Diseases[i]
@@ -1267,8 +2477,43 @@ func validateSnapshot(s *Snapshot) error {
 		for j := range d.ContraMeds {
 			d.ContraMeds[j] = safeAtom(d.ContraMeds[j])
 		}
+		ex := []string{}
+		for _, sid := range d.Excludes {
+			if strings.TrimSpace(sid) != "" {
+				ex = append(ex, safeAtom(sid))
+			}
+		}
 		d.Symptoms = uniq(d.Symptoms)
 		d.ContraMeds = uniq(d.ContraMeds)
+		d.Excludes = uniq(ex)
+		req := []string{}
+		for _, sid := range d.Required {
+			if strings.TrimSpace(sid) != "" {
+				req = append(req, safeAtom(sid))
+			}
+		}
+		d.Required = uniq(req)
+		if len(d.Weights) > 0 {
+			ws := make(map[string]int, len(d.Weights))
+			for k, v := range d.Weights {
+				ws[safeAtom(k)] = v
+			}
+			d.Weights = ws
+		}
+		if len(d.Sensitivity) > 0 {
+			ss := make(map[string]float64, len(d.Sensitivity))
+			for k, v := range d.Sensitivity {
+				ss[safeAtom(k)] = v
+			}
+			d.Sensitivity = ss
+		}
+		if len(d.RedFlags) > 0 {
+			rf := make(map[string]int, len(d.RedFlags))
+			for k, v := range d.RedFlags {
+				rf[safeAtom(k)] = v
+			}
+			d.RedFlags = rf
+		}
 	}
 	for i := range s.Medications {
 		m := &s.Medications[i]
@@ -1291,6 +2536,9 @@ func validateSnapshot(s *Snapshot) error {
 		}
 		symSet[x.ID] = struct{}{}
 	}
+	if err := validateSymptomTree(s.Symptoms, symSet, engines.current().consts.hierarchyCap); err != nil {
+		return err
+	}
 
 	disMap := map[string]*Disease{}
 	for i := range s.Diseases {
@@ -1304,12 +2552,64 @@ func validateSnapshot(s *Snapshot) error {
 		if d.System == "" || d.Type == "" {
 			return fmt.Errorf("enfermedad %s: system y type son requeridos", d.ID)
 		}
+		if d.AbsentPenalty < 0 || d.AbsentPenalty > 100 {
+			return fmt.Errorf("enfermedad %s: absent_penalty debe estar entre 0 y 100", d.ID)
+		}
 		disMap[d.ID] = d
 		for _, sid := range d.Symptoms {
 			if _, ok := symSet[sid]; !ok {
 				return fmt.Errorf("enfermedad %s: síntoma '%s' no existe", d.ID, sid)
 			}
 		}
+		if err := validateExcludes(d, s.Symptoms, symSet); err != nil {
+			return err
+		}
+		for _, sid := range d.Required {
+			if !contains(d.Symptoms, sid) {
+				return fmt.Errorf("enfermedad %s: síntoma requerido '%s', que no es síntoma de la enfermedad", d.ID, sid)
+			}
+		}
+		for sid, wgt := range d.Weights {
+			if !contains(d.Symptoms, sid) {
+				return fmt.Errorf("enfermedad %s: peso para '%s', que no es síntoma de la enfermedad", d.ID, sid)
+			}
+			if wgt < 1 || wgt > 5 {
+				return fmt.Errorf("enfermedad %s: peso de '%s' debe estar entre 1 y 5", d.ID, sid)
+			}
+		}
+		if d.Prevalence < 0 || d.Prevalence > 1 {
+			return fmt.Errorf("enfermedad %s: prevalence debe estar entre 0 y 1", d.ID)
+		}
+		for sid, x := range d.Sensitivity {
+			if !contains(d.Symptoms, sid) {
+				return fmt.Errorf("enfermedad %s: sensibilidad para '%s', que no es síntoma de la enfermedad", d.ID, sid)
+			}
+			if x <= 0 || x >= 1 {
+				return fmt.Errorf("enfermedad %s: sensibilidad de '%s' debe estar en (0, 1)", d.ID, sid)
+			}
+		}
+		if d.AgeMin < 0 || d.AgeMax < 0 || d.AgeMin > 130 || d.AgeMax > 130 {
+			return fmt.Errorf("enfermedad %s: age_min/age_max deben estar entre 0 y 130", d.ID)
+		}
+		if d.AgeMax > 0 && d.AgeMin > d.AgeMax {
+			return fmt.Errorf("enfermedad %s: age_min no puede ser mayor que age_max", d.ID)
+		}
+		if d.DurationMin < 0 || d.DurationMax < 0 || (d.DurationMin > 0 && d.DurationMax == 0) || d.DurationMin > d.DurationMax {
+			return fmt.Errorf("enfermedad %s: duration_min/duration_max inválidos (0 <= min <= max, max requerido)", d.ID)
+		}
+		onset, ok := normOnset(d.Onset)
+		if !ok {
+			return fmt.Errorf("enfermedad %s: onset inválido (subito|gradual)", d.ID)
+		}
+		d.Onset = onset
+		for sid, sev := range d.RedFlags {
+			if !contains(d.Symptoms, sid) {
+				return fmt.Errorf("enfermedad %s: bandera roja '%s', que no es síntoma de la enfermedad", d.ID, sid)
+			}
+			if sev < 1 || sev > 3 {
+				return fmt.Errorf("enfermedad %s: severidad mínima de la bandera roja '%s' debe estar entre 1 y 3", d.ID, sid)
+			}
+		}
 	}
 
 	medMap := map[string]*Medication{}
@@ -1318,6 +2618,66 @@ func validateSnapshot(s *Snapshot) error {
 		if m.ID == "" {
 			return fmt.Errorf("medicamento con ID vacío")
 		}
+		if m.MinAge < 0 || m.MinAge > 130 {
+			return fmt.Errorf("medicamento %s: min_age debe estar entre 0 y 130", m.ID)
+		}
+		if len(m.TreatLines) > 0 {
+			tl := make(map[string]TherapyLine, len(m.TreatLines))
+			for k, v := range m.TreatLines {
+				k = safeAtom(k)
+				if !contains(m.Treats, k) {
+					return fmt.Errorf("medicamento %s: línea de tratamiento para '%s', que no está en treats", m.ID, k)
+				}
+				if v.Line < 1 || v.Line > 5 {
+					return fmt.Errorf("medicamento %s: línea de tratamiento de '%s' debe estar entre 1 y 5", m.ID, k)
+				}
+				if v.Rank < 0 || v.Rank > 99 {
+					return fmt.Errorf("medicamento %s: rank de '%s' debe estar entre 1 y 99 (0 u omitido = 1)", m.ID, k)
+				}
+				if v.Rank == 0 { // sin rank explícito: el preferido de su línea
+					v.Rank = 1
+				}
+				tl[k] = v
+			}
+			m.TreatLines = tl
+		}
+		if len(m.Interactions) > 0 {
+			in := make(map[string]string, len(m.Interactions))
+			for k, v := range m.Interactions {
+				k, v = safeAtom(k), strings.ToLower(strings.TrimSpace(v))
+				if k == m.ID {
+					return fmt.Errorf("medicamento %s: no puede interactuar consigo mismo", m.ID)
+				}
+				if v != "leve" && v != "moderada" && v != "grave" {
+					return fmt.Errorf("medicamento %s: severidad de interacción con '%s' debe ser leve, moderada o grave", m.ID, k)
+				}
+				in[k] = v
+			}
+			m.Interactions = in
+		}
+		for j := range m.Dosages {
+			d := &m.Dosages[j]
+			if strings.TrimSpace(d.Route) == "" {
+				d.Route = "oral"
+			}
+			d.Unit = safeAtom(d.Unit)
+			d.Route = safeAtom(d.Route)
+			if d.Unit != "mg" && d.Unit != "mg_kg" {
+				return fmt.Errorf("medicamento %s: posología %d: unit debe ser mg o mg_kg", m.ID, j+1)
+			}
+			if d.Dose <= 0 || d.MaxDaily <= 0 {
+				return fmt.Errorf("medicamento %s: posología %d: dose y max_daily deben ser mayores que 0", m.ID, j+1)
+			}
+			if d.EveryHours < 1 || d.EveryHours > 48 {
+				return fmt.Errorf("medicamento %s: posología %d: every_hours debe estar entre 1 y 48", m.ID, j+1)
+			}
+			if d.AgeMin < 0 || d.AgeMax < 0 || d.AgeMax > 130 || (d.AgeMax > 0 && d.AgeMin > d.AgeMax) {
+				return fmt.Errorf("medicamento %s: posología %d: rango de edad inválido", m.ID, j+1)
+			}
+			if d.WeightMin < 0 || d.WeightMax < 0 || (d.WeightMax > 0 && d.WeightMin > d.WeightMax) {
+				return fmt.Errorf("medicamento %s: posología %d: rango de peso inválido", m.ID, j+1)
+			}
+		}
 		medMap[m.ID] = m
 	}
 
@@ -1336,6 +2696,57 @@ func validateSnapshot(s *Snapshot) error {
 		}
 	}
 
+	// interacciones: el otro medicamento debe existir y, si la declara, con la misma severidad
+	for _, m := range s.Medications {
+		others := make([]string, 0, len(m.Interactions))
+		for o := range m.Interactions {
+			others = append(others, o)
+		}
+		sort.Strings(others)
+		for _, o := range others {
+			om, ok := medMap[o]
+			if !ok {
+				return fmt.Errorf("interaccion(%s,%s): medicamento no existe", m.ID, o)
+			}
+			if sev, sev2 := m.Interactions[o], om.Interactions[m.ID]; sev2 != "" && sev2 != sev {
+				return fmt.Errorf("interaccion(%s,%s): severidad distinta en cada medicamento (%s / %s)", m.ID, o, sev, sev2)
+			}
+		}
+	}
+
+	// banderas rojas: síntomas existentes, severidad 1..3 y nivel conocido
+	flagSet := map[string]struct{}{}
+	for i := range s.RedFlags {
+		rf := &s.RedFlags[i]
+		if strings.TrimSpace(rf.ID) == "" {
+			return fmt.Errorf("bandera roja con ID vacío")
+		}
+		rf.ID = safeAtom(rf.ID)
+		if _, dup := flagSet[rf.ID]; dup {
+			return fmt.Errorf("bandera roja %s duplicada", rf.ID)
+		}
+		flagSet[rf.ID] = struct{}{}
+		for j := range rf.Symptoms {
+			rf.Symptoms[j] = safeAtom(rf.Symptoms[j])
+		}
+		rf.Symptoms = uniq(rf.Symptoms)
+		if len(rf.Symptoms) == 0 {
+			return fmt.Errorf("bandera roja %s: requiere al menos un síntoma", rf.ID)
+		}
+		for _, sid := range rf.Symptoms {
+			if _, ok := symSet[sid]; !ok {
+				return fmt.Errorf("bandera roja %s: síntoma '%s' no existe", rf.ID, sid)
+			}
+		}
+		if rf.MinSeverity < 1 || rf.MinSeverity > 3 {
+			return fmt.Errorf("bandera roja %s: min_severity debe estar entre 1 y 3", rf.ID)
+		}
+		rf.Level = strings.TrimSpace(rf.Level)
+		if urgencyRank(rf.Level) == 0 {
+			return fmt.Errorf("bandera roja %s: nivel '%s' desconocido", rf.ID, rf.Level)
+		}
+	}
+
 	return nil
 }
 
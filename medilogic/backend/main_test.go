@@ -0,0 +1,868 @@
+//go:build !rpa
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// snapshotCase: una edición sobre fixtureRespiratorio() y el error esperado ("" = válida).
+type snapshotCase struct {
+	name    string
+	edit    func(s *Snapshot)
+	wantErr string
+}
+
+func runSnapshotCases(t *testing.T, tests []snapshotCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := fixtureRespiratorio()
+			tt.edit(&snap)
+			err := validateSnapshot(&snap)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("error inesperado: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// snapshotEngine compila un snapshot como lo haría POST /admin/kb/snapshot.
+func snapshotEngine(t *testing.T, snap Snapshot) *kbEngine {
+	t.Helper()
+	kb, err := renderPLFromSnapshot(snap)
+	if err != nil {
+		t.Fatalf("snapshot inválido: %v", err)
+	}
+	eng := newKBEngine(1, kb)
+	if eng.err != nil {
+		t.Fatalf("la KB no compila: %v", eng.err)
+	}
+	return eng
+}
+
+func disease(s *Snapshot, id string) *Disease {
+	for i := range s.Diseases {
+		if s.Diseases[i].ID == id {
+			return &s.Diseases[i]
+		}
+	}
+	return nil
+}
+
+func TestValidateSnapshotWeights(t *testing.T) {
+	runSnapshotCases(t, []snapshotCase{
+		{"fixture válida", func(s *Snapshot) {}, ""},
+		{"peso máximo", func(s *Snapshot) { disease(s, "gripe").Weights["tos"] = 5 }, ""},
+		{"peso cero", func(s *Snapshot) { disease(s, "gripe").Weights["tos"] = 0 }, "peso de 'tos' debe estar entre 1 y 5"},
+		{"peso excesivo", func(s *Snapshot) { disease(s, "gripe").Weights["tos"] = 6 }, "peso de 'tos' debe estar entre 1 y 5"},
+		{"peso de síntoma ajeno", func(s *Snapshot) { disease(s, "gripe").Weights["disnea"] = 2 }, "peso para 'disnea'"},
+		{"id sin normalizar", func(s *Snapshot) { disease(s, "gripe").Weights["Tos "] = 2 }, ""},
+	})
+}
+
+// afinidad = Σ peso(S) x severidad / (3 x Σ pesos), en ambos motores.
+func TestWeightedAffinity(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	tests := []struct {
+		name     string
+		symptoms []SymptomEntry
+		disease  string
+		want     int
+	}{
+		{"síntoma de peso 3", []SymptomEntry{sym("fiebre", "severo")}, "Gripe", 43},                           // 9/21
+		{"síntoma de peso 1", []SymptomEntry{sym("fiebre", "severo"), sym("cefalea", "severo")}, "Gripe", 57}, // 12/21
+		{"peso por omisión", []SymptomEntry{sym("rinorrea", "severo"), sym("tos", "severo")}, "Resfriado", 50},
+		{"vínculo pesado", []SymptomEntry{sym("fiebre", "leve"), sym("disnea", "severo")}, "Neumonía", 62}, // (1+12)/21
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms}) {
+				dg := findDiagnosis(resp, tt.disease)
+				if dg == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				if dg.Affinity != tt.want {
+					t.Errorf("%s: afinidad %d, want %d", name, dg.Affinity, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotBayes(t *testing.T) {
+	runSnapshotCases(t, []snapshotCase{
+		{"prevalencia 1", func(s *Snapshot) { disease(s, "gripe").Prevalence = 1 }, ""},
+		{"prevalencia negativa", func(s *Snapshot) { disease(s, "gripe").Prevalence = -0.1 }, "prevalence debe estar entre 0 y 1"},
+		{"prevalencia mayor a 1", func(s *Snapshot) { disease(s, "gripe").Prevalence = 1.5 }, "prevalence debe estar entre 0 y 1"},
+		{"sensibilidad 0", func(s *Snapshot) { disease(s, "gripe").Sensitivity["tos"] = 0 }, "sensibilidad de 'tos' debe estar en (0, 1)"},
+		{"sensibilidad 1", func(s *Snapshot) { disease(s, "gripe").Sensitivity["tos"] = 1 }, "sensibilidad de 'tos' debe estar en (0, 1)"},
+		{"sensibilidad de síntoma ajeno", func(s *Snapshot) { disease(s, "gripe").Sensitivity["disnea"] = 0.5 }, "sensibilidad para 'disnea'"},
+	})
+}
+
+func TestOptionsFromQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		body    DiagnoseOptions
+		want    DiagnoseOptions
+		wantErr string
+	}{
+		{"sin query", "", DiagnoseOptions{Limit: 3}, DiagnoseOptions{Limit: 3}, ""},
+		{"todas", "?min_affinity=20&limit=2&system=respiratorio,otorrino&type=viral&skip_zero=1", DiagnoseOptions{},
+			DiagnoseOptions{MinAffinity: 20, Limit: 2, Systems: []string{"respiratorio", "otorrino"}, Types: []string{"viral"}, SkipZero: true}, ""},
+		{"la query pisa el body", "?limit=5&skip_zero=false", DiagnoseOptions{Limit: 1, SkipZero: true}, DiagnoseOptions{Limit: 5}, ""},
+		{"min_affinity no numérico", "?min_affinity=alta", DiagnoseOptions{}, DiagnoseOptions{}, "min_affinity inválido"},
+		{"min_affinity fuera de rango", "?min_affinity=101", DiagnoseOptions{}, DiagnoseOptions{}, "entre 0 y 100"},
+		{"limit negativo en el body", "", DiagnoseOptions{Limit: -1}, DiagnoseOptions{}, "limit no puede ser negativo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.body
+			err := optionsFromQuery(httptest.NewRequest("POST", "/api/diagnose"+tt.query, nil), &o)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(o, tt.want) {
+				t.Errorf("opciones = %+v (%v), want %+v", o, err, tt.want)
+			}
+		})
+	}
+}
+
+// Las opciones filtran dentro del motor y se aplican igual en ambos.
+func TestDiagnoseOptions(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	rinoTos := []SymptomEntry{sym("rinorrea", "moderado"), sym("tos", "leve")}
+	tests := []struct {
+		name     string
+		symptoms []SymptomEntry
+		opt      DiagnoseOptions
+		want     []string
+	}{
+		{"sin opciones", rinoTos, DiagnoseOptions{}, []string{"Resfriado", "Gripe", "Asma", "Neumonía"}},
+		{"min_affinity", rinoTos, DiagnoseOptions{MinAffinity: 10}, []string{"Resfriado", "Gripe"}},
+		{"limit", rinoTos, DiagnoseOptions{Limit: 1}, []string{"Resfriado"}},
+		{"tipo", rinoTos, DiagnoseOptions{Types: []string{"viral"}}, []string{"Resfriado", "Gripe"}},
+		{"sistema y tipo", rinoTos, DiagnoseOptions{Systems: []string{"respiratorio"}, Types: []string{"cronico", "bacteriano"}}, []string{"Asma", "Neumonía"}},
+		{"skip_zero", []SymptomEntry{sym("rinorrea", "moderado")}, DiagnoseOptions{SkipZero: true}, []string{"Resfriado", "Gripe"}},
+		{"sin skip_zero", []SymptomEntry{sym("rinorrea", "moderado")}, DiagnoseOptions{}, []string{"Resfriado", "Gripe", "Asma", "Neumonía"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms, Options: tt.opt}) {
+				var got []string
+				for _, dg := range resp.Diagnoses {
+					got = append(got, dg.Disease)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: %v, want %v", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotRedFlags(t *testing.T) {
+	flag := func(s *Snapshot) *RedFlag { return &s.RedFlags[0] }
+	runSnapshotCases(t, []snapshotCase{
+		{"sin banderas", func(s *Snapshot) { s.RedFlags = nil }, ""},
+		{"id vacío", func(s *Snapshot) { flag(s).ID = " " }, "bandera roja con ID vacío"},
+		{"duplicada", func(s *Snapshot) { s.RedFlags[1].ID = "Dolor Pecho Agudo" }, "bandera roja dolor_pecho_agudo duplicada"},
+		{"sin síntomas", func(s *Snapshot) { flag(s).Symptoms = nil }, "requiere al menos un síntoma"},
+		{"síntoma inexistente", func(s *Snapshot) { flag(s).Symptoms = []string{"vertigo"} }, "síntoma 'vertigo' no existe"},
+		{"severidad fuera de rango", func(s *Snapshot) { flag(s).MinSeverity = 4 }, "min_severity debe estar entre 1 y 3"},
+		{"nivel desconocido", func(s *Snapshot) { flag(s).Level = "Urgente" }, "nivel 'Urgente' desconocido"},
+		{"nivel con espacios", func(s *Snapshot) { flag(s).Level = " Atención prioritaria " }, ""},
+	})
+}
+
+// Solo se evalúan las bandera_roja/4 de la KB; una combinación exige todos sus síntomas.
+func TestRedFlagsFromKB(t *testing.T) {
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	noFlags := fixtureRespiratorio()
+	noFlags.RedFlags = nil
+	tests := []struct {
+		name     string
+		snap     Snapshot
+		symptoms []SymptomEntry
+		want     Triage
+	}{
+		{"combinación completa", fixtureRespiratorio(), []SymptomEntry{sym("disnea", "moderado"), sym("fiebre", "moderado")},
+			Triage{Level: "Consulta recomendada", Rule: "bandera_roja", Symptom: "disnea_febril"}},
+		{"combinación incompleta", fixtureRespiratorio(), []SymptomEntry{sym("disnea", "moderado"), sym("fiebre", "leve")},
+			Triage{Level: "Observación recomendada", Rule: "caso_base"}},
+		{"síntoma sin bandera en la KB", fixtureRespiratorio(), []SymptomEntry{sym("dolor_pecho", "leve")},
+			Triage{Level: "Observación recomendada", Rule: "caso_base"}},
+		{"sin banderas en la KB", noFlags, []SymptomEntry{sym("dolor_pecho", "leve"), sym("disnea", "moderado")},
+			Triage{Level: "Observación recomendada", Rule: "caso_base"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := snapshotEngine(t, tt.snap)
+			// solo urgencia/1: sin enfermedades que eleven el triage
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms, Options: DiagnoseOptions{Systems: []string{"ninguno"}}}) {
+				if resp.Triage != tt.want {
+					t.Errorf("%s: triage %+v, want %+v", name, resp.Triage, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDemographics(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     DiagnoseReq
+		wantSex string
+		wantErr string
+	}{
+		{"sin datos", DiagnoseReq{}, "", ""},
+		{"sexo en palabras", DiagnoseReq{Sex: " Mujer "}, "f", ""},
+		{"masculino", DiagnoseReq{Sex: "masculino", Age: intp(0)}, "m", ""},
+		{"sexo desconocido", DiagnoseReq{Sex: "x"}, "", "sex inválido"},
+		{"edad negativa", DiagnoseReq{Age: intp(-1)}, "", "age debe estar entre 0 y 130"},
+		{"edad excesiva", DiagnoseReq{Age: intp(131)}, "", "age debe estar entre 0 y 130"},
+		{"peso excesivo", DiagnoseReq{WeightKg: 501}, "", "weight_kg debe estar entre 0 y 500"},
+		{"embarazo sin sexo", DiagnoseReq{Pregnant: true}, "", ""},
+		{"embarazo con sexo m", DiagnoseReq{Sex: "hombre", Pregnant: true}, "", "pregnant no aplica con sex=m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := validateDemographics(&req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || req.Sex != tt.wantSex {
+				t.Errorf("sex = %q (%v), want %q", req.Sex, err, tt.wantSex)
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotAges(t *testing.T) {
+	runSnapshotCases(t, []snapshotCase{
+		{"solo edad mínima", func(s *Snapshot) { d := disease(s, "asma"); d.AgeMin, d.AgeMax = 5, 0 }, ""},
+		{"mínimo mayor que máximo", func(s *Snapshot) { d := disease(s, "asma"); d.AgeMin, d.AgeMax = 50, 40 }, "age_min no puede ser mayor que age_max"},
+		{"edad de enfermedad fuera de rango", func(s *Snapshot) { disease(s, "asma").AgeMax = 131 }, "age_min/age_max deben estar entre 0 y 130"},
+		{"edad mínima de medicamento", func(s *Snapshot) { s.Medications[0].MinAge = -1 }, "min_age debe estar entre 0 y 130"},
+	})
+}
+
+// Edad y embarazo bloquean medicamentos; la edad fuera de enf_edad/3 solo advierte.
+func TestDemographicsInDiagnosis(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	gripe := []SymptomEntry{{ID: "fiebre", Severity: "severo", Present: true}, {ID: "tos", Severity: "moderado", Present: true}}
+	tests := []struct {
+		name    string
+		req     DiagnoseReq
+		disease string
+		blocked []string // medicamentos descartados (motivo:condición)
+		warning string
+	}{
+		{"adulto", DiagnoseReq{Age: intp(30), Symptoms: gripe}, "Gripe", nil, ""},
+		{"embarazo", DiagnoseReq{Age: intp(30), Sex: "f", Pregnant: true, Symptoms: gripe}, "Gripe", []string{"ibuprofeno:embarazo"}, ""},
+		{"lactante", DiagnoseReq{Age: intp(0), Symptoms: gripe}, "Gripe", []string{"ibuprofeno:1"}, ""},
+		{"fuera de rango típico", DiagnoseReq{Age: intp(60), Symptoms: []SymptomEntry{{ID: "sibilancias", Severity: "severo", Present: true}}},
+			"Asma", nil, "edad fuera del rango típico de Asma (5-40 años)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, tt.req) {
+				dg := findDiagnosis(resp, tt.disease)
+				if dg == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				var blocked []string
+				for _, b := range dg.BlockedDrugs {
+					blocked = append(blocked, b.Drug+":"+b.Condition)
+				}
+				if !reflect.DeepEqual(blocked, tt.blocked) {
+					t.Errorf("%s: bloqueados %v, want %v", name, blocked, tt.blocked)
+				}
+				if tt.warning != "" && !contains(dg.Warnings, tt.warning) {
+					t.Errorf("%s: advertencias %v, falta %q", name, dg.Warnings, tt.warning)
+				}
+			}
+		})
+	}
+}
+
+func TestSymptomTiming(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   SymptomEntry
+		want    string
+		wantErr string
+	}{
+		{"sin inicio", SymptomEntry{ID: "tos"}, "", ""},
+		{"súbito con tilde", SymptomEntry{ID: "tos", Onset: "Súbito"}, "subito", ""},
+		{"agudo", SymptomEntry{ID: "tos", Onset: "agudo"}, "subito", ""},
+		{"progresivo", SymptomEntry{ID: "tos", Onset: " progresivo"}, "gradual", ""},
+		{"inicio desconocido", SymptomEntry{ID: "tos", Onset: "ayer"}, "", "síntoma tos: onset inválido"},
+		{"duración negativa", SymptomEntry{ID: "tos", DurationDays: -1}, "", "síntoma tos: duration_days fuera de rango"},
+		{"duración excesiva", SymptomEntry{ID: "tos", DurationDays: 36501}, "", "síntoma tos: duration_days fuera de rango"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := DiagnoseReq{Symptoms: []SymptomEntry{tt.entry}}
+			err := validateDemographics(&req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || req.Symptoms[0].Onset != tt.want {
+				t.Errorf("onset = %q (%v), want %q", req.Symptoms[0].Onset, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotTiming(t *testing.T) {
+	runSnapshotCases(t, []snapshotCase{
+		{"sin duración", func(s *Snapshot) { d := disease(s, "gripe"); d.DurationMin, d.DurationMax = 0, 0 }, ""},
+		{"duración sin máximo", func(s *Snapshot) { d := disease(s, "gripe"); d.DurationMin, d.DurationMax = 2, 0 }, "duration_min/duration_max inválidos"},
+		{"mínimo mayor que máximo", func(s *Snapshot) { d := disease(s, "gripe"); d.DurationMin, d.DurationMax = 8, 7 }, "duration_min/duration_max inválidos"},
+		{"inicio normalizado", func(s *Snapshot) { disease(s, "gripe").Onset = "agudo" }, ""},
+		{"inicio desconocido", func(s *Snapshot) { disease(s, "gripe").Onset = "lento" }, "onset inválido"},
+	})
+}
+
+// Cada síntoma con duración/inicio suma bono_temporal/1 si encaja con la enfermedad o resta castigo_temporal/1.
+func TestTimingAdjustment(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio()) // gripe: 2-7 días, súbito; fiebre severo = 43%
+	fiebre := func(days int, onset string) []SymptomEntry {
+		return []SymptomEntry{{ID: "fiebre", Severity: "severo", Present: true, DurationDays: days, Onset: onset}}
+	}
+	tests := []struct {
+		name     string
+		symptoms []SymptomEntry
+		want     int
+	}{
+		{"sin evolución", fiebre(0, ""), 43},
+		{"duración típica", fiebre(3, ""), 48},
+		{"duración atípica", fiebre(30, ""), 33},
+		{"duración e inicio típicos", fiebre(3, "subito"), 53},
+		{"inicio atípico", fiebre(3, "gradual"), 38},
+		{"síntoma ajeno a la enfermedad", append(fiebre(0, ""), SymptomEntry{ID: "disnea", Severity: "leve", Present: true, DurationDays: 60}), 43},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms}) {
+				if dg := findDiagnosis(resp, "Gripe"); dg == nil || dg.Affinity != tt.want {
+					t.Errorf("%s: Gripe = %+v, want afinidad %d", name, dg, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotInteractions(t *testing.T) {
+	med := func(s *Snapshot, id string) *Medication {
+		for i := range s.Medications {
+			if s.Medications[i].ID == id {
+				return &s.Medications[i]
+			}
+		}
+		return nil
+	}
+	runSnapshotCases(t, []snapshotCase{
+		{"declarada en ambos con la misma severidad", func(s *Snapshot) { med(s, "warfarina").Interactions = map[string]string{"ibuprofeno": "Grave"} }, ""},
+		{"severidad distinta en cada medicamento", func(s *Snapshot) { med(s, "warfarina").Interactions = map[string]string{"ibuprofeno": "leve"} },
+			"interaccion(ibuprofeno,warfarina): severidad distinta"},
+		{"severidad desconocida", func(s *Snapshot) { med(s, "paracetamol").Interactions["warfarina"] = "alta" }, "debe ser leve, moderada o grave"},
+		{"consigo mismo", func(s *Snapshot) { med(s, "paracetamol").Interactions["paracetamol"] = "leve" }, "no puede interactuar consigo mismo"},
+		{"medicamento inexistente", func(s *Snapshot) { med(s, "paracetamol").Interactions["aspirina"] = "leve" }, "interaccion(paracetamol,aspirina): medicamento no existe"},
+	})
+}
+
+func TestDemoteInteracting(t *testing.T) {
+	opts := []TherapyOption{{Drug: "a", Line: 1}, {Drug: "b", Line: 1}, {Drug: "c"}}
+	tests := []struct {
+		name  string
+		inter []DrugInteraction
+		want  []string
+	}{
+		{"sin interacciones", nil, []string{"a", "b", "c"}},
+		{"una al final", []DrugInteraction{{Drug: "a", With: "x", Severity: "leve"}}, []string{"b", "c", "a"}},
+		{"orden estable", []DrugInteraction{{Drug: "b", With: "x", Severity: "leve"}, {Drug: "a", With: "y", Severity: "moderada"}}, []string{"c", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, o := range demoteInteracting(append([]TherapyOption(nil), opts...), tt.inter) {
+				got = append(got, o.Drug)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("demoteInteracting = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Lo que el paciente ya toma (toma/1): grave descarta el medicamento, leve/moderada advierte y lo relega.
+func TestCurrentMedInteractions(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	tests := []struct {
+		name     string
+		disease  string
+		symptoms []SymptomEntry
+		meds     []string
+		options  []string
+		blocked  []string
+		inter    []DrugInteraction
+	}{
+		{"sin medicación", "Gripe", []SymptomEntry{sym("fiebre", "severo")}, nil, []string{"ibuprofeno", "paracetamol"}, nil, nil},
+		{"grave y moderada", "Gripe", []SymptomEntry{sym("fiebre", "severo")}, []string{"warfarina"}, []string{"paracetamol"},
+			[]string{"ibuprofeno"}, []DrugInteraction{{Drug: "paracetamol", With: "warfarina", Severity: "moderada"}}},
+		{"declarada del otro lado", "Neumonía", []SymptomEntry{sym("disnea", "leve")}, []string{"salbutamol"}, []string{"amoxicilina", "azitromicina"},
+			nil, []DrugInteraction{{Drug: "azitromicina", With: "salbutamol", Severity: "moderada"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Age: intp(30), CurrentMeds: tt.meds, Symptoms: tt.symptoms}) {
+				dg := findDiagnosis(resp, tt.disease)
+				if dg == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				var opts, blocked []string
+				for _, o := range dg.TherapyOptions {
+					opts = append(opts, o.Drug)
+				}
+				for _, b := range dg.BlockedDrugs {
+					blocked = append(blocked, b.Drug)
+				}
+				if !reflect.DeepEqual(opts, tt.options) || !reflect.DeepEqual(blocked, tt.blocked) || !reflect.DeepEqual(dg.Interactions, tt.inter) {
+					t.Errorf("%s: opciones %v bloqueados %v interacciones %+v, want %v %v %+v", name, opts, blocked, dg.Interactions, tt.options, tt.blocked, tt.inter)
+				}
+				for _, in := range tt.inter {
+					if w := in.Drug + ": interacción " + in.Severity + " con " + in.With; !contains(dg.Warnings, w) {
+						t.Errorf("%s: falta la advertencia %q", name, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotDosages(t *testing.T) {
+	dose := func(s *Snapshot) *Dosage { return &s.Medications[0].Dosages[0] } // paracetamol, 0-11 años
+	runSnapshotCases(t, []snapshotCase{
+		{"vía por defecto", func(s *Snapshot) { dose(s).Route = "" }, ""},
+		{"unidad desconocida", func(s *Snapshot) { dose(s).Unit = "ml" }, "posología 1: unit debe ser mg o mg_kg"},
+		{"dosis cero", func(s *Snapshot) { dose(s).Dose = 0 }, "dose y max_daily deben ser mayores que 0"},
+		{"intervalo fuera de rango", func(s *Snapshot) { dose(s).EveryHours = 0 }, "every_hours debe estar entre 1 y 48"},
+		{"banda de edad invertida", func(s *Snapshot) { dose(s).AgeMin = 12 }, "rango de edad inválido"},
+		{"banda de peso invertida", func(s *Snapshot) { dose(s).WeightMin, dose(s).WeightMax = 40, 10 }, "rango de peso inválido"},
+	})
+}
+
+func TestNewDosageInfo(t *testing.T) {
+	tests := []struct {
+		dose     float64
+		route    string
+		everyH   int
+		maxDaily float64
+		wantMg   float64
+		wantText string
+	}{
+		{500, "oral", 8, 3000, 500, "500 mg vía oral cada 8 h (máx. 3000 mg/día)"},
+		{342.857, "oral", 7, 1200, 342.9, "342.9 mg vía oral cada 7 h (máx. 1200 mg/día)"},
+		{0.16666, "inhalada", 4, 1, 0.2, "0.2 mg vía inhalada cada 4 h (máx. 1 mg/día)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantText, func(t *testing.T) {
+			d := newDosageInfo("x", tt.dose, tt.route, tt.everyH, tt.maxDaily, false)
+			if d.DoseMg != tt.wantMg || d.Text != tt.wantText {
+				t.Errorf("dosis %v %q, want %v %q", d.DoseMg, d.Text, tt.wantMg, tt.wantText)
+			}
+		})
+	}
+}
+
+// dosis/6: primera banda de posologia/10 que encaja con edad/peso, recortada al máximo diario.
+func TestSuggestedDosage(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	sym := func(id string) []SymptomEntry { return []SymptomEntry{{ID: id, Severity: "severo", Present: true}} }
+	tests := []struct {
+		name    string
+		req     DiagnoseReq
+		disease string
+		want    *DosageInfo // solo Drug, DoseMg, EveryHours y Capped
+	}{
+		{"sin edad: ninguna banda aplica", DiagnoseReq{Symptoms: sym("sibilancias")}, "Asma", nil},
+		{"por debajo de la banda", DiagnoseReq{Age: intp(3), Symptoms: sym("sibilancias")}, "Asma", nil},
+		{"recortada al máximo diario", DiagnoseReq{Age: intp(40), Symptoms: sym("sibilancias")}, "Asma",
+			&DosageInfo{Drug: "salbutamol", DoseMg: 0.2, EveryHours: 4, Capped: true}},
+		{"mg/kg", DiagnoseReq{Age: intp(8), WeightKg: 25, Symptoms: sym("fiebre")}, "Faringitis",
+			&DosageInfo{Drug: "amoxicilina", DoseMg: 625, EveryHours: 8}},
+		{"mg/kg sin peso", DiagnoseReq{Age: intp(8), Symptoms: sym("fiebre")}, "Faringitis", nil},
+		{"mg/kg recortada", DiagnoseReq{Age: intp(40), WeightKg: 90, Symptoms: sym("fiebre")}, "Faringitis",
+			&DosageInfo{Drug: "amoxicilina", DoseMg: 1000, EveryHours: 8, Capped: true}},
+		{"banda por peso", DiagnoseReq{Age: intp(40), WeightKg: 90, Symptoms: sym("fiebre")}, "Gripe",
+			&DosageInfo{Drug: "ibuprofeno", DoseMg: 350, EveryHours: 7, Capped: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, tt.req) {
+				dg := findDiagnosis(resp, tt.disease)
+				if dg == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				got := dg.Dosage
+				if (got == nil) != (tt.want == nil) {
+					t.Fatalf("%s: dosis %+v, want %+v", name, got, tt.want)
+				}
+				if got == nil {
+					continue
+				}
+				if got.Drug != dg.SuggestedDrug || got.Drug != tt.want.Drug || got.DoseMg != tt.want.DoseMg ||
+					got.EveryHours != tt.want.EveryHours || got.Capped != tt.want.Capped {
+					t.Errorf("%s: dosis %+v, want %+v", name, got, tt.want)
+				}
+				if !contains(dg.RulesFired, "dosis/6") {
+					t.Errorf("%s: rules_fired sin dosis/6: %v", name, dg.RulesFired)
+				}
+			}
+		})
+	}
+}
+
+func TestSortTherapyOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []TherapyOption
+		want []string
+	}{
+		{"por línea y rank", []TherapyOption{{Drug: "c", Line: 2, Rank: 1}, {Drug: "b", Line: 1, Rank: 2}, {Drug: "a", Line: 1, Rank: 1}}, []string{"a", "b", "c"}},
+		{"sin línea al final", []TherapyOption{{Drug: "x"}, {Drug: "a", Line: 3, Rank: 1}, {Drug: "y"}}, []string{"a", "x", "y"}},
+		{"empates estables", []TherapyOption{{Drug: "b", Line: 1, Rank: 1}, {Drug: "a", Line: 1, Rank: 1}}, []string{"b", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sortTherapyOptions(tt.in)
+			var got []string
+			for _, o := range tt.in {
+				got = append(got, o.Drug)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orden %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotTherapyLines(t *testing.T) {
+	lines := func(s *Snapshot) map[string]TherapyLine { return s.Medications[2].TreatLines } // amoxicilina
+	runSnapshotCases(t, []snapshotCase{
+		{"rank por defecto", func(s *Snapshot) { lines(s)["neumonia"] = TherapyLine{Line: 1} }, ""},
+		{"línea fuera de rango", func(s *Snapshot) { lines(s)["neumonia"] = TherapyLine{Line: 6, Rank: 1} }, "línea de tratamiento de 'neumonia' debe estar entre 1 y 5"},
+		{"rank fuera de rango", func(s *Snapshot) { lines(s)["neumonia"] = TherapyLine{Line: 1, Rank: 100} }, "rank de 'neumonia' debe estar entre 1 y 99 (0 u omitido = 1)"},
+		{"rank negativo", func(s *Snapshot) { lines(s)["neumonia"] = TherapyLine{Line: 1, Rank: -1} }, "rank de 'neumonia' debe estar entre 1 y 99 (0 u omitido = 1)"},
+		{"enfermedad que no trata", func(s *Snapshot) { lines(s)["gripe"] = TherapyLine{Line: 1, Rank: 1} }, "línea de tratamiento para 'gripe', que no está en treats"},
+	})
+}
+
+// El sugerido es el seguro de mejor línea/rank; las alternativas siguen ese orden.
+func TestTherapyLineRanking(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	neumonia := []SymptomEntry{{ID: "disnea", Severity: "severo", Present: true}}
+	tests := []struct {
+		name    string
+		disease string
+		req     DiagnoseReq
+		options []TherapyOption
+	}{
+		{"primera línea por rank", "Neumonía", DiagnoseReq{Age: intp(30), Symptoms: neumonia},
+			[]TherapyOption{{Drug: "amoxicilina", Line: 1, Rank: 1}, {Drug: "azitromicina", Line: 1, Rank: 2}}},
+		{"la de rank 1 bloqueada", "Neumonía", DiagnoseReq{Age: intp(30), Allergies: []string{"alergia_penicilina"}, Symptoms: neumonia},
+			[]TherapyOption{{Drug: "azitromicina", Line: 1, Rank: 2}}},
+		{"segunda línea antes que sin línea", "Gripe", DiagnoseReq{Age: intp(30), Symptoms: []SymptomEntry{{ID: "fiebre", Severity: "severo", Present: true}}},
+			[]TherapyOption{{Drug: "ibuprofeno", Line: 2, Rank: 1}, {Drug: "paracetamol"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, tt.req) {
+				dg := findDiagnosis(resp, tt.disease)
+				if dg == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				if !reflect.DeepEqual(dg.TherapyOptions, tt.options) || dg.SuggestedDrug != tt.options[0].Drug {
+					t.Errorf("%s: opciones %+v sugerido %q, want %+v", name, dg.TherapyOptions, dg.SuggestedDrug, tt.options)
+				}
+				if !contains(dg.RulesFired, "linea/4") {
+					t.Errorf("%s: rules_fired sin linea/4: %v", name, dg.RulesFired)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSymptomTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		parents  map[string][]string // síntoma -> padres
+		maxDepth int
+		wantErr  string
+	}{
+		{"sin jerarquía", map[string][]string{"a": nil, "b": nil}, 0, ""},
+		{"cadena dentro del tope", map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}}, 2, ""},
+		{"varios padres", map[string][]string{"a": nil, "b": nil, "c": {"a", "b"}}, 1, ""},
+		{"sin tope", map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}, "d": {"c"}}, 0, ""},
+		{"supera el tope", map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}, "d": {"c"}}, 2, "síntoma d: jerarquía de 3 niveles (máximo 2)"},
+		{"su propio padre", map[string][]string{"a": {"a"}}, 0, "síntoma a: no puede ser su propio padre"},
+		{"padre inexistente", map[string][]string{"a": {"z"}}, 0, "síntoma a: padre 'z' no existe"},
+		{"ciclo de dos", map[string][]string{"a": {"b"}, "b": {"a"}}, 0, "jerarquía de síntomas con ciclo: a -> b -> a"},
+		{"ciclo largo", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}, "d": {"a"}}, 0, "ciclo: a -> b -> c -> a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var syms []Symptom
+			symSet := map[string]struct{}{}
+			for _, id := range []string{"a", "b", "c", "d"} {
+				if pars, ok := tt.parents[id]; ok {
+					syms = append(syms, Symptom{ID: id, Parents: pars})
+					symSet[id] = struct{}{}
+				}
+			}
+			err := validateSymptomTree(syms, symSet, tt.maxDepth)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("error inesperado: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotHierarchy(t *testing.T) {
+	// cadena s0 <- s1 <- ... con un nivel más que tope_jerarquia/1
+	chain := func(s *Snapshot, levels int) {
+		for i := 1; i <= levels; i++ {
+			s.Symptoms = append(s.Symptoms, Symptom{ID: fmt.Sprintf("s%d", i), Parents: []string{fmt.Sprintf("s%d", i-1)}})
+		}
+		s.Symptoms = append(s.Symptoms, Symptom{ID: "s0"})
+	}
+	capLevels := engines.current().consts.hierarchyCap
+	runSnapshotCases(t, []snapshotCase{
+		{"padres normalizados", func(s *Snapshot) { s.Symptoms[2].Parents = []string{" Dolor Garganta ", "", "dolor_garganta"} }, ""},
+		{"en el tope", func(s *Snapshot) { chain(s, capLevels) }, ""},
+		{"sobre el tope", func(s *Snapshot) { chain(s, capLevels+1) }, fmt.Sprintf("jerarquía de %d niveles (máximo %d)", capLevels+1, capLevels)},
+		{"padre inexistente", func(s *Snapshot) { s.Symptoms[0].Parents = []string{"calor"} }, "síntoma fiebre: padre 'calor' no existe"},
+		{"ciclo", func(s *Snapshot) { s.Symptoms[6].Parents = []string{"estridor"} }, "ciclo: disnea -> estridor -> sibilancias -> disnea"},
+	})
+}
+
+// Un descendiente presente es evidencia para sus ancestros (estridor -> sibilancias -> disnea);
+// con varios, cuenta el de mayor severidad.
+func TestHierarchyEvidence(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	tests := []struct {
+		name        string
+		symptoms    []SymptomEntry
+		disease     string
+		wantAff     int
+		wantMatched []string
+	}{
+		{"nieto cubre padre y abuelo", []SymptomEntry{sym("estridor", "moderado")}, "Asma", 57, []string{"sibilancias", "disnea"}},
+		{"nieto cubre abuelo de otra enfermedad", []SymptomEntry{sym("estridor", "moderado")}, "Neumonía", 38, []string{"disnea"}},
+		{"hijo cubre padre", []SymptomEntry{sym("tos_seca", "leve")}, "Gripe", 10, []string{"tos"}},
+		{"vale el más severo", []SymptomEntry{sym("sibilancias", "severo"), sym("estridor", "leve")}, "Asma", 86, []string{"sibilancias", "disnea"}},
+		{"el padre no cubre al hijo", []SymptomEntry{sym("disnea", "severo")}, "Asma", 14, []string{"disnea"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms}) {
+				d := findDiagnosis(resp, tt.disease)
+				if d == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				if d.Affinity != tt.wantAff || !reflect.DeepEqual(d.MatchedSymptoms, tt.wantMatched) {
+					t.Errorf("%s: afinidad %d %v, want %d %v", name, d.Affinity, d.MatchedSymptoms, tt.wantAff, tt.wantMatched)
+				}
+			}
+		})
+	}
+}
+
+const hierarchyKB = `sintoma(tos). sintoma(tos_seca). sintoma(fiebre).
+sintoma_padre(tos_seca, tos).
+enfermedad(gripe, "Gripe", respiratorio, viral).
+enfermedad(asma, "Asma", respiratorio, cronico).
+enf_sintoma(gripe, tos, 2). enf_sintoma(gripe, fiebre, 1).
+enf_sintoma(asma, fiebre, 1).
+enf_duracion(gripe, 1, 5).
+`
+
+// En modo bayes el ancestro vinculado aporta su sensibilidad (sin fuga del hijo ni
+// 1 - sensibilidad por el ancestro negado) y la duración del hijo ajusta al ancestro.
+func TestHierarchyBayesAndTiming(t *testing.T) {
+	eng := newKBEngine(1, []byte(hierarchyKB))
+	if eng.err != nil {
+		t.Fatal(eng.err)
+	}
+	tosSeca := func(days int) SymptomEntry {
+		return SymptomEntry{ID: "tos_seca", Severity: "leve", Present: true, DurationDays: days}
+	}
+	tests := []struct {
+		name          string
+		symptoms      []SymptomEntry
+		wantAff       int
+		wantPosterior float64
+	}{
+		// gripe 0.01 x 0.7 frente a asma 0.01 x 0.05 (fuga de tos_seca)
+		{"duración dentro del rango", []SymptomEntry{tosSeca(3), {ID: "tos"}}, 27, 0.9333},
+		{"duración fuera del rango", []SymptomEntry{tosSeca(9)}, 12, 0.9333},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Mode: modeBayes, Symptoms: tt.symptoms}) {
+				d := findDiagnosis(resp, "Gripe")
+				if d == nil {
+					t.Fatalf("%s: falta Gripe", name)
+				}
+				if d.Affinity != tt.wantAff || d.Posterior != tt.wantPosterior {
+					t.Errorf("%s: afinidad %d, posterior %v, want %d, %v", name, d.Affinity, d.Posterior, tt.wantAff, tt.wantPosterior)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotExcludes(t *testing.T) {
+	runSnapshotCases(t, []snapshotCase{
+		{"normaliza", func(s *Snapshot) { disease(s, "faringitis").Excludes = []string{" Rinorrea ", "", "rinorrea"} }, ""},
+		{"descendiente de un síntoma", func(s *Snapshot) { disease(s, "gripe").Excludes = []string{"tos_seca"} }, ""},
+		{"no existe", func(s *Snapshot) { disease(s, "asma").Excludes = []string{"vertigo"} }, "enfermedad asma: síntoma excluyente 'vertigo' no existe"},
+		{"síntoma propio", func(s *Snapshot) { disease(s, "gripe").Excludes = []string{"cefalea"} }, "'cefalea' no puede ser síntoma y excluyente a la vez"},
+		{"ancestro de un síntoma", func(s *Snapshot) {
+			disease(s, "resfriado").Excludes = []string{"disnea"}
+			disease(s, "resfriado").Symptoms = append(disease(s, "resfriado").Symptoms, "estridor")
+		},
+			"'disnea' es excluyente pero su descendiente 'estridor' es síntoma de la enfermedad"},
+	})
+}
+
+// Un hallazgo excluyente presente (o un descendiente suyo) saca a la enfermedad de la
+// lista y la informa en "excluded" con su motivo.
+func TestExcludedDiseases(t *testing.T) {
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	tests := []struct {
+		name     string
+		edit     func(s *Snapshot)
+		symptoms []SymptomEntry
+		want     []string // disease: síntomas
+		reason   string   // motivo del primero
+	}{
+		{"sin excluyentes presentes", func(s *Snapshot) {}, []SymptomEntry{sym("sibilancias", "severo")}, nil, ""},
+		{"negado no excluye", func(s *Snapshot) {}, []SymptomEntry{sym("sibilancias", "severo"), {ID: "fiebre"}}, nil, ""},
+		{"excluyente presente", func(s *Snapshot) {}, []SymptomEntry{sym("sibilancias", "severo"), sym("fiebre", "leve")},
+			[]string{"Asma: fiebre"}, "Asma descartada: presenta fiebre"},
+		{"dos enfermedades", func(s *Snapshot) {}, []SymptomEntry{sym("sibilancias", "severo"), sym("fiebre", "leve"), sym("rinorrea", "leve"), sym("dolor_garganta", "leve")},
+			[]string{"Asma: fiebre", "Faringitis: rinorrea"}, "Asma descartada: presenta fiebre"},
+		{"por un descendiente", func(s *Snapshot) { disease(s, "resfriado").Excludes = []string{"disnea"} }, []SymptomEntry{sym("cefalea", "severo"), sym("estridor", "leve")},
+			[]string{"Resfriado: disnea"}, "Resfriado descartada: presenta disnea"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := fixtureRespiratorio()
+			tt.edit(&snap)
+			eng := snapshotEngine(t, snap)
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms}) {
+				var got []string
+				for _, ex := range resp.Excluded {
+					got = append(got, ex.Disease+": "+strings.Join(ex.Symptoms, ", "))
+					if findDiagnosis(resp, ex.Disease) != nil {
+						t.Errorf("%s: %s excluida sigue en diagnoses", name, ex.Disease)
+					}
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: excluidas %v, want %v", name, got, tt.want)
+				}
+				if len(resp.Excluded) > 0 && resp.Excluded[0].Reason != tt.reason {
+					t.Errorf("%s: motivo %q, want %q", name, resp.Excluded[0].Reason, tt.reason)
+				}
+			}
+		})
+	}
+}
+
+// La exclusión se revisa antes de la afinidad mínima: se informa aunque no llegue al mínimo.
+func TestExcludedBelowMinAffinity(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	req := DiagnoseReq{
+		Symptoms: []SymptomEntry{{ID: "rinorrea", Severity: "leve", Present: true}, {ID: "tos", Severity: "leve", Present: true}},
+		Options:  DiagnoseOptions{MinAffinity: 60},
+	}
+	for name, resp := range bothEngines(t, eng, req) {
+		if len(resp.Excluded) != 1 || resp.Excluded[0].Disease != "Faringitis" || resp.Excluded[0].Affinity != 0 {
+			t.Errorf("%s: excluidas %+v, want Faringitis con afinidad 0", name, resp.Excluded)
+		}
+	}
+}
+
+func TestValidateSnapshotRequired(t *testing.T) {
+	runSnapshotCases(t, []snapshotCase{
+		{"normaliza", func(s *Snapshot) { disease(s, "gripe").Required = []string{" Fiebre ", "", "fiebre", "tos"} }, ""},
+		{"sin requeridos", func(s *Snapshot) { disease(s, "asma").Required = nil }, ""},
+		{"no es síntoma de la enfermedad", func(s *Snapshot) { disease(s, "gripe").Required = []string{"disnea"} },
+			"enfermedad gripe: síntoma requerido 'disnea', que no es síntoma de la enfermedad"},
+		{"descendiente de un síntoma", func(s *Snapshot) { disease(s, "gripe").Required = []string{"tos_seca"} }, "síntoma requerido 'tos_seca'"},
+	})
+}
+
+// Sin un requerido (ni un descendiente suyo) la afinidad queda con tope y se informa.
+func TestMissingRequired(t *testing.T) {
+	eng := snapshotEngine(t, fixtureRespiratorio())
+	sym := func(id, sev string) SymptomEntry { return SymptomEntry{ID: id, Severity: sev, Present: true} }
+	tests := []struct {
+		name        string
+		symptoms    []SymptomEntry
+		disease     string
+		wantMissing []string
+	}{
+		{"presente", []SymptomEntry{sym("fiebre", "leve"), sym("tos", "severo")}, "Gripe", nil},
+		{"faltante", []SymptomEntry{sym("tos", "severo"), sym("cefalea", "severo")}, "Gripe", []string{"fiebre"}},
+		{"negado", []SymptomEntry{sym("tos", "severo"), {ID: "fiebre"}}, "Gripe", []string{"fiebre"}},
+		{"cubierto por un descendiente", []SymptomEntry{sym("estridor", "severo"), sym("tos", "leve")}, "Asma", nil},
+		{"el padre no lo cubre", []SymptomEntry{sym("disnea", "severo"), sym("tos", "leve")}, "Asma", []string{"sibilancias"}},
+	}
+	const capLevel = 25 // tope_requerido/1 de rules.pl (ver TestRequiredCapFromRules)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, resp := range bothEngines(t, eng, DiagnoseReq{Symptoms: tt.symptoms}) {
+				d := findDiagnosis(resp, tt.disease)
+				if d == nil {
+					t.Fatalf("%s: falta %s", name, tt.disease)
+				}
+				if !reflect.DeepEqual(d.MissingRequired, tt.wantMissing) {
+					t.Errorf("%s: faltan %v, want %v", name, d.MissingRequired, tt.wantMissing)
+				}
+				if tt.wantMissing != nil && d.Affinity > capLevel {
+					t.Errorf("%s: afinidad %d sobre el tope %d", name, d.Affinity, capLevel)
+				}
+			}
+		})
+	}
+}
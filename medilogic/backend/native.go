@@ -0,0 +1,831 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ===========================================================
+   Motor nativo (Go puro): mismo resultado que rules.pl
+   =========================================================== */
+
+// nativeEngine evalúa los hechos de la KB (kb.facts()) sin intérprete: útil como
+// referencia rápida y para detectar divergencias con rules.pl (differential_test.go).
+// Las constantes y valores por defecto (pesos, prior, sensibilidad, topes) salen de
+// las reglas compiladas, no se repiten acá.
+type nativeEngine struct{}
+
+func (nativeEngine) Name() string { return "nativo" }
+
+func (nativeEngine) Diagnose(ctx context.Context, kb *kbEngine, req DiagnoseReq) (DiagnoseResp, error) {
+	f, err := kb.facts()
+	if err != nil {
+		var le *LimitError
+		if errors.As(err, &le) {
+			return DiagnoseResp{}, le
+		}
+		log.Println("native engine error:", err)
+		return DiagnoseResp{}, fmt.Errorf("prolog engine error")
+	}
+	x := &nativeKB{f}
+	return x.diagnose(ctx, newNativeSession(req), req)
+}
+
+func (nativeEngine) NextQuestions(ctx context.Context, kb *kbEngine, req DiagnoseReq, n int) (NextQuestionResp, error) {
+	f, err := kb.facts()
+	if err != nil {
+		var le *LimitError
+		if errors.As(err, &le) {
+			return NextQuestionResp{}, le
+		}
+		log.Println("native engine error:", err)
+		return NextQuestionResp{}, fmt.Errorf("prolog engine error")
+	}
+	x := &nativeKB{f}
+	return x.nextQuestions(ctx, newNativeSession(req), req, n)
+}
+
+/* ---------- Hechos de sesión (como los aserta assertSession) ---------- */
+
+type nativeObs struct {
+	S string
+	W int
+}
+
+type nativeSession struct {
+	present   []nativeObs // presente/2, en el orden del request (con repetidos)
+	absent    []string    // ausente/1
+	duration  []nativeObs // duracion(S, Dias)
+	onset     map[string][]string
+	allergies []string
+	chronics  []string
+	meds      []string // toma/1
+	age       *int
+	pregnant  bool
+	weight    float64 // 0 = sin peso_kg/1
+
+	ev map[string]int // presente_ev/2: máximo peso de S o de un descendiente
+}
+
+func newNativeSession(req DiagnoseReq) *nativeSession {
+	s := &nativeSession{onset: map[string][]string{}, age: req.Age, pregnant: req.Pregnant, weight: req.WeightKg}
+	for _, e := range req.Symptoms {
+		id := safeAtom(e.ID)
+		if !e.Present {
+			s.absent = append(s.absent, id)
+			continue
+		}
+		s.present = append(s.present, nativeObs{S: id, W: severityWeight(e.Severity)})
+		if e.DurationDays > 0 {
+			s.duration = append(s.duration, nativeObs{S: id, W: e.DurationDays})
+		}
+		if e.Onset != "" {
+			s.onset[id] = append(s.onset[id], e.Onset)
+		}
+	}
+	for _, a := range req.Allergies {
+		s.allergies = append(s.allergies, safeAtom(a))
+	}
+	for _, c := range req.Chronics {
+		s.chronics = append(s.chronics, safeAtom(c))
+	}
+	for _, m := range req.CurrentMeds {
+		s.meds = append(s.meds, safeAtom(m))
+	}
+	return s
+}
+
+// presentCount: soluciones de presente(S, _)
+func (s *nativeSession) presentCount(id string) int {
+	n := 0
+	for _, o := range s.present {
+		if o.S == id {
+			n++
+		}
+	}
+	return n
+}
+
+/* ---------- KB indexada ---------- */
+
+// nativeKB: hechos de una versión de la KB, en el orden de sus cláusulas.
+type nativeKB struct {
+	*kbFacts
+}
+
+// evidence calcula presente_ev/2 (máximo peso por síntoma, contando descendientes).
+func (x *nativeKB) evidence(s *nativeSession) {
+	s.ev = map[string]int{}
+	note := func(sym string, w int) {
+		if p, ok := s.ev[sym]; !ok || w > p {
+			s.ev[sym] = w
+		}
+	}
+	for _, o := range s.present {
+		note(o.S, o.W)
+		for _, a := range x.ancestors[o.S] {
+			note(a, o.W)
+		}
+	}
+}
+
+// interacts: interactua/3 (los hechos A-B y luego, si A \== B, los B-A).
+func (x *nativeKB) interacts(a, b string) []string {
+	var out []string
+	for _, it := range x.inter {
+		if it.A == a && it.B == b {
+			out = append(out, it.Sev)
+		}
+	}
+	if a != b {
+		for _, it := range x.inter {
+			if it.A == b && it.B == a {
+				out = append(out, it.Sev)
+			}
+		}
+	}
+	return out
+}
+
+/* ---------- afinidad/3 ---------- */
+
+// reqs: reqs_enf/2 (síntomas únicos y ordenados)
+func (x *nativeKB) reqs(enf string) []string {
+	out := uniq(x.links[enf])
+	sort.Strings(out)
+	return out
+}
+
+func (x *nativeKB) weight(enf, sym string) int {
+	return x.weights[[2]string{enf, sym}]
+}
+
+// negated: negados_enf/2 (uno por cada ausente/1, sin descendiente presente)
+func (x *nativeKB) negated(enf string, s *nativeSession) []deniedPair {
+	var out []deniedPair
+	for _, sym := range x.reqs(enf) {
+		if _, ok := s.ev[sym]; ok {
+			continue
+		}
+		for _, a := range s.absent {
+			if a == sym {
+				out = append(out, deniedPair{S: sym, Pen: x.penalty[enf], W: x.weight(enf, sym)})
+			}
+		}
+	}
+	return out
+}
+
+func (x *nativeKB) affinity(enf string, s *nativeSession) int {
+	top := x.maxScore[enf]
+	if top == 0 {
+		return 0
+	}
+	reqs := x.reqs(enf)
+	score := 0
+	for _, sym := range reqs {
+		if p, ok := s.ev[sym]; ok {
+			score += p * x.weight(enf, sym)
+		}
+	}
+	pen := 0
+	for _, n := range x.negated(enf, s) {
+		pen += n.Pen * n.W
+	}
+	// ajuste_sintoma/4: cada enf_duracion/enf_inicio x síntoma con duracion_ev/inicio_ev
+	adj := 0
+	pts := func(ok bool) int {
+		if ok {
+			return x.timeBonus
+		}
+		return -x.timePenalty
+	}
+	for _, du := range x.durations[enf] {
+		for _, sym := range reqs {
+			if d, ok := x.durationEv(sym, s); ok {
+				adj += pts(d >= du[0] && d <= du[1])
+			}
+		}
+	}
+	for _, on := range x.onsets[enf] {
+		for _, sym := range reqs {
+			if i, ok := x.onsetEv(sym, s); ok {
+				adj += pts(i == on)
+			}
+		}
+	}
+	a := min(max(int(math.Round(float64(score)*100/float64(top)))-pen+adj, 0), 100)
+	// tope_afinidad/3
+	if len(x.missingRequired(enf, s)) > 0 && a > x.requiredCap {
+		return x.requiredCap
+	}
+	return a
+}
+
+// missingRequired: falta_requerido/2 (requeridos sin evidencia, en el orden de enf_requerido/2)
+func (x *nativeKB) missingRequired(enf string, s *nativeSession) []string {
+	var out []string
+	for _, sym := range x.required[enf] {
+		if _, ok := s.ev[sym]; !ok && !contains(out, sym) {
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+// excluded: excluida/2 (hallazgos de enf_excluye/2 con evidencia presente)
+func (x *nativeKB) excluded(enf string, s *nativeSession) []string {
+	var out []string
+	for _, sym := range x.excludes[enf] {
+		if _, ok := s.ev[sym]; ok && !contains(out, sym) {
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+// durationEv: primera solución de duracion_ev/2 (la del síntoma presente o la del
+// primer descendiente presente, en el orden de presente/2, que la informe)
+func (x *nativeKB) durationEv(sym string, s *nativeSession) (int, bool) {
+	if s.presentCount(sym) > 0 {
+		for _, d := range s.duration {
+			if d.S == sym {
+				return d.W, true
+			}
+		}
+	}
+	for _, o := range s.present {
+		if !contains(x.ancestors[o.S], sym) {
+			continue
+		}
+		for _, d := range s.duration {
+			if d.S == o.S {
+				return d.W, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// onsetEv: primera solución de inicio_ev/2
+func (x *nativeKB) onsetEv(sym string, s *nativeSession) (string, bool) {
+	if s.presentCount(sym) > 0 && len(s.onset[sym]) > 0 {
+		return s.onset[sym][0], true
+	}
+	for _, o := range s.present {
+		if contains(x.ancestors[o.S], sym) && len(s.onset[o.S]) > 0 {
+			return s.onset[o.S][0], true
+		}
+	}
+	return "", false
+}
+
+/* ---------- modo bayes ---------- */
+
+// ageOut: fuera_rango_edad/3 (el primer enf_edad/3 que deja afuera la edad del paciente)
+func (x *nativeKB) ageOut(enf string, s *nativeSession) (int, int, bool) {
+	if s.age == nil {
+		return 0, 0, false
+	}
+	for _, r := range x.ages[enf] {
+		if *s.age < r[0] || *s.age > r[1] {
+			return r[0], r[1], true
+		}
+	}
+	return 0, 0, false
+}
+
+// bayesScore: puntaje_bayes/2 (prior x producto de factores, en el mismo orden que prod_list_/2)
+func (x *nativeKB) bayesScore(enf string, s *nativeSession) float64 {
+	prior := x.prior[enf]
+	if _, _, out := x.ageOut(enf, s); out {
+		prior *= x.ageFactor
+	}
+	// factor_bayes/3 sobre presente_ev/2: un ancestro vinculado aporta su sensibilidad y
+	// la fuga solo la suma un síntoma informado sin vínculo propio ni de sus ancestros
+	var fs []float64
+	ps := make([]string, 0, len(s.ev))
+	for sym := range s.ev {
+		ps = append(ps, sym)
+	}
+	sort.Strings(ps)
+	for _, sym := range ps {
+		if contains(x.links[enf], sym) {
+			fs = append(fs, x.sens[[2]string{enf, sym}])
+		} else if s.presentCount(sym) > 0 && !x.ancestorLinked(enf, sym) {
+			fs = append(fs, x.leak)
+		}
+	}
+	as := uniq(s.absent)
+	sort.Strings(as)
+	for _, sym := range as {
+		if _, ok := s.ev[sym]; ok {
+			continue
+		}
+		for _, l := range x.links[enf] { // un factor por cada enf_sintoma(Enf, S)
+			if l == sym {
+				fs = append(fs, 1-x.sens[[2]string{enf, sym}])
+			}
+		}
+	}
+	l := 1.0
+	for i := len(fs) - 1; i >= 0; i-- {
+		l = fs[i] * l
+	}
+	return prior * l
+}
+
+// ancestorLinked: ancestro(S, A), enf_sintoma(Enf, A)
+func (x *nativeKB) ancestorLinked(enf, sym string) bool {
+	for _, a := range x.ancestors[sym] {
+		if contains(x.links[enf], a) {
+			return true
+		}
+	}
+	return false
+}
+
+/* ---------- urgencia/1 y urgencia/2 ---------- */
+
+// flagActive: bandera_activa/3 (todos los síntomas con peso >= Min; devuelve el menor)
+func (x *nativeKB) flagActive(f RedFlag, s *nativeSession) (int, bool) {
+	if len(f.Symptoms) == 0 {
+		return 0, false
+	}
+	low := 0
+	for i, sym := range f.Symptoms {
+		p, ok := s.ev[sym]
+		if !ok || p < f.MinSeverity {
+			return 0, false
+		}
+		if i == 0 || p < low {
+			low = p
+		}
+	}
+	return low, true
+}
+
+// urgency: urgencia_motivo/4, primera solución recorriendo nivel_urgencia/2
+func (x *nativeKB) urgency(s *nativeSession) urgencyTrace {
+	for _, lvl := range x.levels {
+		for _, f := range x.flags {
+			if f.Level != lvl {
+				continue
+			}
+			if p, ok := x.flagActive(f, s); ok {
+				return urgencyTrace{Level: lvl, Rule: "bandera_roja", Symptom: f.ID, Value: p}
+			}
+		}
+		switch lvl {
+		case "Consulta recomendada":
+			for _, o := range s.present {
+				if o.W >= 3 {
+					return urgencyTrace{Level: lvl, Rule: "sintoma_severo", Symptom: o.S, Value: o.W}
+				}
+			}
+			if n := len(s.present); n >= 3 {
+				return urgencyTrace{Level: lvl, Rule: "conteo_sintomas", Symptom: "sintomas", Value: n}
+			}
+		case "Observación recomendada":
+			return urgencyTrace{Level: lvl, Rule: "caso_base", Symptom: "ninguno"}
+		}
+	}
+	return urgencyTrace{Level: "Observación recomendada", Rule: "caso_base", Symptom: "ninguno"}
+}
+
+// matches: coincidencias_enf/2
+func (x *nativeKB) matches(enf string, s *nativeSession) int {
+	n := 0
+	for _, sym := range uniq(x.links[enf]) {
+		if _, ok := s.ev[sym]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// diseaseUrgency: urgencia_enf_motivo/5, primera solución recorriendo nivel_urgencia/2
+func (x *nativeKB) diseaseUrgency(enf string, s *nativeSession) urgencyTrace {
+	for _, lvl := range x.levels {
+		if lvl == "Atención prioritaria" {
+			for _, ef := range x.enfFlags[enf] {
+				if p, ok := s.ev[ef.S]; ok && p >= ef.Min {
+					return urgencyTrace{Level: lvl, Rule: "bandera_roja_enf", Symptom: ef.S, Value: p}
+				}
+			}
+		}
+		for _, f := range x.flags {
+			if f.Level != lvl {
+				continue
+			}
+			own := false
+			for _, sym := range f.Symptoms {
+				if contains(x.links[enf], sym) {
+					own = true
+					break
+				}
+			}
+			if !own {
+				continue
+			}
+			if p, ok := x.flagActive(f, s); ok {
+				return urgencyTrace{Level: lvl, Rule: "bandera_roja", Symptom: f.ID, Value: p}
+			}
+		}
+		switch lvl {
+		case "Consulta recomendada":
+			for _, sym := range x.links[enf] {
+				if p, ok := s.ev[sym]; ok && p >= 3 {
+					return urgencyTrace{Level: lvl, Rule: "sintoma_severo", Symptom: sym, Value: p}
+				}
+			}
+			n := x.matches(enf, s)
+			for _, d := range x.diseases {
+				if d.ID == enf && contains(x.consultTypes, d.Type) && n >= 1 {
+					return urgencyTrace{Level: lvl, Rule: "tipo_enfermedad", Symptom: d.Type, Value: n}
+				}
+			}
+			if n >= 3 {
+				return urgencyTrace{Level: lvl, Rule: "conteo_sintomas", Symptom: "sintomas", Value: n}
+			}
+		case "Observación recomendada":
+			return urgencyTrace{Level: lvl, Rule: "caso_base", Symptom: "ninguno"}
+		}
+	}
+	return urgencyTrace{Level: "Observación recomendada", Rule: "caso_base", Symptom: "ninguno"}
+}
+
+/* ---------- medicamento_seguro/2 y motivo_bloqueo/4 ---------- */
+
+// ageBlocked: bloqueado_por_edad/1 (algún edad_minima/2 por encima de la edad)
+func (x *nativeKB) ageBlocked(med string, s *nativeSession) bool {
+	if s.age == nil {
+		return false
+	}
+	for _, m := range x.minAge[med] {
+		if *s.age < m {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *nativeKB) graveWith(med string, s *nativeSession) bool {
+	for _, t := range s.meds {
+		for _, sev := range x.interacts(med, t) {
+			if sev == "grave" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (x *nativeKB) safe(enf, med string, s *nativeSession) bool {
+	for _, a := range s.allergies {
+		if contains(x.contra[med], a) {
+			return false
+		}
+	}
+	for _, c := range s.chronics {
+		if contains(x.contra[med], c) {
+			return false
+		}
+	}
+	if contains(x.contraEnf[enf], med) || x.ageBlocked(med, s) {
+		return false
+	}
+	if s.pregnant && x.pregnancy[med] {
+		return false
+	}
+	return !x.graveWith(med, s)
+}
+
+// blocked: motivo_bloqueo/4 en el orden de sus cláusulas, sin repetidos
+func (x *nativeKB) blocked(enf string, s *nativeSession) []blockReason {
+	treat := x.treats[enf]
+	var out []blockReason
+	seen := map[blockReason]bool{}
+	add := func(b blockReason) {
+		if !seen[b] {
+			seen[b] = true
+			out = append(out, b)
+		}
+	}
+	for _, m := range treat {
+		for _, a := range s.allergies {
+			if contains(x.contra[m], a) {
+				add(blockReason{Med: m, Kind: "alergia", Cond: a})
+			}
+		}
+	}
+	for _, m := range treat {
+		for _, c := range s.chronics {
+			if contains(x.contra[m], c) {
+				add(blockReason{Med: m, Kind: "cronica", Cond: c})
+			}
+		}
+	}
+	for _, m := range treat {
+		if contains(x.contraEnf[enf], m) {
+			add(blockReason{Med: m, Kind: "enfermedad", Cond: enf})
+		}
+	}
+	for _, m := range treat {
+		if x.ageBlocked(m, s) {
+			for _, min := range x.minAge[m] { // Cond = cada edad_minima/2 de Med
+				add(blockReason{Med: m, Kind: "edad", Cond: strconv.Itoa(min)})
+			}
+		}
+	}
+	for _, m := range treat {
+		if s.pregnant && x.pregnancy[m] {
+			add(blockReason{Med: m, Kind: "embarazo", Cond: "embarazo"})
+		}
+	}
+	for _, m := range treat {
+		for _, t := range s.meds {
+			for _, sev := range x.interacts(m, t) {
+				if sev == "grave" {
+					add(blockReason{Med: m, Kind: "interaccion", Cond: t})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// therapy: seguros (orden de trata/2) -> línea/rank, alerta_interaccion/4 e interacciones al final
+func (x *nativeKB) therapy(enf string, s *nativeSession) ([]TherapyOption, []DrugInteraction) {
+	var opts []TherapyOption
+	var inter []DrugInteraction
+	for _, m := range x.treats[enf] {
+		if !x.safe(enf, m, s) {
+			continue
+		}
+		o := TherapyOption{Drug: m}
+		if tl, ok := x.lines[[2]string{m, enf}]; ok {
+			o.Line, o.Rank = tl.Line, tl.Rank
+		}
+		opts = append(opts, o)
+		for _, t := range s.meds {
+			for _, sev := range x.interacts(m, t) {
+				if sev != "grave" {
+					inter = append(inter, DrugInteraction{Drug: m, With: t, Severity: sev})
+				}
+			}
+		}
+	}
+	if opts == nil {
+		opts = []TherapyOption{}
+	}
+	sortTherapyOptions(opts)
+	return demoteInteracting(opts, inter), inter
+}
+
+/* ---------- dosis/6 ---------- */
+
+// inBand: en_banda/3 (0/0 = sin restricción; Max 0 = sin tope)
+func inBand(lo, hi float64, v float64, known bool) bool {
+	if lo == 0 && hi == 0 {
+		return true
+	}
+	return known && v >= lo && (hi == 0 || v <= hi)
+}
+
+func (x *nativeKB) dosage(med string, s *nativeSession) *DosageInfo {
+	age := 0.0
+	if s.age != nil {
+		age = float64(*s.age)
+	}
+	for _, d := range x.dosages[med] {
+		if !inBand(d.ageMin, d.ageMax, age, s.age != nil) ||
+			!inBand(d.weightMin, d.weightMax, s.weight, s.weight > 0) {
+			continue
+		}
+		dose := d.dose
+		switch d.unit {
+		case "mg":
+		case "mg_kg":
+			if s.weight <= 0 {
+				continue
+			}
+			dose = d.dose * s.weight
+		default:
+			continue
+		}
+		if d.everyHours == 0 { // 24 / 0 es un error de evaluación: dosis/6 no tiene solución
+			return nil
+		}
+		doses := 24 / float64(d.everyHours)
+		capped := dose*doses > d.maxDaily
+		if capped {
+			dose = d.maxDaily / doses
+		}
+		return newDosageInfo(med, dose, d.route, d.everyHours, d.maxDaily, capped)
+	}
+	return nil
+}
+
+/* ---------- diagnóstico ---------- */
+
+// diagnose replica diagnose() de main.go paso a paso (mismo orden, filtros y desempates).
+func (x *nativeKB) diagnose(ctx context.Context, s *nativeSession, req DiagnoseReq) (DiagnoseResp, error) {
+	x.evidence(s)
+	gt := x.urgency(s)
+	triage := Triage{Level: gt.Level, Rule: gt.Rule}
+	if gt.Rule != "caso_base" {
+		triage.Symptom = gt.Symptom
+	}
+	mode := req.Mode
+	if mode == "" {
+		mode = modeAffinity
+	}
+	opt := req.Options
+	var systems, types []string
+	for _, v := range opt.Systems {
+		if strings.TrimSpace(v) != "" {
+			systems = append(systems, safeAtom(v))
+		}
+	}
+	for _, v := range opt.Types {
+		if strings.TrimSpace(v) != "" {
+			types = append(types, safeAtom(v))
+		}
+	}
+
+	type row struct {
+		d   diseaseFact
+		aff int
+	}
+	// la posterior se normaliza entre todas las enfermedades, antes de filtrar
+	scores := map[string]float64{}
+	total := 0.0
+	if mode == modeBayes {
+		for _, d := range x.diseases {
+			sc := x.bayesScore(d.ID, s)
+			scores[d.ID] = sc
+			total += sc
+		}
+	}
+	var rows []row
+	var excluded []ExcludedDisease
+	for _, d := range x.diseases {
+		if ctx.Err() != nil {
+			return DiagnoseResp{}, &LimitError{Kind: "cancelado"}
+		}
+		if (len(systems) > 0 && !contains(systems, d.System)) || (len(types) > 0 && !contains(types, d.Type)) {
+			continue
+		}
+		if opt.SkipZero && x.matches(d.ID, s) == 0 {
+			continue
+		}
+		aff := x.affinity(d.ID, s)
+		if ex := x.excluded(d.ID, s); len(ex) > 0 {
+			excluded = append(excluded, excludedDisease(d.Name, aff, ex))
+			continue
+		}
+		if aff < opt.MinAffinity {
+			continue
+		}
+		rows = append(rows, row{d: d, aff: aff})
+	}
+
+	posteriors := make([]float64, len(rows))
+	if total > 0 {
+		for i, r := range rows {
+			posteriors[i] = math.Round(scores[r.d.ID]/total*10000) / 10000
+		}
+	}
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		if mode == modeBayes && posteriors[idx[a]] != posteriors[idx[b]] {
+			return posteriors[idx[a]] > posteriors[idx[b]]
+		}
+		return rows[idx[a]].aff > rows[idx[b]].aff
+	})
+	if opt.Limit > 0 && len(idx) > opt.Limit {
+		idx = idx[:opt.Limit]
+	}
+
+	resp := DiagnoseResp{Mode: mode}
+	for _, i := range idx {
+		d := rows[i].d
+		var matched []string
+		for _, sym := range uniq(x.links[d.ID]) {
+			if _, ok := s.ev[sym]; ok {
+				matched = append(matched, sym)
+			}
+		}
+		opts, inter := x.therapy(d.ID, s)
+		ut := x.diseaseUrgency(d.ID, s)
+		if urgencyRank(ut.Level) > urgencyRank(triage.Level) {
+			triage = Triage{Level: ut.Level, Rule: ut.Rule, Symptom: ut.Symptom, Disease: d.ID}
+		}
+		var dosage *DosageInfo
+		if len(opts) > 0 {
+			dosage = x.dosage(opts[0].Drug, s)
+		}
+		lo, hi, ageOut := x.ageOut(d.ID, s)
+		resp.Diagnoses = append(resp.Diagnoses, diagnosisParts{
+			name: d.Name, aff: rows[i].aff, posterior: posteriors[i], matched: matched,
+			opts: opts, inter: inter, blocked: x.blocked(d.ID, s), denied: x.negated(d.ID, s), urgency: ut.Level,
+			dosage: dosage, ageOut: ageOut, ageMin: lo, ageMax: hi, missing: x.missingRequired(d.ID, s), reqCap: x.requiredCap,
+		}.diagnosis(mode))
+	}
+	resp.Triage = triage
+	resp.Excluded = excluded
+	resp.Explanations = "Diagnóstico realizado con el motor nativo: afinidad/3, urgencia/2 y medicamento_seguro/2."
+	return resp, nil
+}
+
+/* ---------- próxima pregunta ---------- */
+
+// unasked: sin_preguntar/1 (sin evidencia ni ausente/1)
+func (x *nativeKB) unasked(sym string, s *nativeSession) bool {
+	_, ok := s.ev[sym]
+	return !ok && !contains(s.absent, sym)
+}
+
+// nextQuestions replica nextQuestions() de question.go (mismos filtros, orden y empates).
+func (x *nativeKB) nextQuestions(ctx context.Context, s *nativeSession, req DiagnoseReq, n int) (NextQuestionResp, error) {
+	x.evidence(s)
+	opt := req.Options
+	var systems, types []string
+	for _, v := range opt.Systems {
+		if strings.TrimSpace(v) != "" {
+			systems = append(systems, safeAtom(v))
+		}
+	}
+	for _, v := range opt.Types {
+		if strings.TrimSpace(v) != "" {
+			types = append(types, safeAtom(v))
+		}
+	}
+	var cands []QuestionCandidate
+	for _, d := range x.diseases {
+		if ctx.Err() != nil {
+			return NextQuestionResp{}, &LimitError{Kind: "cancelado"}
+		}
+		if (len(systems) > 0 && !contains(systems, d.System)) || (len(types) > 0 && !contains(types, d.Type)) {
+			continue
+		}
+		if opt.SkipZero && x.matches(d.ID, s) == 0 {
+			continue
+		}
+		if len(x.excluded(d.ID, s)) > 0 {
+			continue
+		}
+		if opt.MinAffinity > 0 && x.affinity(d.ID, s) < opt.MinAffinity {
+			continue
+		}
+		cands = append(cands, QuestionCandidate{ID: d.ID, Disease: d.Name, Posterior: x.bayesScore(d.ID, s)})
+	}
+
+	cands, prior := leadingCandidates(cands, opt.Limit)
+	resp := NextQuestionResp{Candidates: cands, Entropy: round4(entropy(prior)), Questions: []Question{}}
+	if len(cands) < 2 {
+		return resp, nil
+	}
+
+	// prob_sintoma/3: sensibilidad si el síntoma está vinculado, si no la fuga
+	probs := map[string][]float64{}
+	linked := map[string][]string{}
+	var order []string
+	for i, c := range cands {
+		for _, sym := range x.symptoms {
+			if !x.unasked(sym, s) {
+				continue
+			}
+			if _, ok := probs[sym]; !ok {
+				probs[sym] = make([]float64, len(cands))
+				order = append(order, sym)
+			}
+			if contains(x.links[c.ID], sym) {
+				probs[sym][i] = x.sens[[2]string{c.ID, sym}]
+			} else {
+				probs[sym][i] = x.leak
+			}
+		}
+		// enf_sintoma(Enf, S), sin_preguntar(S): una solución por vínculo y por sintoma/1
+		for _, l := range x.links[c.ID] {
+			for _, sym := range x.symptoms {
+				if sym == l && x.unasked(sym, s) {
+					linked[sym] = append(linked[sym], c.ID)
+				}
+			}
+		}
+	}
+	resp.Questions = rankQuestions(prior, order, probs, linked, n)
+	return resp, nil
+}
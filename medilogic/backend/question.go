@@ -0,0 +1,246 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+/* ===========================================================
+   Siguiente mejor pregunta (/api/next-question)
+   =========================================================== */
+
+// Candidatas que se separan por defecto (options.limit las cambia)
+const nextQuestionCandidates = 5
+
+type NextQuestionResp struct {
+	Candidates []QuestionCandidate `json:"candidates"` // enfermedades líderes con su posterior
+	Entropy    float64             `json:"entropy"`    // incertidumbre actual en bits
+	Questions  []Question          `json:"questions"`  // síntomas sin preguntar, mayor ganancia primero
+}
+type QuestionCandidate struct {
+	ID        string  `json:"id"`
+	Disease   string  `json:"disease"`
+	Posterior float64 `json:"posterior"`
+}
+type Question struct {
+	Symptom  string   `json:"symptom"`
+	Gain     float64  `json:"gain"`     // ganancia de información esperada (bits)
+	PYes     float64  `json:"p_yes"`    // probabilidad de que el paciente responda que sí
+	Diseases []string `json:"diseases"` // candidatas que tienen el síntoma en enf_sintoma
+}
+
+// POST /api/next-question (mismo body que /api/diagnose; ?questions=N, por defecto 5)
+func handleNextQuestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := readDiagnoseReq(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := 5
+	if v := r.URL.Query().Get("questions"); v != "" {
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "questions inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp, err := activeEngine.NextQuestions(r.Context(), engines.current(), req, n)
+	if err != nil {
+		writePrologError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// NextQuestions toma un intérprete del pool, aserta la sesión y ordena las preguntas
+// dentro de los mismos límites que Diagnose.
+func (prologEngine) NextQuestions(ctx context.Context, kb *kbEngine, req DiagnoseReq, n int) (NextQuestionResp, error) {
+	p, err := kb.acquire(ctx)
+	if err != nil {
+		log.Println("prolog engine error:", err)
+		return NextQuestionResp{}, fmt.Errorf("prolog engine error")
+	}
+	defer kb.release(p)
+	if err := assertSession(p, req); err != nil {
+		if le := p.limitErr(); le != nil {
+			return NextQuestionResp{}, le
+		}
+		log.Println("assert session facts error:", err)
+		return NextQuestionResp{}, fmt.Errorf("prolog assert error")
+	}
+	resp, err := nextQuestions(p, req, n)
+	if le := p.limitErr(); le != nil {
+		return NextQuestionResp{}, le
+	}
+	return resp, err
+}
+
+// nextQuestions ordena los síntomas sin preguntar por la ganancia de información
+// que aportan sobre las enfermedades líderes (posterior del modo bayes).
+func nextQuestions(p *session, req DiagnoseReq, n int) (NextQuestionResp, error) {
+	opt := req.Options
+	goal := fmt.Sprintf(`enf_candidata(Enf, Nombre, %s, %s)`, plAtomList(opt.Systems), plAtomList(opt.Types))
+	if opt.SkipZero {
+		goal += `, con_coincidencia(Enf)`
+	}
+	// las descartadas por enf_excluye/2 no compiten por la próxima pregunta
+	goal += `, \+ excluida(Enf, _)`
+	if opt.MinAffinity > 0 {
+		goal += fmt.Sprintf(`, afinidad(Enf, A, _), A >= %d`, opt.MinAffinity)
+	}
+	q, err := p.Query(goal + `, puntaje_bayes(Enf, Sc).`)
+	if err != nil {
+		return NextQuestionResp{}, fmt.Errorf("query enfermedad/4 failed")
+	}
+	var cands []QuestionCandidate
+	for q.Next() {
+		var row struct {
+			Enf, Nombre string
+			Sc          float64
+		}
+		if err := q.Scan(&row); err == nil {
+			cands = append(cands, QuestionCandidate{ID: row.Enf, Disease: row.Nombre, Posterior: row.Sc})
+		}
+	}
+	q.Close()
+
+	cands, prior := leadingCandidates(cands, opt.Limit)
+	resp := NextQuestionResp{Candidates: cands, Entropy: round4(entropy(prior)), Questions: []Question{}}
+	if len(cands) < 2 {
+		return resp, nil
+	}
+
+	// P(S | Enf) de cada síntoma sin preguntar para cada líder
+	probs := map[string][]float64{}
+	linked := map[string][]string{}
+	var order []string
+	for i, c := range cands {
+		q, err := p.Query(fmt.Sprintf(`sin_preguntar(S), prob_sintoma(%s, S, X0), X is float(X0).`, safeAtom(c.ID)))
+		if err != nil {
+			continue
+		}
+		for q.Next() {
+			var row struct {
+				S string
+				X float64
+			}
+			if err := q.Scan(&row); err != nil {
+				continue
+			}
+			if _, ok := probs[row.S]; !ok {
+				probs[row.S] = make([]float64, len(cands))
+				order = append(order, row.S)
+			}
+			probs[row.S][i] = row.X
+		}
+		q.Close()
+		if q, err := p.Query(fmt.Sprintf(`enf_sintoma(%s, S), sin_preguntar(S).`, safeAtom(c.ID))); err == nil {
+			for q.Next() {
+				var row struct{ S string }
+				if err := q.Scan(&row); err == nil {
+					linked[row.S] = append(linked[row.S], c.ID)
+				}
+			}
+			q.Close()
+		}
+	}
+	resp.Questions = rankQuestions(prior, order, probs, linked, n)
+	return resp, nil
+}
+
+// leadingCandidates deja las top-K por puntaje bayes (options.limit o 5) y renormaliza
+// su posterior entre ellas; devuelve también esa distribución sin redondear.
+func leadingCandidates(cands []QuestionCandidate, limit int) ([]QuestionCandidate, []float64) {
+	sort.SliceStable(cands, func(a, b int) bool { return cands[a].Posterior > cands[b].Posterior })
+	k := nextQuestionCandidates
+	if limit > 0 {
+		k = limit
+	}
+	if len(cands) > k {
+		cands = cands[:k]
+	}
+	total := 0.0
+	for _, c := range cands {
+		total += c.Posterior
+	}
+	prior := make([]float64, len(cands))
+	for i := range cands {
+		if total > 0 {
+			prior[i] = cands[i].Posterior / total
+		} else {
+			prior[i] = 1 / float64(len(cands))
+		}
+		cands[i].Posterior = math.Round(prior[i]*10000) / 10000
+	}
+	return cands, prior
+}
+
+// rankQuestions: IG(S) = H(D) - [P(sí) H(D|sí) + P(no) H(D|no)] de cada síntoma sin
+// preguntar (order) que tenga alguna líder; probs[S][i] = P(S | líder i).
+func rankQuestions(prior []float64, order []string, probs map[string][]float64, linked map[string][]string, n int) []Question {
+	out := []Question{}
+	h := entropy(prior)
+	for _, s := range order {
+		if len(linked[s]) == 0 {
+			continue // ninguna líder lo tiene: no separa
+		}
+		px := probs[s]
+		yes := make([]float64, len(prior))
+		no := make([]float64, len(prior))
+		pYes := 0.0
+		for i := range prior {
+			yes[i] = prior[i] * px[i]
+			no[i] = prior[i] * (1 - px[i])
+			pYes += yes[i]
+		}
+		gain := h - pYes*entropy(normalize(yes)) - (1-pYes)*entropy(normalize(no))
+		out = append(out, Question{
+			Symptom: s, Gain: round4(gain), PYes: round4(pYes), Diseases: linked[s],
+		})
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].Gain > out[b].Gain })
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// entropy en bits de una distribución (ignora ceros).
+func entropy(ps []float64) float64 {
+	h := 0.0
+	for _, x := range ps {
+		if x > 0 {
+			h -= x * math.Log2(x)
+		}
+	}
+	return h
+}
+
+func normalize(xs []float64) []float64 {
+	t := 0.0
+	for _, x := range xs {
+		t += x
+	}
+	out := make([]float64, len(xs))
+	if t == 0 {
+		return out
+	}
+	for i, x := range xs {
+		out[i] = x / t
+	}
+	return out
+}
+
+func round4(x float64) float64 { return math.Round(x*10000) / 10000 }
@@ -0,0 +1,136 @@
+//go:build !rpa
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLeadingCandidates(t *testing.T) {
+	cand := func(id string, p float64) QuestionCandidate { return QuestionCandidate{ID: id, Posterior: p} }
+	tests := []struct {
+		name      string
+		cands     []QuestionCandidate
+		limit     int
+		wantIDs   []string
+		wantPrior []float64
+	}{
+		{"ordena y renormaliza", []QuestionCandidate{cand("a", 0.1), cand("b", 0.3)}, 0, []string{"b", "a"}, []float64{0.75, 0.25}},
+		{"options.limit", []QuestionCandidate{cand("a", 0.1), cand("b", 0.3), cand("c", 0.6)}, 2, []string{"c", "b"}, []float64{2.0 / 3, 1.0 / 3}},
+		{"tope por defecto", []QuestionCandidate{cand("a", 6), cand("b", 5), cand("c", 4), cand("d", 3), cand("e", 2), cand("f", 1)}, 0,
+			[]string{"a", "b", "c", "d", "e"}, []float64{0.3, 0.25, 0.2, 0.15, 0.1}},
+		{"puntajes en cero", []QuestionCandidate{cand("a", 0), cand("b", 0)}, 0, []string{"a", "b"}, []float64{0.5, 0.5}},
+		{"sin candidatas", nil, 0, nil, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, prior := leadingCandidates(tt.cands, tt.limit)
+			var ids []string
+			for _, c := range got {
+				ids = append(ids, c.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Fatalf("candidatas = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range prior {
+				if round4(prior[i]) != round4(tt.wantPrior[i]) || got[i].Posterior != round4(tt.wantPrior[i]) {
+					t.Errorf("prior[%d] = %v (posterior %v), want %v", i, prior[i], got[i].Posterior, tt.wantPrior[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRankQuestions(t *testing.T) {
+	prior := []float64{0.5, 0.5}
+	probs := map[string][]float64{
+		"separa":  {1, 0},       // responde exactamente quién es: 1 bit
+		"parcial": {0.9, 0.1},   // separa a medias
+		"comun":   {0.7, 0.7},   // igual en ambas: no aporta
+		"ajeno":   {0.05, 0.05}, // ninguna líder lo tiene
+	}
+	linked := map[string][]string{"separa": {"a"}, "parcial": {"a", "b"}, "comun": {"a", "b"}}
+	order := []string{"comun", "ajeno", "parcial", "separa"}
+	tests := []struct {
+		name string
+		n    int
+		want []Question
+	}{
+		{"todas", 5, []Question{
+			{Symptom: "separa", Gain: 1, PYes: 0.5, Diseases: []string{"a"}},
+			{Symptom: "parcial", Gain: 0.531, PYes: 0.5, Diseases: []string{"a", "b"}},
+			{Symptom: "comun", Gain: 0, PYes: 0.7, Diseases: []string{"a", "b"}},
+		}},
+		{"recorta a n", 1, []Question{{Symptom: "separa", Gain: 1, PYes: 0.5, Diseases: []string{"a"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankQuestions(prior, order, probs, linked, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rankQuestions = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+const questionKB = `sintoma(tos). sintoma(fiebre). sintoma(disnea). sintoma(rinorrea).
+enfermedad(gripe, "Gripe", respiratorio, viral).
+enfermedad(resfrio, "Resfrío", respiratorio, viral).
+enfermedad(asma, "Asma", respiratorio, cronico).
+enf_sintoma(gripe, tos, 2). enf_sintoma(gripe, fiebre, 3).
+enf_sintoma(resfrio, tos, 2). enf_sintoma(resfrio, rinorrea, 2).
+enf_sintoma(asma, disnea, 3). enf_sintoma(asma, tos, 1).
+enf_excluye(asma, rinorrea).
+`
+
+// options.min_affinity y enf_excluye/2 recortan las líderes igual en ambos motores.
+func TestNextQuestionsCandidates(t *testing.T) {
+	eng := newKBEngine(1, []byte(questionKB))
+	if eng.err != nil {
+		t.Fatal(eng.err)
+	}
+	tos := SymptomEntry{ID: "tos", Severity: "moderado", Present: true}
+	tests := []struct {
+		name     string
+		req      DiagnoseReq
+		wantIDs  []string
+		wantAsks bool
+	}{
+		{"sin filtros", DiagnoseReq{Symptoms: []SymptomEntry{tos}}, []string{"gripe", "resfrio", "asma"}, true},
+		{"min_affinity", DiagnoseReq{Symptoms: []SymptomEntry{tos}, Options: DiagnoseOptions{MinAffinity: 20}}, []string{"gripe", "resfrio"}, true},
+		{"una sola líder", DiagnoseReq{Symptoms: []SymptomEntry{tos}, Options: DiagnoseOptions{MinAffinity: 30}}, []string{"resfrio"}, false},
+		{"excluida", DiagnoseReq{Symptoms: []SymptomEntry{tos, {ID: "rinorrea", Severity: "leve", Present: true}}}, []string{"resfrio", "gripe"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, de := range []DiagnosisEngine{prologEngine{}, nativeEngine{}} {
+				resp, err := de.NextQuestions(context.Background(), eng, tt.req, 3)
+				if err != nil {
+					t.Fatalf("%s: %v", de.Name(), err)
+				}
+				var ids []string
+				for _, c := range resp.Candidates {
+					ids = append(ids, c.ID)
+				}
+				if !sameSet(ids, tt.wantIDs) {
+					t.Errorf("%s: candidatas = %v, want %v", de.Name(), ids, tt.wantIDs)
+				}
+				if got := len(resp.Questions) > 0; got != tt.wantAsks {
+					t.Errorf("%s: preguntas = %+v", de.Name(), resp.Questions)
+				}
+			}
+		})
+	}
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		if !contains(b, x) {
+			return false
+		}
+	}
+	return true
+}
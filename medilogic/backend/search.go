@@ -0,0 +1,208 @@
+//go:build !rpa
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* ===========================================================
+   Búsqueda de síntomas (/api/symptoms/search)
+   =========================================================== */
+
+// Puntaje mínimo (0..1) para devolver un síntoma
+const minSearchScore = 0.6
+
+// Palabras que no aportan al comparar frases del paciente
+var stopwords = map[string]bool{
+	"a": true, "al": true, "con": true, "de": true, "del": true, "el": true, "en": true,
+	"es": true, "la": true, "las": true, "lo": true, "los": true, "me": true, "mi": true,
+	"muy": true, "siento": true, "su": true, "tengo": true, "un": true, "una": true, "y": true,
+}
+
+type SymptomMatch struct {
+	ID      string  `json:"id"`
+	Label   string  `json:"label,omitempty"`
+	Score   float64 `json:"score"`   // 0..1
+	Matched string  `json:"matched"` // id, etiqueta o sinónimo que coincidió
+}
+
+// GET /api/symptoms/search?q=me duele la garganta&limit=5
+func handleSymptomSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "missing ?q=", http.StatusBadRequest)
+		return
+	}
+	limit := 5
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit inválido", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	snap := engines.current().snapshot() // KB ya cargada (sin leer ni parsear el archivo)
+	res := searchSymptoms(snap.Symptoms, q)
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"query": q, "results": res})
+}
+
+// searchSymptoms compara el texto con el id, la etiqueta y los sinónimos de cada síntoma
+// (sin acentos ni mayúsculas, tolerando errores de tipeo) y ordena por puntaje.
+func searchSymptoms(syms []Symptom, text string) []SymptomMatch {
+	query := foldText(text)
+	qTokens := contentTokens(query)
+	out := []SymptomMatch{}
+	for _, s := range syms {
+		best := SymptomMatch{ID: s.ID, Label: s.Label}
+		phrases := append([]string{s.ID, s.Label}, s.Synonyms...)
+		for _, ph := range phrases {
+			if ph == "" {
+				continue
+			}
+			if sc := phraseScore(query, qTokens, foldText(ph)); sc > best.Score {
+				best.Score, best.Matched = sc, ph
+			}
+		}
+		if best.Score >= minSearchScore {
+			best.Score = round4(best.Score)
+			out = append(out, best)
+		}
+	}
+	sort.SliceStable(out, func(a, b int) bool {
+		if out[a].Score != out[b].Score {
+			return out[a].Score > out[b].Score
+		}
+		return out[a].ID < out[b].ID
+	})
+	return out
+}
+
+// phraseScore: 1 si es idéntica, 0.95 si la frase aparece completa en el texto;
+// si no, cuánto de la frase cubre el texto (x 0.9) o, con menos peso, cuánto
+// del texto cubre la frase (el paciente escribió solo parte, ej. "cabeza").
+func phraseScore(query string, qTokens []string, phrase string) float64 {
+	if phrase == "" {
+		return 0
+	}
+	if query == phrase {
+		return 1
+	}
+	if strings.Contains(" "+query+" ", " "+phrase+" ") {
+		return 0.95
+	}
+	pTokens := contentTokens(phrase)
+	if len(pTokens) == 0 || len(qTokens) == 0 {
+		return 0
+	}
+	return 0.9 * max(coverage(pTokens, qTokens), 0.85*coverage(qTokens, pTokens))
+}
+
+// coverage: promedio, para cada palabra de want, de su mejor parecido en have.
+func coverage(want, have []string) float64 {
+	total := 0.0
+	for _, wt := range want {
+		best := 0.0
+		for _, ht := range have {
+			if sc := tokenScore(ht, wt); sc > best {
+				best = sc
+			}
+		}
+		total += best
+	}
+	return total / float64(len(want))
+}
+
+// tokenScore: igualdad, prefijo (palabra incompleta) o distancia de edición acotada.
+func tokenScore(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) >= 4 && strings.HasPrefix(b, a) {
+		return 0.9
+	}
+	maxTypos := 1
+	if len(rb) > 5 {
+		maxTypos = 2
+	}
+	d := levenshtein(ra, rb)
+	if d > maxTypos {
+		return 0
+	}
+	n := len(ra)
+	if len(rb) > n {
+		n = len(rb)
+	}
+	return 1 - float64(d)/float64(n)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// foldText: minúsculas, sin acentos, "_" y signos como espacio, espacios simples.
+func foldText(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch r {
+		case 'á', 'à', 'ä', 'â':
+			r = 'a'
+		case 'é', 'è', 'ë', 'ê':
+			r = 'e'
+		case 'í', 'ì', 'ï', 'î':
+			r = 'i'
+		case 'ó', 'ò', 'ö', 'ô':
+			r = 'o'
+		case 'ú', 'ù', 'ü', 'û':
+			r = 'u'
+		case 'ñ':
+			r = 'n'
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// contentTokens: palabras del texto ya normalizado, sin stopwords.
+func contentTokens(folded string) []string {
+	var out []string
+	for _, t := range strings.Fields(folded) {
+		if !stopwords[t] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+//go:build !rpa
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"tos", "", 3},
+		{"", "tos", 3},
+		{"fiebre", "fiebre", 0},
+		{"fiebre", "fibre", 1},
+		{"garganta", "grganta", 1},
+		{"cabeza", "cabesa", 1},
+		{"nausea", "nauseas", 1},
+		{"mareo", "marea", 1},
+		{"dolor", "olor", 1},
+		{"tos", "sot", 2},
+		{"náusea", "nausea", 1}, // por runas, no bytes
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := levenshtein([]rune(tt.a), []rune(tt.b)); got != tt.want {
+				t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFoldText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Dolor de CABEZA", "dolor de cabeza"},
+		{"náuseas, vómitos!", "nauseas vomitos"},
+		{"dolor_garganta", "dolor garganta"},
+		{"  mucha   tos\n", "mucha tos"},
+		{"Año 2024", "ano 2024"},
+	}
+	for _, tt := range tests {
+		if got := foldText(tt.in); got != tt.want {
+			t.Errorf("foldText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTokenScore(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"fiebre", "fiebre", 1},
+		{"gargan", "garganta", 0.9}, // prefijo de 4+ letras
+		{"gar", "garganta", 0},      // prefijo corto
+		{"fibre", "fiebre", 1 - 1.0/6},
+		{"cabesa", "cabeza", 1 - 1.0/6},
+		{"toz", "tos", 1 - 1.0/3},
+		{"tox", "sol", 0},            // 2 errores en palabra corta
+		{"dolr", "dolor", 1 - 1.0/5}, // 1 error tolerado hasta 5 letras
+		{"grgnta", "garganta", 0.75}, // 2 errores en palabra larga
+		{"grgta", "garganta", 0},     // 3 errores
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := tokenScore(tt.a, tt.b); round4(got) != round4(tt.want) {
+				t.Errorf("tokenScore(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSymptoms(t *testing.T) {
+	syms := []Symptom{
+		{ID: "fiebre", Label: "Fiebre", Synonyms: []string{"temperatura alta", "calentura"}},
+		{ID: "cefalea", Label: "Dolor de cabeza", Synonyms: []string{"jaqueca"}},
+		{ID: "dolor_garganta", Label: "Dolor de garganta"},
+		{ID: "tos"},
+		{ID: "nauseas", Label: "Náuseas", Synonyms: []string{"ganas de vomitar"}},
+		{ID: "mareo", Label: "Vértigo"},
+	}
+	tests := []struct {
+		name    string
+		text    string
+		want    []string // ids en orden
+		matched string   // frase que coincidió en el primero
+	}{
+		{"id exacto", "tos", []string{"tos"}, "tos"},
+		{"etiqueta con acentos", "VERTIGO", []string{"mareo"}, "Vértigo"},
+		{"sinónimo dentro de una frase", "tengo la temperatura alta desde ayer", []string{"fiebre"}, "temperatura alta"},
+		{"error de tipeo", "calentra", []string{"fiebre"}, "calentura"},
+		{"parte de la frase con error", "cabesa", []string{"cefalea"}, "Dolor de cabeza"},
+		{"empate por id", "dolor", []string{"cefalea", "dolor_garganta"}, "Dolor de cabeza"},
+		{"sin coincidencias", "sarpullido", []string{}, ""},
+		{"solo stopwords", "tengo una", []string{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := searchSymptoms(syms, tt.text)
+			ids := []string{}
+			for _, m := range res {
+				ids = append(ids, m.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Fatalf("searchSymptoms(%q) = %+v, want %v", tt.text, res, tt.want)
+			}
+			if len(res) > 0 && res[0].Matched != tt.matched {
+				t.Errorf("matched = %q, want %q", res[0].Matched, tt.matched)
+			}
+			for _, m := range res {
+				if m.Score < minSearchScore || m.Score > 1 {
+					t.Errorf("%s: puntaje %v fuera de rango", m.ID, m.Score)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotSynonyms(t *testing.T) {
+	syn := func(s *Snapshot, i int, v ...string) { s.Symptoms[i].Synonyms = v }
+	runSnapshotCases(t, []snapshotCase{
+		{"válidos", func(s *Snapshot) { syn(s, 0, "calentura", "temperatura alta"); syn(s, 1, "tos seca") }, ""},
+		{"repetido en el mismo síntoma", func(s *Snapshot) { syn(s, 0, "calentura", "Calentura") }, ""},
+		{"con comillas", func(s *Snapshot) { syn(s, 0, `dice "calor"`) }, "sinónimo 'dice \"calor\"' inválido"},
+		{"con barra invertida", func(s *Snapshot) { syn(s, 0, `a\b`) }, "inválido"},
+		{"demasiado largo", func(s *Snapshot) { syn(s, 0, strings.Repeat("a", 81)) }, "máx. 80 caracteres"},
+		{"repetido en dos síntomas", func(s *Snapshot) { syn(s, 0, "ahogo"); syn(s, 6, "AHOGO") }, "sinónimo 'AHOGO' repetido en fiebre y disnea"},
+		{"repetido sin acentos", func(s *Snapshot) { syn(s, 0, "sofocación"); syn(s, 6, "sofocacion") }, "repetido en fiebre y disnea"},
+	})
+
+	snap := fixtureRespiratorio()
+	snap.Symptoms[0].Synonyms = []string{"  temperatura   alta ", "", "Temperatura alta", "calentura"}
+	if err := validateSnapshot(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := snap.Symptoms[0].Synonyms, []string{"temperatura alta", "calentura"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sinónimos normalizados %q, want %q", got, want)
+	}
+}
+
+func TestSymptomSearchHandler(t *testing.T) {
+	tests := []struct {
+		method, query string
+		wantCode      int
+		wantMax       int // resultados como máximo
+	}{
+		{"GET", "?q=fiebre", http.StatusOK, 5},
+		{"GET", "?q=dolor&limit=1", http.StatusOK, 1},
+		{"GET", "?q=%20%20", http.StatusBadRequest, 0},
+		{"GET", "", http.StatusBadRequest, 0},
+		{"GET", "?q=tos&limit=0", http.StatusBadRequest, 0},
+		{"GET", "?q=tos&limit=x", http.StatusBadRequest, 0},
+		{"POST", "?q=tos", http.StatusMethodNotAllowed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleSymptomSearch(w, httptest.NewRequest(tt.method, "/api/symptoms/search"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d (%s), want %d", w.Code, w.Body, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var body struct {
+				Results []SymptomMatch `json:"results"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Results) == 0 || len(body.Results) > tt.wantMax {
+				t.Errorf("%d resultados, want entre 1 y %d", len(body.Results), tt.wantMax)
+			}
+		})
+	}
+}